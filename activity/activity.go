@@ -0,0 +1,265 @@
+// Package activity tells who is actually playing on NINJAM server and who only listens or is away.
+//
+// Monitor gets users' intervals from intervals.Assembler, decodes every interval and measures its
+// RMS and peak level. User plays if the loudest of his channels was louder than threshold in one of the
+// last two intervals: interval is downloaded after it's played, so the last one may be still on the way.
+// Users without channels, users who upload silence and users who don't upload at all are idle.
+//
+// When user who played stays silent for AFK intervals, Monitor sends Silence, router sends its Text to chats.
+// Downloading all users' audio uses as much traffic as a listening client.
+package activity
+
+import (
+	"context"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultThreshold - interval with RMS level below it in dBFS is silent
+	DefaultThreshold = -50.0
+
+	// checkPeriod - how often silence of users is checked
+	checkPeriod = time.Second
+)
+
+// UserActivity is what Monitor knows about user
+type UserActivity struct {
+	User            models.User
+	Level           Level // the loudest channel of the last downloaded interval
+	Playing         bool
+	SilentIntervals int // intervals since the user played or joined
+}
+
+// Silence is sent when user who played hasn't played for Intervals intervals
+type Silence struct {
+	User      string
+	Intervals int
+}
+
+// Text returns AFK notice for chats, server is the name of user's server
+func (s Silence) Text(server string) string {
+	return fmt.Sprintf("%s, похоже, отошёл: молчит на джем-сервере %s, интервалов без звука: %d", s.User, server, s.Intervals)
+}
+
+// Monitor measures loudness of all users of bot's server
+type Monitor struct {
+	bot          *ninjam_bot.NinJamBot
+	intervals    *intervals.Assembler
+	events       <-chan intervals.Event
+	now          func() time.Time
+	threshold    float64
+	afkIntervals int
+	silences     chan Silence
+
+	mutex sync.Mutex
+	bpm   uint
+	bpi   uint
+	users map[string]*userState
+}
+
+type userState struct {
+	user     models.User
+	since    time.Time // when the user joined or played the last time
+	played   bool      // the user played since joined
+	level    Level
+	levelAt  time.Time // интервалы разных каналов одного пользователя приходят по очереди
+	notified bool      // Silence is sent for current silence
+}
+
+// New creates monitor of assembler's server, it subscribes to assembler's events, so it must be created
+// before bot connects
+func New(assembler *intervals.Assembler) *Monitor {
+	return &Monitor{
+		bot:       assembler.Bot(),
+		intervals: assembler,
+		events:    assembler.Subscribe(),
+		now:       time.Now,
+		threshold: DefaultThreshold,
+		silences:  make(chan Silence, 100),
+		users:     make(map[string]*userState),
+	}
+}
+
+// Threshold sets RMS level in dBFS below which interval is silent, DefaultThreshold by default
+func (m *Monitor) Threshold(threshold float64) {
+	m.threshold = threshold
+}
+
+// AFK sets number of silent intervals after which Silence is sent, 0 (by default) disables it
+func (m *Monitor) AFK(intervals int) {
+	m.afkIntervals = intervals
+}
+
+// Silences returns channel of Silence notifications, notifications are dropped when nobody reads it
+func (m *Monitor) Silences() <-chan Silence {
+	return m.silences
+}
+
+// Run handles events and keeps the bot subscribed to all users' audio until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	m.intervals.Acquire()
+	defer m.intervals.Release()
+	defer m.intervals.Unsubscribe(m.events)
+
+	ticker := time.NewTicker(checkPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-m.events:
+			m.handle(event)
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+// Users returns activity of server's users sorted by name
+func (m *Monitor) Users() []UserActivity {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	users := make([]UserActivity, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, m.activity(u))
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].User.Name < users[j].User.Name
+	})
+
+	return users
+}
+
+// Playing returns whether user with name plays
+func (m *Monitor) Playing(name string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	u, ok := m.users[name]
+	if !ok {
+		return false
+	}
+
+	return m.activity(u).Playing
+}
+
+func (m *Monitor) handle(event intervals.Event) {
+	// интервал декодируется без блокировки, чтобы не задерживать Users и Playing
+	if interval, ok := event.(*intervals.Interval); ok {
+		m.measure(interval)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch event := event.(type) {
+	case ninjam_bot.ConfigChangeEvent:
+		m.bpm, m.bpi = event.BPM, event.BPI
+	case ninjam_bot.UserJoinedEvent:
+		m.users[event.User.Name] = &userState{user: event.User, since: m.now(), level: Level{RMS: MinLevel, Peak: MinLevel}}
+	case ninjam_bot.UserChannelsChangedEvent:
+		if u, ok := m.users[event.User.Name]; ok {
+			u.user = event.User
+		}
+	case ninjam_bot.UserLeftEvent:
+		delete(m.users, event.User.Name)
+	case ninjam_bot.DisconnectEvent:
+		// пользователей сервер пришлёт заново после входа
+		m.users = make(map[string]*userState)
+	}
+}
+
+func (m *Monitor) measure(interval *intervals.Interval) {
+	level, err := decodeLevel(interval)
+	if err != nil {
+		logrus.Warnf("Activity monitor %s:%s can't decode interval of %s: %s", m.bot.Host(), m.bot.Port(), interval.User, err)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	u, ok := m.users[interval.User]
+	if !ok {
+		return
+	}
+
+	// уровень пользователя - самый громкий из его каналов в последнем интервале
+	now := m.now()
+	if length := m.intervalLength(); length == 0 || now.Sub(u.levelAt) >= length/2 || level.RMS > u.level.RMS {
+		u.level = level
+		u.levelAt = now
+	}
+
+	if level.RMS > m.threshold {
+		u.since = now
+		u.played = true
+		u.notified = false
+	}
+}
+
+// check sends Silence for users who stopped playing
+func (m *Monitor) check() {
+	if m.afkIntervals <= 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, u := range m.users {
+		if !u.played || u.notified {
+			continue
+		}
+
+		silent := m.silentIntervals(u)
+		if silent < m.afkIntervals {
+			continue
+		}
+		u.notified = true
+
+		select {
+		case m.silences <- Silence{User: u.user.Name, Intervals: silent}:
+		default:
+			logrus.Warnf("Activity monitor %s:%s drops silence of %s", m.bot.Host(), m.bot.Port(), u.user.Name)
+		}
+	}
+}
+
+func (m *Monitor) activity(u *userState) UserActivity {
+	silent := m.silentIntervals(u)
+
+	return UserActivity{
+		User:            u.user,
+		Level:           u.level,
+		Playing:         u.played && silent < 2,
+		SilentIntervals: silent,
+	}
+}
+
+func (m *Monitor) silentIntervals(u *userState) int {
+	length := m.intervalLength()
+	if length == 0 {
+		return 0
+	}
+
+	return int(m.now().Sub(u.since) / length)
+}
+
+func (m *Monitor) intervalLength() time.Duration {
+	if m.bpm == 0 || m.bpi == 0 {
+		return 0
+	}
+
+	return time.Minute * time.Duration(m.bpi) / time.Duration(m.bpm)
+}
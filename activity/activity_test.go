@@ -0,0 +1,91 @@
+package activity
+
+import (
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var (
+	guitar   = models.User{Name: "Vasya@127.0.0.x", Channels: []models.Channel{{Index: 0, Name: "guitar"}}}
+	listener = models.User{Name: "Petya@127.0.0.x"}
+	start    = time.Date(2026, 10, 18, 20, 30, 0, 0, time.UTC)
+)
+
+func interval(userName string, data []byte) *intervals.Interval {
+	return &intervals.Interval{User: userName, Channel: models.Channel{Index: 0}, Data: data}
+}
+
+func TestMonitor(t *testing.T) {
+	sound := ninjamtest.Sound()
+
+	m := New(intervals.New(ninjam_bot.New("127.0.0.1", "2049")))
+	m.AFK(3)
+	now := start
+	m.now = func() time.Time { return now }
+
+	// 120 BPM, 16 BPI - интервал 8 секунд
+	m.handle(ninjam_bot.ConfigChangeEvent{BPM: 120, BPI: 16})
+	m.handle(ninjam_bot.UserJoinedEvent{User: guitar})
+	m.handle(ninjam_bot.UserJoinedEvent{User: listener})
+
+	now = now.Add(time.Second * 8)
+	m.handle(interval(guitar.Name, sound))
+	// битый интервал не меняет уровень
+	m.handle(interval(guitar.Name, []byte("OggS")))
+
+	users := m.Users()
+	require.Len(t, users, 2)
+	assert.Equal(t, listener, users[0].User)
+	assert.False(t, users[0].Playing)
+	assert.Equal(t, Level{RMS: MinLevel, Peak: MinLevel}, users[0].Level)
+	assert.Equal(t, 1, users[0].SilentIntervals)
+
+	assert.Equal(t, guitar, users[1].User)
+	assert.True(t, users[1].Playing)
+	assert.True(t, users[1].Level.RMS > DefaultThreshold)
+	assert.Equal(t, 0, users[1].SilentIntervals)
+
+	assert.True(t, m.Playing(guitar.Name))
+	assert.False(t, m.Playing(listener.Name))
+	assert.False(t, m.Playing("Sidor@127.0.0.x"))
+
+	// тихих интервалов сборщик не присылает, через два интервала пользователь уже не играет
+	now = now.Add(time.Second * 12)
+	assert.True(t, m.Playing(guitar.Name))
+	now = now.Add(time.Second * 4)
+	assert.False(t, m.Playing(guitar.Name))
+
+	m.check()
+	assert.Empty(t, m.Silences())
+
+	// после трёх тихих интервалов - AFK, только один раз и только для игравших
+	now = now.Add(time.Second * 8)
+	m.check()
+	m.check()
+	require.Len(t, m.Silences(), 1)
+	silence := <-m.Silences()
+	assert.Equal(t, Silence{User: guitar.Name, Intervals: 3}, silence)
+	assert.Equal(t, "Vasya@127.0.0.x, похоже, отошёл: молчит на джем-сервере 127.0.0.1:2049, интервалов без звука: 3", silence.Text("127.0.0.1:2049"))
+
+	// снова играет - снова может быть AFK
+	m.handle(interval(guitar.Name, sound))
+	assert.True(t, m.Playing(guitar.Name))
+	now = now.Add(time.Second * 24)
+	m.check()
+	assert.Len(t, m.Silences(), 1)
+
+	m.handle(ninjam_bot.UserLeftEvent{User: listener})
+	assert.Len(t, m.Users(), 1)
+
+	// после переподключения пользователи приходят заново
+	m.handle(ninjam_bot.DisconnectEvent{})
+	assert.Empty(t, m.Users())
+	m.handle(ninjam_bot.UserJoinedEvent{User: guitar})
+	assert.False(t, m.Playing(guitar.Name))
+}
@@ -0,0 +1,54 @@
+package activity
+
+import (
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"math"
+)
+
+// MinLevel is the level of digital silence in dBFS
+const MinLevel = -120.0
+
+// Level is loudness of one interval
+type Level struct {
+	RMS  float64 // dBFS
+	Peak float64 // dBFS
+}
+
+// measure returns level of samples of all channels
+func measure(samples []float32) Level {
+	var sum, peak float64
+	for _, sample := range samples {
+		s := math.Abs(float64(sample))
+		sum += s * s
+		if s > peak {
+			peak = s
+		}
+	}
+
+	if len(samples) == 0 {
+		return Level{RMS: MinLevel, Peak: MinLevel}
+	}
+
+	return Level{
+		RMS:  toDBFS(math.Sqrt(sum / float64(len(samples)))),
+		Peak: toDBFS(peak),
+	}
+}
+
+// decodeLevel decodes interval and returns its level
+func decodeLevel(interval *intervals.Interval) (Level, error) {
+	samples, _, err := interval.Decode()
+	if err != nil {
+		return Level{}, err
+	}
+
+	return measure(samples), nil
+}
+
+func toDBFS(amplitude float64) float64 {
+	if amplitude <= 0 {
+		return MinLevel
+	}
+
+	return math.Max(MinLevel, 20*math.Log10(amplitude))
+}
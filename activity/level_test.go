@@ -0,0 +1,33 @@
+package activity
+
+import (
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	level := measure([]float32{0.5, -0.5, 0.5, -0.5})
+	assert.InDelta(t, -6.02, level.RMS, 0.01)
+	assert.InDelta(t, -6.02, level.Peak, 0.01)
+
+	level = measure([]float32{1, 0, 0, 0})
+	assert.InDelta(t, -6.02, level.RMS, 0.01)
+	assert.InDelta(t, 0, level.Peak, 0.01)
+
+	assert.Equal(t, Level{RMS: MinLevel, Peak: MinLevel}, measure([]float32{0, 0}))
+	assert.Equal(t, Level{RMS: MinLevel, Peak: MinLevel}, measure(nil))
+}
+
+func TestDecodeLevel(t *testing.T) {
+	level, err := decodeLevel(&intervals.Interval{Data: ninjamtest.Sound()})
+	require.NoError(t, err)
+	assert.True(t, level.RMS > DefaultThreshold, "RMS %f", level.RMS)
+	assert.True(t, level.Peak >= level.RMS)
+	assert.True(t, level.Peak <= 0)
+
+	_, err = decodeLevel(&intervals.Interval{Data: []byte("OggS")})
+	assert.Error(t, err)
+}
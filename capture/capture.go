@@ -0,0 +1,207 @@
+// Package capture writes NINJAM protocol traffic to a file and reads it back.
+//
+// Capture file starts with the magic string, then records follow one by one:
+// timestamp (unix nanoseconds, int64 LE), direction (1 byte) and the frame as it was sent over network:
+// message type (1 byte), payload length (uint32 LE) and payload.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const magic = "NJCAP1\n"
+
+// header: timestamp (8 bytes) + direction (1 byte) + message type (1 byte) + payload length (4 bytes)
+const recordHeaderSize = 14
+
+// ErrBadMagic is returned by NewReader when the stream is not a capture file
+var ErrBadMagic = errors.New("not a NINJAM capture file")
+
+// Direction tells who sent the frame
+type Direction uint8
+
+const (
+	// In - frame received from server
+	In Direction = iota
+	// Out - frame sent to server
+	Out
+)
+
+func (d Direction) String() string {
+	switch d {
+	case In:
+		return "<-"
+	case Out:
+		return "->"
+	}
+
+	return fmt.Sprintf("Direction(%d)", d)
+}
+
+// Record is one captured frame
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Type      uint8
+	Payload   []byte
+}
+
+// Message decodes payload with models types, unknown types come with nil InPayload
+func (r Record) Message() (*models.NetMessage, error) {
+	nm := models.NewNetMessage(r.Type)
+	nm.Length = uint32(len(r.Payload))
+
+	return nm, nm.Unmarshal(r.Payload)
+}
+
+// Writer writes records to capture file, it is safe for concurrent use
+type Writer struct {
+	mutex  sync.Mutex
+	writer *bufio.Writer
+	closer io.Closer
+	err    error
+}
+
+// NewWriter writes magic string to w and returns Writer
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &Writer{writer: bufio.NewWriter(w)}
+	if closer, ok := w.(io.Closer); ok {
+		cw.closer = closer
+	}
+
+	if _, err := cw.writer.WriteString(magic); err != nil {
+		return nil, err
+	}
+
+	return cw, nil
+}
+
+// Create creates capture file, existing file is truncated
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write records one frame, the first write error is returned by all following calls
+func (w *Writer) Write(direction Direction, msgType uint8, payload []byte) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint64(header[0:], uint64(time.Now().UnixNano()))
+	header[8] = uint8(direction)
+	header[9] = msgType
+	binary.LittleEndian.PutUint32(header[10:], uint32(len(payload)))
+
+	if _, w.err = w.writer.Write(header[:]); w.err != nil {
+		return w.err
+	}
+	if _, w.err = w.writer.Write(payload); w.err != nil {
+		return w.err
+	}
+
+	// пишем сразу, чтобы при падении приложения в файле остались последние фреймы
+	w.err = w.writer.Flush()
+
+	return w.err
+}
+
+// Close flushes buffer and closes underlying writer if it is io.Closer
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.writer.Flush()
+	if w.closer != nil {
+		if closeErr := w.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Reader reads records from capture file
+type Reader struct {
+	reader  io.Reader
+	maxSize uint32
+}
+
+// NewReader checks magic string and returns Reader
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+
+	buf := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != magic {
+		return nil, ErrBadMagic
+	}
+
+	return &Reader{reader: br, maxSize: models.DefaultMaxFrameSize}, nil
+}
+
+// Read returns the next record, io.EOF means the end of capture
+func (r *Reader) Read() (Record, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r.reader, header[:]); err == io.ErrUnexpectedEOF {
+		return Record{}, models.ErrTruncatedFrame
+	} else if err != nil {
+		return Record{}, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[10:])
+	if length > r.maxSize {
+		return Record{}, models.ErrFrameTooLarge
+	}
+
+	record := Record{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:]))),
+		Direction: Direction(header[8]),
+		Type:      header[9],
+		Payload:   make([]byte, length),
+	}
+
+	if _, err := io.ReadFull(r.reader, record.Payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Record{}, models.ErrTruncatedFrame
+	} else if err != nil {
+		return Record{}, err
+	}
+
+	return record, nil
+}
+
+// ReadAll reads all records till the end of capture
+func (r *Reader) ReadAll() ([]Record, error) {
+	var records []Record
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return records, err
+		}
+
+		records = append(records, record)
+	}
+}
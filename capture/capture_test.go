@@ -0,0 +1,76 @@
+package capture
+
+import (
+	"bytes"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapture_RoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	w, err := NewWriter(buf)
+	require.NoError(t, err)
+
+	before := time.Now()
+	require.NoError(t, w.Write(In, models.ServerConfigChangeNotifyType, []byte{120, 0, 16, 0}))
+	require.NoError(t, w.Write(Out, models.ClientKeepaliveType, nil))
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(buf)
+	require.NoError(t, err)
+
+	records, err := r.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	assert.Equal(t, In, records[0].Direction)
+	assert.Equal(t, models.ServerConfigChangeNotifyType, records[0].Type)
+	assert.Equal(t, []byte{120, 0, 16, 0}, records[0].Payload)
+	assert.False(t, records[0].Time.Before(before.Truncate(time.Nanosecond)))
+
+	assert.Equal(t, Out, records[1].Direction)
+	assert.Equal(t, models.ClientKeepaliveType, records[1].Type)
+	assert.Empty(t, records[1].Payload)
+
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCapture_BrokenFile(t *testing.T) {
+	_, err := NewReader(strings.NewReader("not a capture"))
+	assert.Equal(t, ErrBadMagic, err)
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(In, models.ChatMessageType, []byte("MSG\x00a\x00b\x00")))
+
+	// файл оборван посреди payload - например, приложение упало во время записи
+	r, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+	require.NoError(t, err)
+
+	_, err = r.Read()
+	assert.Equal(t, models.ErrTruncatedFrame, err)
+}
+
+func TestFormat(t *testing.T) {
+	at := time.Date(2020, 5, 1, 20, 15, 30, 0, time.Local)
+
+	chat := Format(Record{Time: at, Direction: In, Type: models.ChatMessageType, Payload: []byte("MSG\x00Vasya\x00hello\x00")})
+	assert.Equal(t, `2020-05-01 20:15:30.000 <- ChatMessage (16 bytes) "MSG" "Vasya" "hello" "" ""`, chat)
+
+	audio := Format(Record{Time: at, Direction: Out, Type: models.ClientUploadIntervalWriteType, Payload: append(make([]byte, 17), 1, 2, 3)})
+	assert.Equal(t, "2020-05-01 20:15:30.000 -> ClientUploadIntervalWrite (20 bytes) GUID 00000000000000000000000000000000, flags 0, 3 bytes of audio", audio)
+
+	keepAlive := Format(Record{Time: at, Direction: Out, Type: models.ClientKeepaliveType})
+	assert.Equal(t, "2020-05-01 20:15:30.000 -> Keepalive (0 bytes)", keepAlive)
+
+	unknown := Format(Record{Time: at, Direction: In, Type: 0x42, Payload: []byte{0xAB}})
+	assert.Equal(t, "2020-05-01 20:15:30.000 <- 0x42 (1 bytes) raw: ab", unknown)
+}
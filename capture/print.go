@@ -0,0 +1,94 @@
+package capture
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"io"
+	"strings"
+)
+
+const timeFormat = "2006-01-02 15:04:05.000"
+
+// Print writes human readable dump of all records to w, one line per frame
+func Print(w io.Writer, r *Reader) error {
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(w, Format(record)); err != nil {
+			return err
+		}
+	}
+}
+
+// Format returns record as one line: time, direction, message type, length and decoded payload.
+// Audio data and password hash are not printed, payloads which can't be decoded are printed in hex
+func Format(record Record) string {
+	line := fmt.Sprintf("%s %s %s (%d bytes)", record.Time.Format(timeFormat), record.Direction,
+		models.MessageTypeName(record.Type), len(record.Payload))
+
+	nm, err := record.Message()
+	if err != nil {
+		return fmt.Sprintf("%s decode error: %s, raw: %s", line, err, hex.EncodeToString(record.Payload))
+	}
+
+	if nm.InPayload == nil {
+		if len(record.Payload) == 0 {
+			return line
+		}
+		return fmt.Sprintf("%s raw: %s", line, hex.EncodeToString(record.Payload))
+	}
+
+	return line + " " + describe(nm.InPayload)
+}
+
+func describe(payload models.Unmarshaler) string {
+	switch p := payload.(type) {
+	case *models.ServerAuthChallenge:
+		return fmt.Sprintf("challenge %x, capabilities 0x%08x, protocol 0x%08x, license %q",
+			p.Challenge, p.ServerCapabilities, p.ProtocolVersion, p.LicenseAgreement)
+	case *models.ServerAuthReply:
+		return fmt.Sprintf("flag %d, message %q, max channels %d", p.Flag, p.ErrorMessage, p.MaxChannels)
+	case *models.ServerConfigChangeNotify:
+		return fmt.Sprintf("BPM %d, BPI %d", p.BPM, p.BPI)
+	case *models.ServerUserInfoChangeNotify:
+		infos := make([]string, 0, len(p.UserInfos))
+		for _, ui := range p.UserInfos {
+			infos = append(infos, fmt.Sprintf("%q #%d %q active %d volume %d pan %d flags %d",
+				ui.Name, ui.ChannelIndex, ui.ChannelName, ui.Active, ui.Volume, ui.Pan, ui.Flags))
+		}
+		return "[" + strings.Join(infos, ", ") + "]"
+	case *models.ServerDownloadIntervalBegin:
+		return fmt.Sprintf("GUID %x, size %d, FourCC %q, user %q, channel %d",
+			p.GUID, p.EstimatedSize, p.FourCC[:], p.Username, p.ChannelIndex)
+	case *models.ServerDownloadIntervalWrite:
+		return fmt.Sprintf("GUID %x, flags %d, %d bytes of audio", p.GUID, p.Flags, len(p.AudioData))
+	case *models.ClientAuthUser:
+		return fmt.Sprintf("user %q, capabilities 0x%08x, version 0x%08x", p.Username, p.ClientCapabilities, p.ClientVersion)
+	case *models.ClientSetUsermask:
+		masks := make([]string, 0, len(p.UserMasks))
+		for _, um := range p.UserMasks {
+			masks = append(masks, fmt.Sprintf("%q 0x%08x", um.Name, um.ChannelMask))
+		}
+		return "[" + strings.Join(masks, ", ") + "]"
+	case *models.ClientSetChannelInfo:
+		channels := make([]string, 0, len(p.Channels))
+		for _, ci := range p.Channels {
+			channels = append(channels, fmt.Sprintf("%q volume %d pan %d flags %d", ci.Name, ci.Volume, ci.Pan, ci.Flags))
+		}
+		return "[" + strings.Join(channels, ", ") + "]"
+	case *models.ClientUploadIntervalBegin:
+		return fmt.Sprintf("GUID %x, size %d, FourCC %q, channel %d", p.GUID, p.EstimatedSize, p.FourCC[:], p.ChannelIndex)
+	case *models.ClientUploadIntervalWrite:
+		return fmt.Sprintf("GUID %x, flags %d, %d bytes of audio", p.GUID, p.Flags, len(p.AudioData))
+	case *models.ChatMessage:
+		return fmt.Sprintf("%q %q %q %q %q", p.Command, p.Arg1, p.Arg2, p.Arg3, p.Arg4)
+	}
+
+	return fmt.Sprintf("%+v", payload)
+}
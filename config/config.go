@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
 type AppConfig struct {
@@ -21,36 +22,139 @@ type AppConfig struct {
 	Telegram      TelegramConf   `yaml:"telegram"`
 	Slack         SlackConf      `yaml:"slack"`
 	Servers       []NinJamServer `yaml:"servers"`
+	Server        ServerConf     `yaml:"server"`
+	HTTP          HTTPConf       `yaml:"http"`
 	IgnoreUsers   []string       `yaml:"ignore_users"`
 	IgnorePrefix  []string       `yaml:"ignore_prefix"`
 }
 
 type NinJamServer struct {
-	Host         string `yaml:"host"`
-	Port         string `yaml:"port"`
-	Anonymous    bool   `yaml:"anonymous"`
-	UserName     string `yaml:"user_name"`
-	UserPassword string `yaml:"user_password"`
+	Host         string        `yaml:"host"`
+	Port         string        `yaml:"port"`
+	Anonymous    bool          `yaml:"anonymous"`
+	UserName     string        `yaml:"user_name"`
+	UserPassword string        `yaml:"user_password"`
+	Reconnect    ReconnectConf `yaml:"reconnect"`
+	Capture      string        `yaml:"capture"` // file to write all protocol traffic to, see "ninjam-chatbot decode"
+	License      LicenseConf   `yaml:"license"`
+	Record       RecordConf    `yaml:"record"`
+	Jukebox      JukeboxConf   `yaml:"jukebox"`
+	Metronome    MetronomeConf `yaml:"metronome"`
+	Activity     ActivityConf  `yaml:"activity"`
+	Stream       StreamConf    `yaml:"stream"`
+	Icecast      IcecastConf   `yaml:"icecast"`
+}
+
+// RecordConf - multitrack recording of server's users, admins start and stop it with "record start|stop" command
+type RecordConf struct {
+	Dir     string `yaml:"dir"`     // directory for session directories, recording is disabled when empty
+	Enabled bool   `yaml:"enabled"` // start recording at application start
+}
+
+// LicenseConf - what to answer to server which requires accepting license agreement
+type LicenseConf struct {
+	Policy string `yaml:"policy"` // "accept" (by default), "reject" or "hash" - accept only the agreement with Hash
+	Hash   string `yaml:"hash"`   // SHA-256 of agreement text, bot sends it to Telegram admin chat with the text
+	State  string `yaml:"state"`  // file with hash of the last agreement sent to admin chat, without it restarts send it again
+}
+
+// ReconnectConf - pauses between reconnects to NINJAM server, zero values mean defaults
+type ReconnectConf struct {
+	InitialDelay time.Duration `yaml:"initial_delay"` // "5s" by default, doubles after every failed connection
+	MaxDelay     time.Duration `yaml:"max_delay"`     // "5m" by default
+	Jitter       float64       `yaml:"jitter"`        // random delay deviation, 0.2 means ±20%
+	MaxAttempts  int           `yaml:"max_attempts"`  // failed connections in a row before giving up, 0 - never
+}
+
+// JukeboxConf - backing tracks player, NINJAM users control it with "!jukebox play NAME", "!jukebox stop" and "!jukebox list"
+type JukeboxConf struct {
+	Dir     string `yaml:"dir"`     // directory with loops "<name>.<BPM>.<BPI>.ogg", jukebox is disabled when empty
+	Channel string `yaml:"channel"` // bot's channel name, "jukebox" by default
+	Command string `yaml:"command"` // chat command, "!jukebox" by default
+}
+
+// MetronomeConf - click channel synced to server's BPM/BPI, NINJAM users turn it on and off with "!metronome on|off"
+type MetronomeConf struct {
+	Dir     string `yaml:"dir"`     // directory with "pattern.<BPM>.<BPI>.ogg" or "accent.ogg" and "click.ogg", metronome is disabled when empty
+	Channel string `yaml:"channel"` // bot's channel name, "metronome" by default
+	Command string `yaml:"command"` // chat command, "!metronome" by default
+	Volume  int16  `yaml:"volume"`  // channel volume in 0.1 dB, -60 is -6 dB
+	Enabled bool   `yaml:"enabled"` // turn metronome on at application start
+}
+
+// ActivityConf - loudness of users' intervals: status replies separate who plays from who listens, AFK notices go to chats
+type ActivityConf struct {
+	Enabled   bool    `yaml:"enabled"`
+	Threshold float64 `yaml:"threshold"` // RMS level in dBFS below which interval is silent, -50 by default
+	AFK       int     `yaml:"afk"`       // silent intervals of user who played before AFK notice, 0 - no notices
+}
+
+// StreamConf - live audio stream of server's mix, it's served by HTTP server from HTTPConf
+type StreamConf struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"` // URL path, "/<port>" by default
+	Name    string `yaml:"name"` // stream name players show, "NINJAM <host>:<port>" by default
+}
+
+// IcecastConf - Icecast or other stream server which relays server's stream, its listeners are shown in status replies
+type IcecastConf struct {
+	Status   string        `yaml:"status"` // URL of status XML, e.g. "http://icecast:8000/admin/stats", polling is disabled when empty
+	Mount    string        `yaml:"mount"`  // mount of the stream in status, e.g. "/2050"
+	User     string        `yaml:"user"`   // HTTP basic authentication, Icecast requires admin's one for "/admin/stats"
+	Password string        `yaml:"password"`
+	Period   time.Duration `yaml:"period"` // "30s" by default
+	URL      string        `yaml:"url"`    // public URL of the mount for chat replies, e.g. "http://icecast:8000/2050"
+}
+
+// HTTPConf - HTTP server of live audio streams
+type HTTPConf struct {
+	Listen string `yaml:"listen"` // ":8000" for example
+	URL    string `yaml:"url"`    // public URL of HTTP server for chat replies, e.g. "http://jam.example.com:8000"
+}
+
+// ServerConf - embedded NINJAM server, zero values mean defaults
+type ServerConf struct {
+	Enabled     bool         `yaml:"enabled"`
+	Listen      string       `yaml:"listen"`       // ":2049" for example
+	BPM         uint16       `yaml:"bpm"`          // 120 by default
+	BPI         uint16       `yaml:"bpi"`          // 16 by default
+	MaxUsers    int          `yaml:"max_users"`    // 10 by default
+	MaxChannels uint8        `yaml:"max_channels"` // channels per user, 2 by default
+	KeepAlive   uint8        `yaml:"keep_alive"`   // seconds, 3 by default
+	Topic       string       `yaml:"topic"`
+	Anonymous   bool         `yaml:"anonymous"` // allow anonymous logins
+	Users       []ServerUser `yaml:"users"`
+}
+
+type ServerUser struct {
+	Name     string `yaml:"name"`
+	Password string `yaml:"password"`
+	Topic    bool   `yaml:"topic"` // user can change topic
+	Admin    bool   `yaml:"admin"` // user can change BPM and BPI
 }
 
 type TelegramConf struct {
-	Token    string `yaml:"token"`
-	ChatID   int64  `yaml:"chat_id"`
-	Disabled bool   `yaml:"disabled"`
+	Token       string  `yaml:"token"`
+	ChatID      int64   `yaml:"chat_id"`
+	AdminChatID int64   `yaml:"admin_chat_id"` // chat for service messages like servers' license agreements
+	Disabled    bool    `yaml:"disabled"`
+	SyncTopic   bool    `yaml:"sync_topic"` // write NINJAM topic to chat description
+	Admins      []int64 `yaml:"admins"`     // IDs of users allowed to run bot commands like "topic"
 }
 
 type SlackConf struct {
-	BotName  string `yaml:"bot_name"`
-	Token    string `yaml:"token"`
-	Channel  string `yaml:"channel"`
-	Disabled bool   `yaml:"disabled"`
+	BotName   string   `yaml:"bot_name"`
+	Token     string   `yaml:"token"`
+	Channel   string   `yaml:"channel"`
+	Disabled  bool     `yaml:"disabled"`
+	SyncTopic bool     `yaml:"sync_topic"` // write NINJAM topic to channel topic
+	Admins    []string `yaml:"admins"`     // IDs of users allowed to run bot commands like "topic", e.g. "U0123ABCD"
 }
 
-var appConfig *AppConfig
-
-func init() {
-	appConfig = &AppConfig{}
+var appConfig = &AppConfig{}
 
+// Load parses command line flags and reads config file, it must be called once at application start
+func Load() {
 	workPath, _ := os.Getwd()
 	workPath, _ = filepath.Abs(workPath)
 	// initialize default configurations
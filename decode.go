@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"github.com/ayvan/ninjam-chatbot/capture"
+	"io"
+	"os"
+)
+
+// decode prints capture file written by the bot with "capture" option of server
+func decode(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: ninjam-chatbot decode <capture file>")
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := capture.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	return capture.Print(out, reader)
+}
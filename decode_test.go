@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ayvan/ninjam-chatbot/capture"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "server.cap")
+	w, err := capture.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(capture.In, models.ServerConfigChangeNotifyType, []byte{120, 0, 16, 0}))
+	require.NoError(t, w.Write(capture.Out, models.ChatMessageType, []byte("MSG\x00hi\x00\x00\x00\x00")))
+	require.NoError(t, w.Close())
+
+	out := &bytes.Buffer{}
+	require.NoError(t, decode([]string{path}, out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasSuffix(lines[0], "<- ServerConfigChangeNotify (4 bytes) BPM 120, BPI 16"), lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], `-> ChatMessage (10 bytes) "MSG" "hi" "" "" ""`), lines[1])
+
+	assert.Error(t, decode(nil, out))
+	assert.Error(t, decode([]string{filepath.Join(dir, "missing.cap")}, out))
+}
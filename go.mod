@@ -1,17 +1,25 @@
 module github.com/ayvan/ninjam-chatbot
 
-go 1.14
+go 1.18
 
 require (
 	github.com/VividCortex/godaemon v0.0.0-20201030185937-6073f6ce8f76
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
-	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/jfreymuth/oggvorbis v1.0.5
 	github.com/luci/go-render v0.0.0-20160219211803-9a04cc21af0f
-	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sirupsen/logrus v1.7.0
 	github.com/slack-go/slack v0.7.2
+	github.com/stretchr/testify v1.2.2
+	gopkg.in/yaml.v2 v2.3.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/jfreymuth/vorbis v1.0.2 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
-	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,205 @@
+// Package icecast polls status XML of stream server which relays NINJAM server's stream, so chats show
+// how many listeners it has.
+//
+// Both status XML of Icecast ("/admin/stats", sources with "mount" attribute) and simple status XML
+// of mounts are understood:
+//
+//	<status>
+//	  <mount><name>/2050</name><listeners>12</listeners><users>Vasya, Petya</users></mount>
+//	</status>
+package icecast
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPeriod - how often status is polled
+	DefaultPeriod = time.Second * 30
+
+	// maxStatusSize - bigger status is an error, Icecast status of hundreds of mounts fits easily
+	maxStatusSize = 1 << 20
+)
+
+// Status is status XML of stream server
+type Status struct {
+	Mount []Mount `xml:"mount"`
+}
+
+// Mount is state of one stream
+type Mount struct {
+	Name      string `xml:"name"`
+	Listeners string `xml:"listeners"`
+	Users     string `xml:"users"`
+}
+
+// icestats is status XML of Icecast, mounts are "source" elements
+type icestats struct {
+	Status
+	Source []struct {
+		Mount     string `xml:"mount,attr"`
+		Listeners string `xml:"listeners"`
+	} `xml:"source"`
+}
+
+// ParseStatus parses status XML of Icecast or simple status XML of mounts
+func ParseStatus(data []byte) (*Status, error) {
+	var stats icestats
+	if err := xml.Unmarshal(data, &stats); err != nil {
+		return nil, err
+	}
+
+	status := &Status{Mount: stats.Mount}
+	for _, source := range stats.Source {
+		status.Mount = append(status.Mount, Mount{Name: source.Mount, Listeners: source.Listeners})
+	}
+
+	return status, nil
+}
+
+// Find returns mount by name, leading slash of names is ignored
+func (s *Status) Find(name string) (Mount, bool) {
+	for _, m := range s.Mount {
+		if strings.TrimPrefix(m.Name, "/") == strings.TrimPrefix(name, "/") {
+			return m, true
+		}
+	}
+
+	return Mount{}, false
+}
+
+// Poller polls status of one mount
+type Poller struct {
+	url      string
+	mount    string
+	user     string
+	password string
+	period   time.Duration
+	client   *http.Client
+
+	mutex     sync.Mutex
+	listeners int
+	known     bool
+	failing   bool // the last poll failed
+}
+
+// New creates poller of mount in status XML at url
+func New(url, mount string) *Poller {
+	return &Poller{
+		url:    url,
+		mount:  mount,
+		period: DefaultPeriod,
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// Auth sets user and password of HTTP basic authentication, Icecast requires admin's ones for "/admin/stats"
+func (p *Poller) Auth(user, password string) {
+	p.user = user
+	p.password = password
+}
+
+// Period sets how often status is polled, DefaultPeriod by default
+func (p *Poller) Period(period time.Duration) {
+	p.period = period
+}
+
+// Listeners returns number of mount's listeners, false when status is unknown because the last poll failed
+func (p *Poller) Listeners() (int, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.listeners, p.known
+}
+
+// Run polls status until ctx is cancelled
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) poll(ctx context.Context) {
+	listeners, err := p.fetch(ctx)
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err != nil {
+		// ошибку пишем в лог один раз, а не при каждом опросе
+		if !p.failing {
+			logrus.Warnf("Icecast %s status error: %s", p.url, err)
+		}
+		p.listeners, p.known, p.failing = 0, false, true
+		return
+	}
+
+	p.listeners, p.known, p.failing = listeners, true, false
+}
+
+func (p *Poller) fetch(ctx context.Context) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if p.user != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStatusSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > maxStatusSize {
+		return 0, fmt.Errorf("status is bigger than %d bytes", maxStatusSize)
+	}
+
+	status, err := ParseStatus(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// нет монтирования - источник не подключён к серверу, слушателей нет
+	m, ok := status.Find(p.mount)
+	if !ok || strings.TrimSpace(m.Listeners) == "" {
+		return 0, nil
+	}
+
+	listeners, err := strconv.Atoi(strings.TrimSpace(m.Listeners))
+	if err != nil {
+		return 0, fmt.Errorf("mount %s: bad listeners %q", m.Name, m.Listeners)
+	}
+
+	return listeners, nil
+}
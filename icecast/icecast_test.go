@@ -0,0 +1,135 @@
+package icecast
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	statusXML = `<?xml version="1.0"?>
+<status>
+  <mount><name>/2050</name><listeners>12</listeners><users>Vasya, Petya</users></mount>
+  <mount><name>/2051</name><listeners> 3 </listeners></mount>
+</status>`
+
+	icestatsXML = `<?xml version="1.0"?>
+<icestats>
+  <admin>icemaster@localhost</admin>
+  <listeners>15</listeners>
+  <source mount="/2050"><listeners>7</listeners><server_name>Guitar jam</server_name></source>
+  <source mount="/2051"><listeners>0</listeners></source>
+</icestats>`
+)
+
+func TestParseStatus(t *testing.T) {
+	status, err := ParseStatus([]byte(statusXML))
+	require.NoError(t, err)
+	assert.Equal(t, []Mount{
+		{Name: "/2050", Listeners: "12", Users: "Vasya, Petya"},
+		{Name: "/2051", Listeners: " 3 "},
+	}, status.Mount)
+
+	m, ok := status.Find("2050")
+	assert.True(t, ok)
+	assert.Equal(t, "12", m.Listeners)
+	_, ok = status.Find("/2052")
+	assert.False(t, ok)
+
+	status, err = ParseStatus([]byte(icestatsXML))
+	require.NoError(t, err)
+	assert.Equal(t, []Mount{{Name: "/2050", Listeners: "7"}, {Name: "/2051", Listeners: "0"}}, status.Mount)
+
+	_, err = ParseStatus([]byte("<status><mount>"))
+	assert.Error(t, err)
+}
+
+func TestPoller(t *testing.T) {
+	body := icestatsXML
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "hackme" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	p := New(server.URL+"/admin/stats", "/2050")
+
+	_, known := p.Listeners()
+	assert.False(t, known)
+
+	// без пароля Icecast не отдаёт статистику
+	p.poll(ctx)
+	_, known = p.Listeners()
+	assert.False(t, known)
+
+	p.Auth("admin", "hackme")
+	p.poll(ctx)
+	listeners, known := p.Listeners()
+	assert.True(t, known)
+	assert.Equal(t, 7, listeners)
+
+	// источник отключился - слушателей нет
+	body = `<icestats><source mount="/2051"><listeners>4</listeners></source></icestats>`
+	p.poll(ctx)
+	listeners, known = p.Listeners()
+	assert.True(t, known)
+	assert.Equal(t, 0, listeners)
+
+	body = `<icestats><source mount="/2050"><listeners>many</listeners></source></icestats>`
+	p.poll(ctx)
+	_, known = p.Listeners()
+	assert.False(t, known)
+
+	body = statusXML
+	p.poll(ctx)
+	listeners, known = p.Listeners()
+	assert.True(t, known)
+	assert.Equal(t, 12, listeners)
+}
+
+func TestPoller_Run(t *testing.T) {
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(statusXML))
+		select {
+		case requests <- struct{}{}:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	p := New(server.URL, "2051")
+	p.Period(time.Millisecond * 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	// статус опрашивается сразу и затем периодически
+	for i := 0; i < 2; i++ {
+		select {
+		case <-requests:
+		case <-time.After(time.Second * 5):
+			t.Fatal("status is not polled")
+		}
+	}
+
+	cancel()
+	<-done
+
+	listeners, known := p.Listeners()
+	assert.True(t, known)
+	assert.Equal(t, 3, listeners)
+}
@@ -0,0 +1,279 @@
+// Package intervals assembles intervals which NINJAM server sends to the bot in chunks, so recorder,
+// activity monitor and live stream share one download of users' audio.
+//
+// Assembler subscribes the bot to all users' channels while somebody has acquired it and passes bot's
+// events to its subscribers in the order they came from server. Chunks of intervals are not passed:
+// every complete interval is sent as *Interval instead, with user, channel and the time when it started.
+// Every interval is decoded at most once, whoever of the subscribers decodes it first.
+package intervals
+
+import (
+	"bytes"
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// maxIntervalSize - bigger intervals are dropped, it's about 10 minutes of 128 kbit/s audio
+const maxIntervalSize = 10 << 20
+
+// Event is ninjam_bot.Event, except interval chunks, or *Interval
+type Event interface{}
+
+// Interval is downloaded interval of user's channel
+type Interval struct {
+	GUID    [16]byte
+	User    string
+	Channel models.Channel // name, volume and pan of the channel when the interval started, Index is always set
+	Started time.Time      // when the first chunk came
+	Data    []byte         // OGG Vorbis
+
+	once    sync.Once
+	samples []float32
+	format  *oggvorbis.Format
+	err     error
+}
+
+// Decode decodes interval, samples are shared by all subscribers, so they must not be changed
+func (i *Interval) Decode() ([]float32, *oggvorbis.Format, error) {
+	i.once.Do(func() {
+		i.samples, i.format, i.err = oggvorbis.ReadAll(bytes.NewReader(i.Data))
+	})
+
+	return i.samples, i.format, i.err
+}
+
+// Assembler assembles intervals of bot's server
+type Assembler struct {
+	bot     *ninjam_bot.NinJamBot
+	events  <-chan ninjam_bot.Event
+	now     func() time.Time
+	changed chan struct{} // acquired changed, Run updates subscriptions
+
+	// состояние меняется только в горутине Run
+	users      map[string]models.User
+	subscribed map[string]bool
+	downloads  map[[16]byte]*Interval
+
+	mutex       sync.Mutex
+	acquired    int
+	subscribers []*subscriber
+}
+
+// subscriber - канал подписчика, done закрывается в Unsubscribe и прерывает ожидание отправки
+type subscriber struct {
+	events chan Event
+	done   chan struct{}
+}
+
+// New creates assembler of bot's server, it subscribes to bot's events, so it must be created before bot connects
+func New(bot *ninjam_bot.NinJamBot) *Assembler {
+	return &Assembler{
+		bot:        bot,
+		events:     bot.Subscribe(),
+		now:        time.Now,
+		changed:    make(chan struct{}, 1),
+		users:      make(map[string]models.User),
+		subscribed: make(map[string]bool),
+		downloads:  make(map[[16]byte]*Interval),
+	}
+}
+
+// Bot returns bot whose intervals are assembled
+func (a *Assembler) Bot() *ninjam_bot.NinJamBot {
+	return a.bot
+}
+
+// Subscribe returns channel of events, subscriber must read them or Unsubscribe,
+// otherwise events processing will be blocked when the channel buffer is full
+func (a *Assembler) Subscribe() <-chan Event {
+	s := &subscriber{
+		events: make(chan Event, ninjam_bot.DefaultBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	a.mutex.Lock()
+	a.subscribers = append(a.subscribers, s)
+	a.mutex.Unlock()
+
+	return s.events
+}
+
+// Unsubscribe stops events delivery, the channel is not closed
+func (a *Assembler) Unsubscribe(events <-chan Event) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i, s := range a.subscribers {
+		if s.events == events {
+			a.subscribers = append(a.subscribers[:i], a.subscribers[i+1:]...)
+			close(s.done)
+			return
+		}
+	}
+}
+
+// Acquire subscribes the bot to all users' channels until Release, calls are counted.
+// It doesn't block: subscriptions are changed by Run
+func (a *Assembler) Acquire() {
+	a.mutex.Lock()
+	a.acquired++
+	a.mutex.Unlock()
+
+	a.notify()
+}
+
+// Release cancels Acquire
+func (a *Assembler) Release() {
+	a.mutex.Lock()
+	if a.acquired > 0 {
+		a.acquired--
+	}
+	a.mutex.Unlock()
+
+	a.notify()
+}
+
+func (a *Assembler) notify() {
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run handles bot's events until ctx is cancelled, then it unsubscribes the bot from users' channels
+func (a *Assembler) Run(ctx context.Context) {
+	defer a.bot.Unsubscribe(a.events)
+	defer a.unsubscribeAll()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.changed:
+			a.subscribe()
+		case event := <-a.events:
+			a.handle(ctx, event)
+		}
+	}
+}
+
+func (a *Assembler) handle(ctx context.Context, event ninjam_bot.Event) {
+	switch event := event.(type) {
+	case ninjam_bot.UserJoinedEvent:
+		a.users[event.User.Name] = event.User
+		a.subscribe()
+	case ninjam_bot.UserChannelsChangedEvent:
+		a.users[event.User.Name] = event.User
+	case ninjam_bot.UserLeftEvent:
+		delete(a.users, event.User.Name)
+		if a.subscribed[event.User.Name] {
+			delete(a.subscribed, event.User.Name)
+			a.bot.UnsubscribeChannels(event.User.Name, models.AllChannels)
+		}
+	case ninjam_bot.IntervalBeginEvent:
+		a.begin(event.Interval)
+		return
+	case ninjam_bot.IntervalWriteEvent:
+		if interval := a.write(event.Interval); interval != nil {
+			a.publish(ctx, interval)
+		}
+		return
+	case ninjam_bot.DisconnectEvent:
+		// сервер не дошлёт начатые интервалы, а пользователей пришлёт заново после входа
+		a.downloads = make(map[[16]byte]*Interval)
+		a.users = make(map[string]models.User)
+	}
+
+	a.publish(ctx, event)
+}
+
+// subscribe subscribes the bot to channels of all users while assembler is acquired or unsubscribes from all
+func (a *Assembler) subscribe() {
+	a.mutex.Lock()
+	acquired := a.acquired > 0
+	a.mutex.Unlock()
+
+	if !acquired {
+		a.unsubscribeAll()
+		return
+	}
+
+	// после переподключения бот сам восстанавливает подписки, повторная подписка не нужна
+	for name := range a.users {
+		if !a.subscribed[name] {
+			a.subscribed[name] = true
+			a.bot.SubscribeChannels(name, models.AllChannels)
+		}
+	}
+}
+
+func (a *Assembler) unsubscribeAll() {
+	for name := range a.subscribed {
+		delete(a.subscribed, name)
+		a.bot.UnsubscribeChannels(name, models.AllChannels)
+	}
+}
+
+func (a *Assembler) begin(begin *models.ServerDownloadIntervalBegin) {
+	// нулевой GUID - пользователь молчит в этом интервале
+	if begin.GUID == [16]byte{} {
+		return
+	}
+
+	interval := &Interval{
+		GUID:    begin.GUID,
+		User:    string(begin.Username),
+		Channel: models.Channel{Index: begin.ChannelIndex},
+		Started: a.now(),
+	}
+	for _, c := range a.users[interval.User].Channels {
+		if c.Index == begin.ChannelIndex {
+			interval.Channel = c
+		}
+	}
+
+	a.downloads[begin.GUID] = interval
+}
+
+// write appends chunk to interval, it returns interval when it's complete
+func (a *Assembler) write(write *models.ServerDownloadIntervalWrite) *Interval {
+	interval, ok := a.downloads[write.GUID]
+	if !ok {
+		return nil
+	}
+
+	interval.Data = append(interval.Data, write.AudioData...)
+	if len(interval.Data) > maxIntervalSize {
+		logrus.Warnf("Interval of %s on %s:%s is bigger than %d bytes, dropped", interval.User, a.bot.Host(), a.bot.Port(), maxIntervalSize)
+		delete(a.downloads, write.GUID)
+		return nil
+	}
+	if !write.IsLast() {
+		return nil
+	}
+	delete(a.downloads, write.GUID)
+
+	return interval
+}
+
+// publish sends event to all subscribers, it blocks on full subscriber's channel until ctx is done
+// or the subscriber unsubscribes
+func (a *Assembler) publish(ctx context.Context, event Event) {
+	a.mutex.Lock()
+	subscribers := append([]*subscriber(nil), a.subscribers...)
+	a.mutex.Unlock()
+
+	for _, s := range subscribers {
+		select {
+		case s.events <- event:
+		case <-s.done:
+		case <-ctx.Done():
+			logrus.Warnf("Event %T of %s:%s dropped, subscriber is not reading events", event, a.bot.Host(), a.bot.Port())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package intervals
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var (
+	guitar = models.User{Name: "Vasya@127.0.0.x", Channels: []models.Channel{{Index: 1, Name: "guitar", Volume: -30, Pan: -64}}}
+	start  = time.Date(2026, 10, 18, 20, 30, 0, 0, time.UTC)
+)
+
+func intervalBegin(guid byte, userName string, channelIndex uint8) ninjam_bot.IntervalBeginEvent {
+	return ninjam_bot.IntervalBeginEvent{Interval: &models.ServerDownloadIntervalBegin{
+		GUID:         [16]byte{guid, 1},
+		FourCC:       [4]byte{'O', 'G', 'G', 'v'},
+		ChannelIndex: channelIndex,
+		Username:     []byte(userName),
+	}}
+}
+
+func intervalWrite(guid byte, flags uint8, data string) ninjam_bot.IntervalWriteEvent {
+	return ninjam_bot.IntervalWriteEvent{Interval: &models.ServerDownloadIntervalWrite{
+		GUID:      [16]byte{guid, 1},
+		Flags:     flags,
+		AudioData: []byte(data),
+	}}
+}
+
+func TestAssembler(t *testing.T) {
+	bot := ninjam_bot.New("127.0.0.1", "2049")
+	a := New(bot)
+	a.now = func() time.Time { return start }
+	events := a.Subscribe()
+	ctx := context.Background()
+
+	// пока сборщик никому не нужен, бот не подписан на аудио
+	a.handle(ctx, ninjam_bot.UserJoinedEvent{User: guitar})
+	assert.Equal(t, ninjam_bot.UserJoinedEvent{User: guitar}, <-events)
+	assert.Empty(t, bot.Subscriptions())
+
+	a.Acquire()
+	a.Acquire()
+	<-a.changed
+	a.subscribe()
+	assert.Equal(t, map[string]uint32{guitar.Name: models.AllChannels}, bot.Subscriptions())
+
+	// куски интервала не передаются, собранный интервал приходит целиком
+	a.handle(ctx, intervalBegin(0xab, guitar.Name, 1))
+	a.handle(ctx, intervalWrite(0xab, 0, "Og"))
+	// нулевой GUID - тишина
+	a.handle(ctx, ninjam_bot.IntervalBeginEvent{Interval: &models.ServerDownloadIntervalBegin{Username: []byte(guitar.Name)}})
+	a.handle(ctx, intervalBegin(0xcd, "Petya@127.0.0.x", 0))
+	a.handle(ctx, intervalWrite(0xab, 1, "gS"))
+	assert.Empty(t, a.downloads[[16]byte{}])
+
+	require.Len(t, events, 1)
+	interval := (<-events).(*Interval)
+	assert.Equal(t, [16]byte{0xab, 1}, interval.GUID)
+	assert.Equal(t, guitar.Name, interval.User)
+	assert.Equal(t, guitar.Channels[0], interval.Channel)
+	assert.Equal(t, start, interval.Started)
+	assert.Equal(t, "OggS", string(interval.Data))
+
+	_, _, err := interval.Decode()
+	assert.Error(t, err)
+
+	// сервер не дошлёт начатые интервалы
+	a.handle(ctx, ninjam_bot.DisconnectEvent{})
+	assert.IsType(t, ninjam_bot.DisconnectEvent{}, <-events)
+	a.handle(ctx, intervalWrite(0xcd, 1, "OggS"))
+	assert.Empty(t, events)
+
+	// после переподключения бот сам восстанавливает подписки
+	a.handle(ctx, ninjam_bot.UserJoinedEvent{User: guitar})
+	<-events
+	assert.Equal(t, map[string]uint32{guitar.Name: models.AllChannels}, bot.Subscriptions())
+
+	a.Release()
+	<-a.changed
+	a.subscribe()
+	assert.Equal(t, map[string]uint32{guitar.Name: models.AllChannels}, bot.Subscriptions())
+
+	a.Release()
+	<-a.changed
+	a.subscribe()
+	assert.Empty(t, bot.Subscriptions())
+
+	a.Acquire()
+	<-a.changed
+	a.subscribe()
+	a.handle(ctx, ninjam_bot.UserLeftEvent{User: guitar})
+	<-events
+	assert.Empty(t, bot.Subscriptions())
+}
+
+func TestAssembler_Unsubscribe(t *testing.T) {
+	a := New(ninjam_bot.New("127.0.0.1", "2049"))
+	events := a.Subscribe()
+	for i := 0; i < cap(events); i++ {
+		a.publish(context.Background(), ninjam_bot.UserCountEvent{})
+	}
+
+	published := make(chan struct{})
+	go func() {
+		a.publish(context.Background(), ninjam_bot.UserCountEvent{})
+		close(published)
+	}()
+
+	a.Unsubscribe(events)
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish is blocked by unsubscribed subscriber")
+	}
+}
@@ -0,0 +1,220 @@
+// Package jukebox plays backing tracks to NINJAM server: the bot creates a channel and uploads
+// pre-encoded OGG Vorbis loop at every interval, the loop must be exactly one interval long.
+//
+// Loops are files "<name>.<BPM>.<BPI>.ogg" in loops directory, the same loop may have files for
+// different tempos. When server's BPM/BPI changes, the jukebox switches to the file of playing loop
+// for new tempo or stops if there is no such file.
+//
+// Jukebox is controlled from NINJAM chat: "<command> list", "<command> play NAME" and "<command> stop",
+// command is "!jukebox" by default.
+package jukebox
+
+import (
+	"context"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// DefaultChannel is the name of bot's channel
+	DefaultChannel = "jukebox"
+	// DefaultCommand is the prefix of chat commands
+	DefaultCommand = "!jukebox"
+)
+
+// Jukebox uploads loops to bot's server
+type Jukebox struct {
+	bot     *ninjam_bot.NinJamBot
+	dir     string
+	channel string
+	command string
+	events  <-chan ninjam_bot.Event
+
+	// состояние меняется только в горутине Run
+	index  uint8 // index of the jukebox channel, bot may have other channels
+	bpm    uint
+	bpi    uint
+	loop   *Loop // nil when the jukebox doesn't play
+	audio  []byte
+	ticker ninjam_bot.IntervalTicker
+}
+
+// New creates jukebox playing loops from dir, it subscribes to bot's events, so it must be created before bot connects
+func New(bot *ninjam_bot.NinJamBot, dir string) *Jukebox {
+	return &Jukebox{
+		bot:     bot,
+		dir:     dir,
+		channel: DefaultChannel,
+		command: DefaultCommand,
+		events:  bot.Subscribe(),
+	}
+}
+
+// Channel sets the name of bot's channel, DefaultChannel by default
+func (j *Jukebox) Channel(name string) {
+	j.channel = name
+}
+
+// Command sets the prefix of chat commands, DefaultCommand by default
+func (j *Jukebox) Command(command string) {
+	j.command = command
+}
+
+// Run handles bot's events and uploads loops until ctx is cancelled
+func (j *Jukebox) Run(ctx context.Context) {
+	defer j.bot.Unsubscribe(j.events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			j.stop()
+			return
+		case event := <-j.events:
+			j.handle(event)
+		case <-j.ticker.C():
+			j.upload()
+			j.ticker.Next()
+		}
+	}
+}
+
+func (j *Jukebox) handle(event ninjam_bot.Event) {
+	switch event := event.(type) {
+	case ninjam_bot.AuthEvent:
+		// после переподключения сервер не помнит каналы бота
+		if event.Success {
+			j.bot.ChannelInit(j.channel)
+			j.index, _ = j.bot.ChannelIndex(j.channel)
+		}
+	case ninjam_bot.ConfigChangeEvent:
+		if event.BPM == j.bpm && event.BPI == j.bpi {
+			return
+		}
+		j.bpm, j.bpi = event.BPM, event.BPI
+
+		if j.loop == nil {
+			return
+		}
+
+		name := j.loop.Name
+		if err := j.play(name); err != nil {
+			j.stop()
+			j.reply(fmt.Sprintf("Джукбокс остановлен: %s", err))
+			return
+		}
+		j.reply(fmt.Sprintf("Играет %s (%d BPM, %d BPI)", name, j.bpm, j.bpi))
+	case ninjam_bot.ChatEvent:
+		j.handleChat(event.Message)
+	}
+}
+
+func (j *Jukebox) handleChat(msg models.Message) {
+	if msg.Type != models.MSG || strings.HasPrefix(msg.Name, j.bot.UserName()) {
+		return
+	}
+
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 || fields[0] != j.command {
+		return
+	}
+
+	switch {
+	case len(fields) == 2 && fields[1] == "list":
+		j.reply(j.list())
+	case len(fields) >= 3 && fields[1] == "play":
+		name := strings.Join(fields[2:], " ")
+		if err := j.play(name); err != nil {
+			j.reply(fmt.Sprintf("Не удалось включить %s: %s", name, err))
+			return
+		}
+		logrus.Infof("Jukebox %s:%s plays %s for %s", j.bot.Host(), j.bot.Port(), j.loop.Path, msg.Name)
+		j.reply(fmt.Sprintf("Играет %s (%d BPM, %d BPI)", j.loop.Name, j.bpm, j.bpi))
+	case len(fields) == 2 && fields[1] == "stop":
+		if j.loop == nil {
+			j.reply("Джукбокс не играет")
+			return
+		}
+		j.stop()
+		j.reply("Джукбокс остановлен")
+	default:
+		j.reply(fmt.Sprintf("Команды джукбокса: %[1]s list, %[1]s play НАЗВАНИЕ, %[1]s stop", j.command))
+	}
+}
+
+func (j *Jukebox) reply(message string) {
+	j.bot.SendMessage(message)
+}
+
+func (j *Jukebox) list() string {
+	loops, err := Scan(j.dir)
+	if err != nil {
+		logrus.Errorf("Jukebox %s:%s can't read loops: %s", j.bot.Host(), j.bot.Port(), err)
+		return "Не удалось прочитать список лупов"
+	}
+
+	if len(loops) == 0 {
+		return "Лупов нет"
+	}
+
+	names := make([]string, 0, len(loops))
+	for _, loop := range loops {
+		names = append(names, fmt.Sprintf("%s %d/%d", loop.Name, loop.BPM, loop.BPI))
+	}
+
+	return "Лупы (BPM/BPI): " + strings.Join(names, ", ")
+}
+
+// play starts loop for current BPM/BPI from new interval
+func (j *Jukebox) play(name string) error {
+	if j.bpm == 0 || j.bpi == 0 {
+		return fmt.Errorf("темп сервера неизвестен")
+	}
+
+	loops, err := Scan(j.dir)
+	if err != nil {
+		return err
+	}
+
+	loop, ok := find(loops, name, j.bpm, j.bpi)
+	if !ok {
+		return fmt.Errorf("нет лупа %s для %d BPM, %d BPI", name, j.bpm, j.bpi)
+	}
+
+	audio, err := ioutil.ReadFile(loop.Path)
+	if err != nil {
+		return err
+	}
+	if len(audio) == 0 {
+		return fmt.Errorf("файл %s пустой", loop.Path)
+	}
+
+	j.loop, j.audio = &loop, audio
+
+	j.upload()
+	j.ticker.Start(j.bpm, j.bpi)
+
+	return nil
+}
+
+// stop stops uploads and tells listeners that the channel is silent
+func (j *Jukebox) stop() {
+	if j.loop == nil {
+		return
+	}
+
+	j.ticker.Stop()
+	j.loop, j.audio = nil, nil
+
+	j.bot.SilenceInterval(j.index)
+}
+
+// upload sends the loop as one interval
+func (j *Jukebox) upload() {
+	if err := j.bot.UploadInterval(j.index, j.audio); err != nil && err != ninjam_bot.ErrNotConnected {
+		logrus.Errorf("Jukebox %s:%s upload error: %s", j.bot.Host(), j.bot.Port(), err)
+	}
+}
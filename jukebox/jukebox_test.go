@@ -0,0 +1,122 @@
+package jukebox
+
+import (
+	"bytes"
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJukebox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jukebox")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 600 BPM, 1 BPI - интервал 100 мс
+	loop := bytes.Repeat([]byte("OggS"), 2500)
+	writeLoops(t, dir, map[string]string{
+		"blues.600.1.ogg": string(loop),
+		"blues.300.1.ogg": "OggS300",
+	})
+
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.OnLogin(ninjamtest.ConfigChangeNotify(600, 1))
+
+	bot := ninjam_bot.New(server.Host(), server.Port())
+	j := New(bot, dir)
+	j.Channel("backing")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go j.Run(ctx)
+	go bot.Connect(ctx)
+	defer bot.Stop()
+
+	inbox := ninjamtest.NewInbox(server)
+	chat := func() string {
+		text, err := inbox.Chat()
+		require.NoError(t, err)
+		return text
+	}
+
+	channels, err := inbox.Channels()
+	require.NoError(t, err)
+	require.Len(t, channels, 1)
+	assert.Equal(t, "backing", channels[0].Name)
+
+	server.Push(ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", "!jukebox list"))
+	assert.Equal(t, "Лупы (BPM/BPI): blues 300/1, blues 600/1", chat())
+
+	server.Push(ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", "!jukebox play blues"))
+	first, err := inbox.IntervalBegin()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), first.ChannelIndex)
+	assert.Equal(t, [4]byte{'O', 'G', 'G', 'v'}, first.FourCC)
+
+	write, err := inbox.IntervalWrite()
+	require.NoError(t, err)
+	assert.Equal(t, first.GUID, write.GUID)
+	assert.Equal(t, uint8(0), write.Flags)
+	assert.Equal(t, loop[:ninjam_bot.UploadChunkSize], write.AudioData)
+
+	write, err = inbox.IntervalWrite()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(1), write.Flags)
+	assert.Equal(t, loop[ninjam_bot.UploadChunkSize:], write.AudioData)
+
+	assert.Equal(t, "Играет blues (600 BPM, 1 BPI)", chat())
+
+	// следующий интервал - тот же луп с новым GUID
+	second, err := inbox.IntervalBegin()
+	require.NoError(t, err)
+	assert.NotEqual(t, first.GUID, second.GUID)
+
+	server.Push(ninjamtest.ConfigChangeNotify(300, 1))
+	for {
+		write, err := inbox.IntervalWrite()
+		require.NoError(t, err)
+		if string(write.AudioData) == "OggS300" {
+			assert.Equal(t, uint8(1), write.Flags)
+			break
+		}
+	}
+	assert.Equal(t, "Играет blues (300 BPM, 1 BPI)", chat())
+
+	// для нового темпа лупа нет - канал замолкает
+	server.Push(ninjamtest.ConfigChangeNotify(100, 16))
+	for {
+		begin, err := inbox.IntervalBegin()
+		require.NoError(t, err)
+		if begin.GUID == [16]byte{} {
+			break
+		}
+	}
+	assert.Equal(t, "Джукбокс остановлен: нет лупа blues для 100 BPM, 16 BPI", chat())
+
+	// ответы бота в чат уходят на сервер в любом порядке, поэтому команды шлём по одной
+	for _, command := range []struct{ text, reply string }{
+		{"!jukebox stop", "Джукбокс не играет"},
+		{"!jukebox play funk", "Не удалось включить funk: нет лупа funk для 100 BPM, 16 BPI"},
+		{"!jukebox", "Команды джукбокса: !jukebox list, !jukebox play НАЗВАНИЕ, !jukebox stop"},
+	} {
+		server.Push(ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", command.text))
+		assert.Equal(t, command.reply, chat())
+	}
+
+	// после остановки интервалы не отправляются, на обычные сообщения джукбокс не отвечает
+	server.Push(ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", "hello"))
+	time.Sleep(time.Millisecond * 200)
+	for _, f := range inbox.Pending() {
+		assert.NotEqual(t, models.ChatMessageType, f.Type)
+	}
+	_, err = server.Next(time.Millisecond * 100)
+	assert.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package jukebox
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Loop is OGG Vorbis file of one interval, file name is "<name>.<BPM>.<BPI>.ogg", e.g. "blues.120.16.ogg"
+type Loop struct {
+	Name string
+	BPM  uint
+	BPI  uint
+	Path string
+}
+
+// Scan returns loops in dir sorted by name, BPM and BPI, files with other names are skipped
+func Scan(dir string) ([]Loop, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	loops := make([]Loop, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		loop, ok := parseLoopName(file.Name())
+		if !ok {
+			continue
+		}
+		loop.Path = filepath.Join(dir, file.Name())
+
+		loops = append(loops, loop)
+	}
+
+	sort.Slice(loops, func(i, j int) bool {
+		if loops[i].Name != loops[j].Name {
+			return loops[i].Name < loops[j].Name
+		}
+		if loops[i].BPM != loops[j].BPM {
+			return loops[i].BPM < loops[j].BPM
+		}
+		return loops[i].BPI < loops[j].BPI
+	})
+
+	return loops, nil
+}
+
+// parseLoopName parses "<name>.<BPM>.<BPI>.ogg", name may contain dots
+func parseLoopName(fileName string) (Loop, bool) {
+	if !strings.EqualFold(filepath.Ext(fileName), ".ogg") {
+		return Loop{}, false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(fileName, filepath.Ext(fileName)), ".")
+	if len(parts) < 3 {
+		return Loop{}, false
+	}
+
+	bpm, err := strconv.ParseUint(parts[len(parts)-2], 10, 16)
+	if err != nil || bpm == 0 {
+		return Loop{}, false
+	}
+
+	bpi, err := strconv.ParseUint(parts[len(parts)-1], 10, 16)
+	if err != nil || bpi == 0 {
+		return Loop{}, false
+	}
+
+	name := strings.Join(parts[:len(parts)-2], ".")
+	if name == "" {
+		return Loop{}, false
+	}
+
+	return Loop{Name: name, BPM: uint(bpm), BPI: uint(bpi)}, true
+}
+
+// find returns loop with name for BPM and BPI
+func find(loops []Loop, name string, bpm, bpi uint) (Loop, bool) {
+	for _, loop := range loops {
+		if strings.EqualFold(loop.Name, name) && loop.BPM == bpm && loop.BPI == bpi {
+			return loop, true
+		}
+	}
+
+	return Loop{}, false
+}
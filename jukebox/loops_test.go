@@ -0,0 +1,52 @@
+package jukebox
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeLoops(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jukebox")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeLoops(t, dir, map[string]string{
+		"blues.120.16.ogg":    "OggS",
+		"blues.90.16.ogg":     "OggS",
+		"slow.blues.60.8.OGG": "OggS",
+		"funk.ogg":            "OggS",
+		"funk.0.16.ogg":       "OggS",
+		"funk.100.x.ogg":      "OggS",
+		"readme.txt":          "loops",
+	})
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "rock.120.16.ogg"), 0755))
+
+	loops, err := Scan(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []Loop{
+		{Name: "blues", BPM: 90, BPI: 16, Path: filepath.Join(dir, "blues.90.16.ogg")},
+		{Name: "blues", BPM: 120, BPI: 16, Path: filepath.Join(dir, "blues.120.16.ogg")},
+		{Name: "slow.blues", BPM: 60, BPI: 8, Path: filepath.Join(dir, "slow.blues.60.8.OGG")},
+	}, loops)
+
+	loop, ok := find(loops, "Blues", 120, 16)
+	assert.True(t, ok)
+	assert.Equal(t, "blues", loop.Name)
+	assert.Equal(t, uint(120), loop.BPM)
+
+	_, ok = find(loops, "blues", 100, 16)
+	assert.False(t, ok)
+
+	_, err = Scan(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
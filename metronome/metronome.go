@@ -0,0 +1,202 @@
+// Package metronome uploads click pattern to NINJAM server on its own channel at every interval,
+// so musicians hear the server's BPM/BPI and the first beat of interval is accented.
+//
+// The pattern is taken from patterns directory: pre-rendered "pattern.<BPM>.<BPI>.ogg" if it exists,
+// otherwise it's assembled from one-shot OGG Vorbis sounds "accent.ogg" (the first beat)
+// and "click.ogg" (other beats) without re-encoding. The pattern is switched when server's tempo changes,
+// metronome pauses at tempo it has no pattern for and plays again when the tempo changes.
+//
+// Metronome is controlled from NINJAM chat: "<command> on" and "<command> off", command is "!metronome" by default.
+package metronome
+
+import (
+	"context"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	// DefaultChannel is the name of bot's channel
+	DefaultChannel = "metronome"
+	// DefaultCommand is the prefix of chat commands
+	DefaultCommand = "!metronome"
+)
+
+// Metronome uploads click pattern to bot's server
+type Metronome struct {
+	bot     *ninjam_bot.NinJamBot
+	dir     string
+	channel string
+	command string
+	volume  int16
+	events  <-chan ninjam_bot.Event
+
+	// состояние меняется только в горутине Run
+	on      bool
+	index   uint8 // index of the metronome channel, bot may have other channels
+	bpm     uint
+	bpi     uint
+	pattern []byte
+	ticker  ninjam_bot.IntervalTicker
+}
+
+// New creates metronome with patterns from dir, it subscribes to bot's events, so it must be created before bot connects
+func New(bot *ninjam_bot.NinJamBot, dir string) *Metronome {
+	return &Metronome{
+		bot:     bot,
+		dir:     dir,
+		channel: DefaultChannel,
+		command: DefaultCommand,
+		events:  bot.Subscribe(),
+	}
+}
+
+// Channel sets the name of bot's channel, DefaultChannel by default
+func (m *Metronome) Channel(name string) {
+	m.channel = name
+}
+
+// Command sets the prefix of chat commands, DefaultCommand by default
+func (m *Metronome) Command(command string) {
+	m.command = command
+}
+
+// Volume sets volume of bot's channel in 0.1 dB, e.g. -60 is -6 dB, 0 by default
+func (m *Metronome) Volume(volume int16) {
+	m.volume = volume
+}
+
+// On turns metronome on when server's tempo becomes known, it must be called before Run
+func (m *Metronome) On() {
+	m.on = true
+}
+
+// Run handles bot's events and uploads pattern until ctx is cancelled
+func (m *Metronome) Run(ctx context.Context) {
+	defer m.bot.Unsubscribe(m.events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.stop()
+			return
+		case event := <-m.events:
+			m.handle(event)
+		case <-m.ticker.C():
+			m.upload()
+			m.ticker.Next()
+		}
+	}
+}
+
+func (m *Metronome) handle(event ninjam_bot.Event) {
+	switch event := event.(type) {
+	case ninjam_bot.AuthEvent:
+		// после переподключения сервер не помнит каналы бота
+		if event.Success {
+			m.bot.ChannelInitExtended(m.channel, 0, m.volume, 0)
+			m.index, _ = m.bot.ChannelIndex(m.channel)
+		}
+	case ninjam_bot.ConfigChangeEvent:
+		if event.BPM == m.bpm && event.BPI == m.bpi {
+			return
+		}
+		m.bpm, m.bpi = event.BPM, event.BPI
+
+		if !m.on {
+			return
+		}
+
+		if err := m.start(); err != nil {
+			// метроном остаётся включённым и продолжит играть, когда темп сменится на тот, для которого есть паттерн
+			m.pause()
+			m.reply(fmt.Sprintf("Метроном приостановлен: %s", err))
+			return
+		}
+		logrus.Infof("Metronome %s:%s plays %d BPM, %d BPI", m.bot.Host(), m.bot.Port(), m.bpm, m.bpi)
+	case ninjam_bot.ChatEvent:
+		m.handleChat(event.Message)
+	}
+}
+
+func (m *Metronome) handleChat(msg models.Message) {
+	if msg.Type != models.MSG || strings.HasPrefix(msg.Name, m.bot.UserName()) {
+		return
+	}
+
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 || fields[0] != m.command {
+		return
+	}
+
+	switch {
+	case len(fields) == 2 && fields[1] == "on":
+		if err := m.start(); err != nil {
+			m.reply(fmt.Sprintf("Не удалось включить метроном: %s", err))
+			return
+		}
+		m.on = true
+		logrus.Infof("Metronome %s:%s is turned on by %s", m.bot.Host(), m.bot.Port(), msg.Name)
+		m.reply(fmt.Sprintf("Метроном включён (%d BPM, %d BPI)", m.bpm, m.bpi))
+	case len(fields) == 2 && fields[1] == "off":
+		if !m.on {
+			m.reply("Метроном не включён")
+			return
+		}
+		m.stop()
+		logrus.Infof("Metronome %s:%s is turned off by %s", m.bot.Host(), m.bot.Port(), msg.Name)
+		m.reply("Метроном выключен")
+	default:
+		m.reply(fmt.Sprintf("Команды метронома: %[1]s on, %[1]s off", m.command))
+	}
+}
+
+func (m *Metronome) reply(message string) {
+	m.bot.SendMessage(message)
+}
+
+// start uploads pattern for current BPM/BPI from new interval
+func (m *Metronome) start() error {
+	pattern, err := Pattern(m.dir, m.bpm, m.bpi)
+	if err != nil {
+		return err
+	}
+
+	m.pattern = pattern
+
+	m.upload()
+	m.ticker.Start(m.bpm, m.bpi)
+
+	return nil
+}
+
+// stop turns metronome off
+func (m *Metronome) stop() {
+	if !m.on {
+		return
+	}
+	m.on = false
+
+	m.pause()
+}
+
+// pause stops uploads and tells listeners that the channel is silent, metronome stays on
+func (m *Metronome) pause() {
+	m.ticker.Stop()
+	if m.pattern == nil {
+		return
+	}
+	m.pattern = nil
+
+	m.bot.SilenceInterval(m.index)
+}
+
+// upload sends the pattern as one interval
+func (m *Metronome) upload() {
+	if err := m.bot.UploadInterval(m.index, m.pattern); err != nil && err != ninjam_bot.ErrNotConnected {
+		logrus.Errorf("Metronome %s:%s upload error: %s", m.bot.Host(), m.bot.Port(), err)
+	}
+}
@@ -0,0 +1,179 @@
+package metronome
+
+import (
+	"bytes"
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetronome(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metronome")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 600 BPM, 1 BPI - интервал 100 мс
+	for name, data := range map[string]string{
+		PatternName(600, 1): "OggS600",
+		PatternName(300, 1): "OggS300",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.OnLogin(ninjamtest.ConfigChangeNotify(600, 1))
+
+	bot := ninjam_bot.New(server.Host(), server.Port())
+	m := New(bot, dir)
+	m.Volume(-60)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	go bot.Connect(ctx)
+	defer bot.Stop()
+
+	inbox := ninjamtest.NewInbox(server)
+	chat := func() string {
+		text, err := inbox.Chat()
+		require.NoError(t, err)
+		return text
+	}
+
+	channels, err := inbox.Channels()
+	require.NoError(t, err)
+	require.Len(t, channels, 1)
+	assert.Equal(t, "metronome", channels[0].Name)
+	assert.Equal(t, int16(-60), channels[0].Volume)
+
+	server.Push(ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", "!metronome on"))
+	first, err := inbox.IntervalBegin()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), first.ChannelIndex)
+
+	write, err := inbox.IntervalWrite()
+	require.NoError(t, err)
+	assert.Equal(t, first.GUID, write.GUID)
+	assert.Equal(t, uint8(1), write.Flags)
+	assert.Equal(t, "OggS600", string(write.AudioData))
+
+	assert.Equal(t, "Метроном включён (600 BPM, 1 BPI)", chat())
+
+	// следующий интервал - тот же паттерн с новым GUID
+	second, err := inbox.IntervalBegin()
+	require.NoError(t, err)
+	assert.NotEqual(t, first.GUID, second.GUID)
+
+	server.Push(ninjamtest.ConfigChangeNotify(300, 1))
+	for {
+		write, err := inbox.IntervalWrite()
+		require.NoError(t, err)
+		if string(write.AudioData) == "OggS300" {
+			assert.Equal(t, uint8(1), write.Flags)
+			break
+		}
+	}
+
+	// ответы бота в чат уходят на сервер в любом порядке, поэтому команды шлём по одной
+	command := func(text, reply string) {
+		server.Push(ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", text))
+		assert.Equal(t, reply, chat())
+	}
+	// метроном отправляет интервал тишины, после него интервалов с паттерном нет
+	silence := func() {
+		for {
+			begin, err := inbox.IntervalBegin()
+			require.NoError(t, err)
+			if begin.GUID == [16]byte{} {
+				break
+			}
+		}
+	}
+
+	// для нового темпа нет ни паттерна, ни звуков - метроном приостанавливается
+	server.Push(ninjamtest.ConfigChangeNotify(100, 16))
+	silence()
+	assert.Equal(t, "Метроном приостановлен: нет паттерна pattern.100.16.ogg и звука accent.ogg", chat())
+
+	command("!metronome on", "Не удалось включить метроном: нет паттерна pattern.100.16.ogg и звука accent.ogg")
+	command("!metronome", "Команды метронома: !metronome on, !metronome off")
+
+	// темп, для которого есть паттерн, - метроном играет снова без команды
+	server.Push(ninjamtest.ConfigChangeNotify(600, 1))
+	for {
+		write, err := inbox.IntervalWrite()
+		require.NoError(t, err)
+		if string(write.AudioData) == "OggS600" {
+			break
+		}
+	}
+
+	command("!metronome off", "Метроном выключен")
+	silence()
+	command("!metronome off", "Метроном не включён")
+
+	// выключенный метроном интервалы не отправляет
+	inbox.Timeout = time.Millisecond * 300
+	_, err = inbox.IntervalBegin()
+	assert.Error(t, err)
+}
+
+func TestMetronome_On(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metronome")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data, _ := readSoundFile(t)
+	for _, name := range []string{AccentName, ClickName} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0644))
+	}
+
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.OnLogin(ninjamtest.ConfigChangeNotify(600, 2))
+
+	bot := ninjam_bot.New(server.Host(), server.Port())
+	m := New(bot, dir)
+	m.Channel("click")
+	m.On()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+	go bot.Connect(ctx)
+	defer bot.Stop()
+
+	inbox := ninjamtest.NewInbox(server)
+	channels, err := inbox.Channels()
+	require.NoError(t, err)
+	assert.Equal(t, "click", channels[0].Name)
+
+	// паттерн собран из звуков и включён сразу, без команды
+	begin, err := inbox.IntervalBegin()
+	require.NoError(t, err)
+	var pattern []byte
+	for {
+		write, err := inbox.IntervalWrite()
+		require.NoError(t, err)
+		assert.Equal(t, begin.GUID, write.GUID)
+		pattern = append(pattern, write.AudioData...)
+		if write.Flags == 1 {
+			break
+		}
+	}
+
+	samples, _, err := oggvorbis.ReadAll(bytes.NewReader(pattern))
+	require.NoError(t, err)
+	// 600 BPM, 2 BPI - 0.2 с
+	assert.Len(t, samples, 8820)
+}
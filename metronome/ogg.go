@@ -0,0 +1,170 @@
+package metronome
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	oggHeaderSize = 27
+	// oggPageData - pages are flushed when they have at least this many bytes of packets
+	oggPageData = 4096
+
+	oggFlagContinued = 0x01
+	oggFlagFirst     = 0x02
+	oggFlagLast      = 0x04
+)
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggPacket is packet of logical stream with granule position after it
+type oggPacket struct {
+	data    []byte
+	granule int64
+}
+
+// readOgg returns packets of the first logical stream in data
+func readOgg(data []byte) ([][]byte, error) {
+	var packets [][]byte
+	var packet []byte
+	var serial uint32
+
+	for first := true; len(data) > 0; first = false {
+		if len(data) < oggHeaderSize || !bytes.Equal(data[:4], []byte("OggS")) {
+			return nil, errors.New("ogg: bad page header")
+		}
+
+		segmentCount := int(data[26])
+		if len(data) < oggHeaderSize+segmentCount {
+			return nil, errors.New("ogg: unexpected end of data")
+		}
+		segments := data[oggHeaderSize : oggHeaderSize+segmentCount]
+
+		size := 0
+		for _, s := range segments {
+			size += int(s)
+		}
+		body := data[oggHeaderSize+segmentCount:]
+		if len(body) < size {
+			return nil, errors.New("ogg: unexpected end of data")
+		}
+
+		pageSerial := binary.LittleEndian.Uint32(data[14:18])
+		data = body[size:]
+
+		if first {
+			serial = pageSerial
+		} else if pageSerial != serial {
+			// другие логические потоки не нужны
+			continue
+		}
+
+		for _, s := range segments {
+			packet = append(packet, body[:s]...)
+			body = body[s:]
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+
+	if len(packets) == 0 {
+		return nil, errors.New("ogg: no packets")
+	}
+
+	return packets, nil
+}
+
+// writeOgg returns logical stream of packets, every group starts on a new page
+func writeOgg(serial uint32, groups ...[]oggPacket) []byte {
+	var out bytes.Buffer
+	var sequence uint32
+
+	writePage := func(flags byte, granule int64, segments, data []byte) {
+		header := make([]byte, oggHeaderSize, oggHeaderSize+len(segments))
+		copy(header, "OggS")
+		header[5] = flags
+		binary.LittleEndian.PutUint64(header[6:14], uint64(granule))
+		binary.LittleEndian.PutUint32(header[14:18], serial)
+		binary.LittleEndian.PutUint32(header[18:22], sequence)
+		header[26] = byte(len(segments))
+		header = append(header, segments...)
+
+		page := append(header, data...)
+		binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+		out.Write(page)
+
+		sequence++
+	}
+
+	continued := false
+	for g, group := range groups {
+		var segments, data []byte
+		granule := int64(-1)
+
+		for p, packet := range group {
+			rest := packet.data
+			for {
+				n := len(rest)
+				if n > 255 {
+					n = 255
+				}
+				segments = append(segments, byte(n))
+				data = append(data, rest[:n]...)
+				rest = rest[n:]
+
+				done := n < 255
+				if done {
+					granule = packet.granule
+				}
+
+				groupEnd := done && p == len(group)-1
+				if len(segments) == 255 || groupEnd || (done && len(data) >= oggPageData) {
+					var flags byte
+					if sequence == 0 {
+						flags |= oggFlagFirst
+					}
+					if continued {
+						flags |= oggFlagContinued
+					}
+					if groupEnd && g == len(groups)-1 {
+						flags |= oggFlagLast
+					}
+
+					writePage(flags, granule, segments, data)
+					segments, data, granule = nil, nil, -1
+					continued = !done
+				}
+
+				if done {
+					break
+				}
+			}
+		}
+	}
+
+	return out.Bytes()
+}
@@ -0,0 +1,53 @@
+package metronome
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestOggCRC(t *testing.T) {
+	data, _ := readSoundFile(t)
+
+	// CRC первой страницы считается с нулями на месте CRC
+	size := oggHeaderSize + int(data[26])
+	for _, s := range data[oggHeaderSize:size] {
+		size += int(s)
+	}
+	page := append([]byte(nil), data[:size]...)
+	expected := binary.LittleEndian.Uint32(page[22:26])
+	copy(page[22:26], []byte{0, 0, 0, 0})
+
+	assert.Equal(t, expected, oggCRC(page))
+}
+
+func TestWriteOgg(t *testing.T) {
+	big := bytes.Repeat([]byte{1, 2, 3}, 30000) // больше 255 сегментов - продолжается на следующей странице
+	exact := bytes.Repeat([]byte{4}, 510)       // кратен 255 - заканчивается нулевым сегментом
+
+	stream := writeOgg(1, []oggPacket{{data: []byte("header")}}, []oggPacket{
+		{data: big, granule: 100},
+		{data: exact, granule: 200},
+		{data: []byte{}, granule: 300},
+	})
+
+	packets, err := readOgg(stream)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("header"), big, exact, nil}, packets)
+
+	var flags []byte
+	var granules []int64
+	for data := stream; len(data) > 0; {
+		size := oggHeaderSize + int(data[26])
+		for _, s := range data[oggHeaderSize:size] {
+			size += int(s)
+		}
+		flags = append(flags, data[5])
+		granules = append(granules, int64(binary.LittleEndian.Uint64(data[6:14])))
+		data = data[size:]
+	}
+	assert.Equal(t, []byte{oggFlagFirst, 0, oggFlagContinued, oggFlagLast}, flags)
+	assert.Equal(t, []int64{0, -1, 100, 300}, granules)
+}
@@ -0,0 +1,195 @@
+package metronome
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// AccentName is the sound of the first beat of interval
+	AccentName = "accent.ogg"
+	// ClickName is the sound of other beats
+	ClickName = "click.ogg"
+)
+
+// PatternName returns file name of pre-rendered pattern for BPM and BPI, e.g. "pattern.120.16.ogg"
+func PatternName(bpm, bpi uint) string {
+	return fmt.Sprintf("pattern.%d.%d.ogg", bpm, bpi)
+}
+
+// Pattern returns OGG Vorbis click pattern of one interval. Pre-rendered pattern from dir is used if it exists,
+// otherwise the pattern is assembled from accent and click sounds in dir
+func Pattern(dir string, bpm, bpi uint) ([]byte, error) {
+	if bpm == 0 || bpi == 0 {
+		return nil, fmt.Errorf("темп сервера неизвестен")
+	}
+
+	pattern, err := ioutil.ReadFile(filepath.Join(dir, PatternName(bpm, bpi)))
+	if err == nil && len(pattern) > 0 {
+		return pattern, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	accent, err := ioutil.ReadFile(filepath.Join(dir, AccentName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("нет паттерна %s и звука %s", PatternName(bpm, bpi), AccentName)
+		}
+		return nil, err
+	}
+
+	click, err := ioutil.ReadFile(filepath.Join(dir, ClickName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("нет паттерна %s и звука %s", PatternName(bpm, bpi), ClickName)
+		}
+		return nil, err
+	}
+
+	return assemble(accent, click, bpm, bpi)
+}
+
+// block is Vorbis audio packet with its block size
+type block struct {
+	data    []byte
+	size    int
+	long    bool // long blocks have window flags
+	granule int64
+}
+
+// sound is one-shot OGG Vorbis sound split to packets
+type sound struct {
+	headers [][]byte // identification, comment and setup headers
+	packets [][]byte
+	blocks  []block
+}
+
+func readSound(name string, data []byte) (*sound, error) {
+	packets, err := readOgg(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	if len(packets) < 4 || !isVorbisHeader(packets[0], 1) || !isVorbisHeader(packets[1], 3) || !isVorbisHeader(packets[2], 5) {
+		return nil, fmt.Errorf("%s: not an OGG Vorbis sound", name)
+	}
+
+	return &sound{headers: packets[:3], packets: packets[3:]}, nil
+}
+
+// assemble makes pattern of BPI beats from packets of accent and click sounds, accent is the first beat.
+//
+// Vorbis audio can't be cut at any sample without re-encoding, so pattern is built of whole packets:
+// sound packets are copied as is and pauses are filled with packets of silence. Beats start at most
+// one short block (a few milliseconds) later than they should, the error doesn't accumulate and
+// the pattern length is exact thanks to granule position of the last page. Sounds must be encoded
+// with the same settings, sounds longer than a beat are cut.
+func assemble(accentData, clickData []byte, bpm, bpi uint) ([]byte, error) {
+	accent, err := readSound(AccentName, accentData)
+	if err != nil {
+		return nil, err
+	}
+	click, err := readSound(ClickName, clickData)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(accent.headers[0], click.headers[0]) || !bytes.Equal(accent.headers[2], click.headers[2]) {
+		return nil, fmt.Errorf("%s и %s закодированы с разными настройками", AccentName, ClickName)
+	}
+
+	stream, err := parseVorbis(accent.headers[0], accent.headers[2])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range []*sound{accent, click} {
+		for _, packet := range s.packets {
+			b, err := stream.block(packet)
+			if err != nil {
+				return nil, err
+			}
+			s.blocks = append(s.blocks, b)
+		}
+	}
+
+	silence, err := stream.block(stream.silence())
+	if err != nil {
+		return nil, err
+	}
+
+	// beatStart returns the first sample of beat
+	beatStart := func(beat uint) int64 {
+		return int64(beat) * 60 * int64(stream.sampleRate) / int64(bpm)
+	}
+	length := beatStart(bpi)
+
+	var blocks []block
+	var position int64 // granule position after the last block
+
+	// lead returns number of samples which decoder outputs for block added after the last one
+	lead := func(size int) int64 {
+		if len(blocks) == 0 {
+			return 0
+		}
+		return int64(blocks[len(blocks)-1].size/4 + size/4)
+	}
+	add := func(b block) {
+		position += lead(b.size)
+		b.granule = position
+		blocks = append(blocks, b)
+	}
+
+	for beat := uint(0); beat < bpi; beat++ {
+		s := click
+		if beat == 0 {
+			s = accent
+		}
+
+		// звук начинается с выхода своего первого пакета
+		for position+lead(s.blocks[0].size) < beatStart(beat) {
+			add(silence)
+		}
+
+		for i, b := range s.blocks {
+			end := position + lead(b.size)
+			// звук длиннее доли обрезаем, чтобы следующая доля началась вовремя
+			if beat+1 < bpi && i > 0 && end+int64(b.size/4+click.blocks[0].size/4) > beatStart(beat+1) {
+				break
+			}
+			if beat+1 == bpi && i > 0 && end > length {
+				break
+			}
+			add(b)
+		}
+	}
+
+	for position < length {
+		add(silence)
+	}
+	// декодер отбрасывает сэмплы после granule position последней страницы
+	blocks[len(blocks)-1].granule = length
+
+	audio := make([]oggPacket, 0, len(blocks))
+	for i, b := range blocks {
+		data := b.data
+		if b.long {
+			// окна длинного блока должны соответствовать соседям в паттерне, а не в исходном звуке
+			data = append([]byte(nil), data...)
+			prevLong := i == 0 || blocks[i-1].long
+			nextLong := i == len(blocks)-1 || blocks[i+1].long
+			stream.setWindowFlags(data, prevLong, nextLong)
+		}
+		audio = append(audio, oggPacket{data: data, granule: b.granule})
+	}
+
+	return writeOgg(uint32(bpm)<<16|uint32(bpi),
+		[]oggPacket{{data: accent.headers[0]}},
+		[]oggPacket{{data: accent.headers[1]}, {data: accent.headers[2]}},
+		audio,
+	), nil
+}
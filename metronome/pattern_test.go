@@ -0,0 +1,114 @@
+package metronome
+
+import (
+	"bytes"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readSoundFile(t *testing.T) ([]byte, []float32) {
+	data := ninjamtest.Sound()
+
+	samples, _, err := oggvorbis.ReadAll(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	return data, samples
+}
+
+// onset returns shift of sound in samples from start, sound is compared after the first block
+func onset(t *testing.T, samples, sound []float32, start, length int) int {
+	for shift := 0; shift < 256; shift++ {
+		matches := true
+		for i := 2048; i < length && matches; i++ {
+			matches = math.Abs(float64(samples[start+shift+i]-sound[i])) < 1e-3
+		}
+		if matches {
+			return shift
+		}
+	}
+
+	t.Fatalf("no sound at %d", start)
+	return 0
+}
+
+func TestAssemble(t *testing.T) {
+	data, sound := readSoundFile(t)
+
+	// 40 BPM - доля 1.5 с, звук 1 с и пауза
+	pattern, err := assemble(data, data, 40, 2)
+	require.NoError(t, err)
+
+	samples, format, err := oggvorbis.ReadAll(bytes.NewReader(pattern))
+	require.NoError(t, err)
+	assert.Equal(t, 44100, format.SampleRate)
+	require.Len(t, samples, 44100*3)
+
+	assert.Equal(t, 0, onset(t, samples, sound, 0, len(sound)))
+	assert.True(t, onset(t, samples, sound, 66150, len(sound)-256) < 128)
+
+	// перед звуком декодер выдаёт половину его первого блока
+	for _, pause := range [][2]int{{44100 + 2048, 66150 - 1024}, {66150 + 44100 + 2048, len(samples)}} {
+		for i := pause[0]; i < pause[1]; i++ {
+			require.True(t, math.Abs(float64(samples[i])) < 1e-4, "sample %d is not silent", i)
+		}
+	}
+
+	// 120 BPM - звук длиннее доли обрезается
+	pattern, err = assemble(data, data, 120, 4)
+	require.NoError(t, err)
+
+	samples, _, err = oggvorbis.ReadAll(bytes.NewReader(pattern))
+	require.NoError(t, err)
+	require.Len(t, samples, 44100*2)
+
+	for beat := 0; beat < 4; beat++ {
+		assert.True(t, onset(t, samples, sound, beat*22050, 16384) < 128, "beat %d", beat)
+	}
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	data, _ := readSoundFile(t)
+
+	_, err := assemble([]byte("OggS"), data, 120, 4)
+	assert.EqualError(t, err, "accent.ogg: ogg: bad page header")
+
+	_, err = assemble(data, data[:100], 120, 4)
+	assert.EqualError(t, err, "click.ogg: ogg: unexpected end of data")
+}
+
+func TestPattern(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metronome")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = Pattern(dir, 120, 4)
+	assert.EqualError(t, err, "нет паттерна pattern.120.4.ogg и звука accent.ogg")
+
+	data, _ := readSoundFile(t)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, AccentName), data, 0644))
+	_, err = Pattern(dir, 120, 4)
+	assert.EqualError(t, err, "нет паттерна pattern.120.4.ogg и звука click.ogg")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ClickName), data, 0644))
+	pattern, err := Pattern(dir, 120, 4)
+	require.NoError(t, err)
+	expected, err := assemble(data, data, 120, 4)
+	require.NoError(t, err)
+	assert.Equal(t, expected, pattern)
+
+	// готовый паттерн важнее звуков
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, PatternName(120, 4)), []byte("OggS"), 0644))
+	pattern, err = Pattern(dir, 120, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("OggS"), pattern)
+
+	_, err = Pattern(dir, 0, 4)
+	assert.EqualError(t, err, "темп сервера неизвестен")
+}
@@ -0,0 +1,438 @@
+package metronome
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Vorbis I specification: https://xiph.org/vorbis/doc/Vorbis_I_spec.html
+// Из setup-заголовка нужно немного: размеры блоков и режимы, чтобы считать сэмплы в пакетах,
+// и типы floor каналов, чтобы собрать пакет тишины.
+
+var errVorbisSetup = errors.New("vorbis: bad setup header")
+
+type vorbisFloor struct {
+	floorType     int
+	amplitudeBits int // floor 0 only
+}
+
+type vorbisMapping struct {
+	mux    []int // submap of channel
+	floors []int // floor of submap
+}
+
+type vorbisMode struct {
+	long    bool
+	mapping int
+}
+
+// vorbisStream is what assembler needs to know about stream from its headers
+type vorbisStream struct {
+	channels   int
+	sampleRate int
+	blockSizes [2]int
+	floors     []vorbisFloor
+	mappings   []vorbisMapping
+	modes      []vorbisMode
+}
+
+// parseVorbis parses identification and setup headers
+func parseVorbis(identification, setup []byte) (*vorbisStream, error) {
+	if len(identification) < 30 || !isVorbisHeader(identification, 1) {
+		return nil, errors.New("vorbis: bad identification header")
+	}
+
+	s := &vorbisStream{
+		channels:   int(identification[11]),
+		sampleRate: int(binary.LittleEndian.Uint32(identification[12:16])),
+		blockSizes: [2]int{1 << (identification[28] & 0x0f), 1 << (identification[28] >> 4)},
+	}
+	if s.channels == 0 || s.sampleRate == 0 {
+		return nil, errors.New("vorbis: bad identification header")
+	}
+
+	if !isVorbisHeader(setup, 5) {
+		return nil, errVorbisSetup
+	}
+	r := &bitReader{data: setup[7:]}
+
+	for i, count := 0, r.read(8)+1; i < count; i++ {
+		if err := skipCodebook(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// time domain transforms, не используются
+	for i, count := 0, r.read(6)+1; i < count; i++ {
+		if r.read(16) != 0 {
+			return nil, errVorbisSetup
+		}
+	}
+
+	for i, count := 0, r.read(6)+1; i < count; i++ {
+		floor, err := readFloor(r)
+		if err != nil {
+			return nil, err
+		}
+		s.floors = append(s.floors, floor)
+	}
+
+	for i, count := 0, r.read(6)+1; i < count; i++ {
+		if err := skipResidue(r); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, count := 0, r.read(6)+1; i < count; i++ {
+		mapping, err := readMapping(r, s.channels, len(s.floors))
+		if err != nil {
+			return nil, err
+		}
+		s.mappings = append(s.mappings, mapping)
+	}
+
+	for i, count := 0, r.read(6)+1; i < count; i++ {
+		mode := vorbisMode{long: r.read(1) == 1}
+		r.read(16) // window type
+		r.read(16) // transform type
+		mode.mapping = r.read(8)
+		if mode.mapping >= len(s.mappings) {
+			return nil, errVorbisSetup
+		}
+		s.modes = append(s.modes, mode)
+	}
+
+	if r.read(1) != 1 || r.eof {
+		return nil, errVorbisSetup
+	}
+
+	return s, nil
+}
+
+func isVorbisHeader(packet []byte, packetType byte) bool {
+	return len(packet) > 7 && packet[0] == packetType && bytes.Equal(packet[1:7], []byte("vorbis"))
+}
+
+func skipCodebook(r *bitReader) error {
+	if r.read(24) != 0x564342 {
+		return errVorbisSetup
+	}
+	dimensions := r.read(16)
+	entries := r.read(24)
+
+	if r.read(1) == 0 {
+		// unordered
+		sparse := r.read(1) == 1
+		for i := 0; i < entries && !r.eof; i++ {
+			if !sparse || r.read(1) == 1 {
+				r.read(5)
+			}
+		}
+	} else {
+		// ordered
+		length := r.read(5) + 1
+		for current := 0; current < entries && !r.eof; length++ {
+			if length > 32 {
+				return errVorbisSetup
+			}
+			current += r.read(ilog(entries - current))
+		}
+	}
+
+	switch lookupType := r.read(4); lookupType {
+	case 0:
+	case 1, 2:
+		r.read(32) // minimum value
+		r.read(32) // delta value
+		valueBits := r.read(4) + 1
+		r.read(1) // sequence_p
+
+		values := entries * dimensions
+		if lookupType == 1 {
+			values = lookup1Values(entries, dimensions)
+		}
+		for i := 0; i < values && !r.eof; i++ {
+			r.read(valueBits)
+		}
+	default:
+		return errVorbisSetup
+	}
+
+	if r.eof {
+		return errVorbisSetup
+	}
+	return nil
+}
+
+func readFloor(r *bitReader) (vorbisFloor, error) {
+	floor := vorbisFloor{floorType: r.read(16)}
+
+	switch floor.floorType {
+	case 0:
+		r.read(8)  // order
+		r.read(16) // rate
+		r.read(16) // bark map size
+		floor.amplitudeBits = r.read(6)
+		r.read(8) // amplitude offset
+		for i, books := 0, r.read(4)+1; i < books; i++ {
+			r.read(8)
+		}
+	case 1:
+		partitions := r.read(5)
+		classes := make([]int, partitions)
+		maxClass := -1
+		for i := range classes {
+			classes[i] = r.read(4)
+			if classes[i] > maxClass {
+				maxClass = classes[i]
+			}
+		}
+
+		dimensions := make([]int, maxClass+1)
+		for i := range dimensions {
+			dimensions[i] = r.read(3) + 1
+			subclasses := r.read(2)
+			if subclasses > 0 {
+				r.read(8) // master book
+			}
+			for j := 0; j < 1<<subclasses; j++ {
+				r.read(8)
+			}
+		}
+
+		r.read(2) // multiplier
+		rangeBits := r.read(4)
+		for _, class := range classes {
+			for j := 0; j < dimensions[class]; j++ {
+				r.read(rangeBits)
+			}
+		}
+	default:
+		return floor, fmt.Errorf("vorbis: unknown floor type %d", floor.floorType)
+	}
+
+	return floor, nil
+}
+
+func skipResidue(r *bitReader) error {
+	if r.read(16) > 2 {
+		return errVorbisSetup
+	}
+	r.read(24) // begin
+	r.read(24) // end
+	r.read(24) // partition size
+	classifications := r.read(6) + 1
+	r.read(8) // classbook
+
+	cascades := make([]int, classifications)
+	for i := range cascades {
+		cascades[i] = r.read(3)
+		if r.read(1) == 1 {
+			cascades[i] |= r.read(5) << 3
+		}
+	}
+	for _, cascade := range cascades {
+		for j := 0; j < 8; j++ {
+			if cascade&(1<<j) != 0 {
+				r.read(8)
+			}
+		}
+	}
+
+	return nil
+}
+
+func readMapping(r *bitReader, channels, floors int) (vorbisMapping, error) {
+	if r.read(16) != 0 {
+		return vorbisMapping{}, errVorbisSetup
+	}
+
+	submaps := 1
+	if r.read(1) == 1 {
+		submaps = r.read(4) + 1
+	}
+
+	if r.read(1) == 1 {
+		// coupling
+		bits := ilog(channels - 1)
+		for i, steps := 0, r.read(8)+1; i < steps; i++ {
+			r.read(bits) // magnitude
+			r.read(bits) // angle
+		}
+	}
+
+	if r.read(2) != 0 {
+		return vorbisMapping{}, errVorbisSetup
+	}
+
+	mapping := vorbisMapping{mux: make([]int, channels), floors: make([]int, submaps)}
+	if submaps > 1 {
+		for i := range mapping.mux {
+			mapping.mux[i] = r.read(4)
+			if mapping.mux[i] >= submaps {
+				return vorbisMapping{}, errVorbisSetup
+			}
+		}
+	}
+	for i := range mapping.floors {
+		r.read(8) // time config, не используется
+		mapping.floors[i] = r.read(8)
+		r.read(8) // residue
+		if mapping.floors[i] >= floors {
+			return vorbisMapping{}, errVorbisSetup
+		}
+	}
+
+	return mapping, nil
+}
+
+func (s *vorbisStream) modeBits() int {
+	return ilog(len(s.modes) - 1)
+}
+
+// block returns audio packet with its block size
+func (s *vorbisStream) block(packet []byte) (block, error) {
+	r := &bitReader{data: packet}
+	if r.read(1) != 0 {
+		return block{}, errors.New("vorbis: not an audio packet")
+	}
+
+	mode := r.read(s.modeBits())
+	if r.eof || mode >= len(s.modes) {
+		return block{}, errors.New("vorbis: bad audio packet")
+	}
+
+	b := block{data: packet, size: s.blockSizes[0], long: s.modes[mode].long}
+	if b.long {
+		b.size = s.blockSizes[1]
+	}
+	return b, nil
+}
+
+// setWindowFlags sets flags of long block packet which tell decoder sizes of previous and next blocks,
+// packet is changed in place
+func (s *vorbisStream) setWindowFlags(packet []byte, prevLong, nextLong bool) {
+	bit := 1 + s.modeBits()
+	for _, long := range []bool{prevLong, nextLong} {
+		if bit/8 >= len(packet) {
+			return
+		}
+		mask := byte(1) << (bit % 8)
+		if long {
+			packet[bit/8] |= mask
+		} else {
+			packet[bit/8] &^= mask
+		}
+		bit++
+	}
+}
+
+// silence returns audio packet with unused floors in all channels, it decodes to zeros.
+// Short block is used if stream has short block mode
+func (s *vorbisStream) silence() []byte {
+	mode := 0
+	for i, m := range s.modes {
+		if !m.long {
+			mode = i
+			break
+		}
+	}
+
+	w := &bitWriter{}
+	w.write(0, 1) // audio packet
+	w.write(mode, s.modeBits())
+	if s.modes[mode].long {
+		w.write(1, 1) // previous window flag
+		w.write(1, 1) // next window flag
+	}
+
+	mapping := s.mappings[s.modes[mode].mapping]
+	for channel := 0; channel < s.channels; channel++ {
+		floor := s.floors[mapping.floors[mapping.mux[channel]]]
+		if floor.floorType == 0 {
+			// нулевая амплитуда - floor не используется
+			w.write(0, floor.amplitudeBits)
+		} else {
+			// nonzero = 0
+			w.write(0, 1)
+		}
+	}
+
+	return w.data
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint
+	eof  bool
+}
+
+// read reads n bits (n <= 32) LSB first, after the end of data eof is set and zeros are returned
+func (r *bitReader) read(n int) int {
+	var value uint32
+	for i := 0; i < n; i++ {
+		if r.pos/8 >= uint(len(r.data)) {
+			r.eof = true
+			return 0
+		}
+		if r.data[r.pos/8]&(1<<(r.pos%8)) != 0 {
+			value |= 1 << uint(i)
+		}
+		r.pos++
+	}
+	return int(value)
+}
+
+type bitWriter struct {
+	data []byte
+	pos  uint
+}
+
+// write writes n bits of value LSB first
+func (w *bitWriter) write(value int, n int) {
+	for i := 0; i < n; i++ {
+		if w.pos%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value&(1<<uint(i)) != 0 {
+			w.data[w.pos/8] |= 1 << (w.pos % 8)
+		}
+		w.pos++
+	}
+}
+
+// ilog returns number of bits needed to store x
+func ilog(x int) int {
+	n := 0
+	for ; x > 0; x >>= 1 {
+		n++
+	}
+	return n
+}
+
+// lookup1Values returns the greatest value whose dimensions power is not greater than entries
+func lookup1Values(entries, dimensions int) int {
+	if dimensions == 0 {
+		return 0
+	}
+
+	value := int(math.Floor(math.Pow(float64(entries), 1/float64(dimensions))))
+	for pow(value+1, dimensions) <= entries {
+		value++
+	}
+	for value > 0 && pow(value, dimensions) > entries {
+		value--
+	}
+	return value
+}
+
+func pow(x, n int) int {
+	result := 1
+	for i := 0; i < n && result <= math.MaxInt32; i++ {
+		result *= x
+	}
+	return result
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/mixdown"
+	"io"
+	"sort"
+)
+
+// mixdownSession renders session recorded with "record" option of server to WAV files,
+// they are written to session directory if output directory is not set
+func mixdownSession(args []string, out io.Writer) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("usage: ninjam-chatbot mixdown <session dir> [output dir]")
+	}
+
+	outDir := args[0]
+	if len(args) == 2 {
+		outDir = args[1]
+	}
+
+	result, err := mixdown.Render(args[0], outDir)
+	if err != nil {
+		return err
+	}
+
+	users := make([]string, 0, len(result.Stems))
+	for user := range result.Stems {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	fmt.Fprintf(out, "Mix: %s (%s)\n", result.Mix, result.Duration)
+	for _, user := range users {
+		fmt.Fprintf(out, "Stem %s: %s\n", user, result.Stems[user])
+	}
+	if result.Skipped > 0 {
+		fmt.Fprintf(out, "Skipped clips: %d\n", result.Skipped)
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package mixdown
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Clip is one interval of user's channel
+type Clip struct {
+	GUID        string
+	User        string
+	Channel     uint8
+	ChannelName string
+	Volume      int16 // 0.1 dB, the last "channel" line before the clip
+	Pan         int8  // -128 (left) to 127 (right)
+}
+
+// Path returns clip file path in session directory the way recorder stores it
+func (c Clip) Path() string {
+	return c.GUID[:1] + "/" + c.GUID + ".ogg"
+}
+
+// Interval is one interval of session with clips which started in it
+type Interval struct {
+	Index int
+	BPM   uint
+	BPI   uint
+	Clips []Clip
+}
+
+// Length returns interval duration
+func (i Interval) Length() time.Duration {
+	return time.Minute * time.Duration(i.BPI) / time.Duration(i.BPM)
+}
+
+// Session is clipsort.log of recorded session
+type Session struct {
+	Intervals []Interval
+}
+
+type channelKey struct {
+	user  string
+	index uint8
+}
+
+type channelSettings struct {
+	volume int16
+	pan    int8
+}
+
+// ReadLog parses clipsort.log written by recorder, unknown lines are skipped
+func ReadLog(r io.Reader) (*Session, error) {
+	session := &Session{}
+	channels := make(map[channelKey]channelSettings)
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "channel":
+			var key channelKey
+			var name string
+			var settings channelSettings
+			if _, err := fmt.Sscanf(line, "channel %q %d %q %d %d", &key.user, &key.index, &name, &settings.volume, &settings.pan); err != nil {
+				return nil, fmt.Errorf("clipsort.log line %d: %s", lineNumber, err)
+			}
+			channels[key] = settings
+		case "interval":
+			var interval Interval
+			if _, err := fmt.Sscanf(line, "interval %d %d %d", &interval.Index, &interval.BPM, &interval.BPI); err != nil {
+				return nil, fmt.Errorf("clipsort.log line %d: %s", lineNumber, err)
+			}
+			if interval.BPM == 0 || interval.BPI == 0 {
+				return nil, fmt.Errorf("clipsort.log line %d: interval %d has no BPM or BPI", lineNumber, interval.Index)
+			}
+			if n := len(session.Intervals); n > 0 && session.Intervals[n-1].Index >= interval.Index {
+				return nil, fmt.Errorf("clipsort.log line %d: interval %d goes after interval %d", lineNumber, interval.Index, session.Intervals[n-1].Index)
+			}
+			session.Intervals = append(session.Intervals, interval)
+		case "user":
+			var clip Clip
+			if _, err := fmt.Sscanf(line, "user %s %q %d %q", &clip.GUID, &clip.User, &clip.Channel, &clip.ChannelName); err != nil {
+				return nil, fmt.Errorf("clipsort.log line %d: %s", lineNumber, err)
+			}
+			if len(session.Intervals) == 0 {
+				return nil, fmt.Errorf("clipsort.log line %d: clip before the first interval", lineNumber)
+			}
+			settings := channels[channelKey{user: clip.User, index: clip.Channel}]
+			clip.Volume, clip.Pan = settings.volume, settings.pan
+
+			interval := &session.Intervals[len(session.Intervals)-1]
+			interval.Clips = append(interval.Clips, clip)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Positions returns start of every interval from the start of the first one.
+// Skipped intervals are counted with tempo of the interval before them
+func (s *Session) Positions() []time.Duration {
+	positions := make([]time.Duration, len(s.Intervals))
+
+	for i := 1; i < len(s.Intervals); i++ {
+		prev := s.Intervals[i-1]
+		positions[i] = positions[i-1] + time.Duration(s.Intervals[i].Index-prev.Index)*prev.Length()
+	}
+
+	return positions
+}
@@ -0,0 +1,57 @@
+package mixdown
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLog = `channel "Vasya@127.0.0.x" 0 "guitar" -30 -64
+interval 0 120 16
+user AB010000000000000000000000000000 "Vasya@127.0.0.x" 0 "guitar"
+user CD010000000000000000000000000000 "Petya@127.0.0.x" 1 "bass"
+channel "Vasya@127.0.0.x" 0 "guitar" 10 0
+interval 3 120 16
+user EF010000000000000000000000000000 "Vasya@127.0.0.x" 0 "guitar"
+interval 4 90 16
+user 12010000000000000000000000000000 "Vasya@127.0.0.x" 0 "guitar"
+end
+`
+
+func TestReadLog(t *testing.T) {
+	session, err := ReadLog(strings.NewReader(testLog))
+	require.NoError(t, err)
+
+	assert.Equal(t, []Interval{
+		{Index: 0, BPM: 120, BPI: 16, Clips: []Clip{
+			{GUID: "AB010000000000000000000000000000", User: "Vasya@127.0.0.x", Channel: 0, ChannelName: "guitar", Volume: -30, Pan: -64},
+			{GUID: "CD010000000000000000000000000000", User: "Petya@127.0.0.x", Channel: 1, ChannelName: "bass"},
+		}},
+		{Index: 3, BPM: 120, BPI: 16, Clips: []Clip{
+			{GUID: "EF010000000000000000000000000000", User: "Vasya@127.0.0.x", Channel: 0, ChannelName: "guitar", Volume: 10},
+		}},
+		{Index: 4, BPM: 90, BPI: 16, Clips: []Clip{
+			{GUID: "12010000000000000000000000000000", User: "Vasya@127.0.0.x", Channel: 0, ChannelName: "guitar", Volume: 10},
+		}},
+	}, session.Intervals)
+
+	assert.Equal(t, "A/AB010000000000000000000000000000.ogg", session.Intervals[0].Clips[0].Path())
+	assert.Equal(t, []time.Duration{0, time.Second * 24, time.Second * 32}, session.Positions())
+	assert.Equal(t, time.Second*32/3, session.Intervals[2].Length())
+}
+
+func TestReadLog_Errors(t *testing.T) {
+	for _, log := range []string{
+		"user AB01 \"Vasya\" 0 \"guitar\"\n",
+		"interval 0 0 16\n",
+		"interval 1 120 16\ninterval 1 120 16\n",
+		"interval x 120 16\n",
+		"interval 0 120 16\nuser AB01 Vasya 0 guitar\n",
+		"channel \"Vasya\" 0\n",
+	} {
+		_, err := ReadLog(strings.NewReader(log))
+		assert.Error(t, err, log)
+	}
+}
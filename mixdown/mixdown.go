@@ -0,0 +1,218 @@
+// Package mixdown renders session recorded by recorder package to WAV files: stereo mix of all users
+// and one stem per user. OGG Vorbis intervals are decoded by pure Go decoder, so it works offline
+// on any platform.
+//
+// Intervals are placed on the grid of clipsort.log starting from the first interval with audio,
+// volume and pan of every clip are the last ones server reported for the channel before the clip.
+// All stems start at the start of the mix, so they line up in any audio editor.
+package mixdown
+
+import (
+	"fmt"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/sirupsen/logrus"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// MixName is the name of the stereo mix file in output directory
+	MixName = "mix.wav"
+	// LogName is the name of intervals log in session directory, see recorder package
+	LogName = "clipsort.log"
+
+	// defaultSampleRate is used when the session has no decodable clips
+	defaultSampleRate = 44100
+)
+
+// Result lists written files
+type Result struct {
+	Mix      string
+	Stems    map[string]string // user name -> stem file
+	Duration time.Duration
+	Skipped  int // clips which are missing or can't be decoded
+}
+
+// Render decodes all clips of session in sessionDir and writes mix and stems to outDir
+func Render(sessionDir, outDir string) (*Result, error) {
+	f, err := os.Open(filepath.Join(sessionDir, LogName))
+	if err != nil {
+		return nil, err
+	}
+	session, err := ReadLog(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Mix:   filepath.Join(outDir, MixName),
+		Stems: make(map[string]string),
+	}
+
+	mix, err := createTrack(result.Mix)
+	if err != nil {
+		return nil, err
+	}
+	stems := make(map[string]*wavTrack)
+	tracks := []*wavTrack{mix}
+	closeTracks := func(sampleRate int) error {
+		var err error
+		for _, t := range tracks {
+			if closeErr := t.close(sampleRate); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+
+	// микс и стемы пишутся по интервалам, в памяти только клипы, которые ещё могут перекрыться со следующими
+	sampleRate := 0
+	positions := session.Positions()
+	for i, interval := range session.Intervals {
+		if sampleRate != 0 {
+			for _, t := range tracks {
+				if err := t.flush(offset(positions[i], sampleRate)); err != nil {
+					closeTracks(sampleRate)
+					return nil, err
+				}
+			}
+		}
+
+		for _, c := range interval.Clips {
+			path := filepath.Join(sessionDir, filepath.FromSlash(c.Path()))
+			samples, format, err := decode(path)
+			if err != nil {
+				logrus.Warnf("Mixdown skips clip %s of %s: %s", path, c.User, err)
+				result.Skipped++
+				continue
+			}
+
+			if sampleRate == 0 {
+				sampleRate = format.SampleRate
+			}
+
+			stem, ok := stems[c.User]
+			if !ok {
+				stemPath := filepath.Join(outDir, stemName(c.User))
+				if stem, err = createTrack(stemPath); err != nil {
+					closeTracks(sampleRate)
+					return nil, err
+				}
+				stems[c.User] = stem
+				tracks = append(tracks, stem)
+				result.Stems[c.User] = stemPath
+			}
+
+			stereo := ToStereo(samples, format.Channels, format.SampleRate, sampleRate)
+			left, right := Gains(c.Volume, c.Pan)
+			stem.add(offset(positions[i], sampleRate), stereo, left, right)
+			mix.add(offset(positions[i], sampleRate), stereo, left, right)
+		}
+	}
+
+	if sampleRate == 0 {
+		sampleRate = defaultSampleRate
+	}
+
+	if err := closeTracks(sampleRate); err != nil {
+		return nil, err
+	}
+	result.Duration = time.Duration(mix.written/wavChannels) * time.Second / time.Duration(sampleRate)
+
+	return result, nil
+}
+
+func decode(path string) ([]float32, *oggvorbis.Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	samples, format, err := oggvorbis.ReadAll(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format.Channels != 1 && format.Channels != 2 {
+		return nil, nil, fmt.Errorf("%d channels are not supported", format.Channels)
+	}
+
+	return samples, format, nil
+}
+
+// ToStereo converts mono or stereo samples to stereo with sample rate of the mix
+func ToStereo(samples []float32, channels, sampleRate, mixSampleRate int) []float32 {
+	frames := len(samples) / channels
+	outFrames := frames
+	if sampleRate != mixSampleRate {
+		outFrames = int(int64(frames) * int64(mixSampleRate) / int64(sampleRate))
+	}
+
+	stereo := make([]float32, outFrames*wavChannels)
+	for i := 0; i < outFrames; i++ {
+		// линейная интерполяция между соседними сэмплами исходной частоты
+		pos := float64(i) * float64(sampleRate) / float64(mixSampleRate)
+		j := int(pos)
+		frac := float32(pos - float64(j))
+
+		for ch := 0; ch < wavChannels; ch++ {
+			src := ch
+			if channels == 1 {
+				src = 0
+			}
+
+			sample := samples[j*channels+src]
+			if j+1 < frames {
+				sample += (samples[(j+1)*channels+src] - sample) * frac
+			}
+			stereo[i*wavChannels+ch] = sample
+		}
+	}
+
+	return stereo
+}
+
+// offset returns index of the first interleaved sample at position
+func offset(position time.Duration, sampleRate int) int {
+	return int(position*time.Duration(sampleRate)/time.Second) * wavChannels
+}
+
+// Gains returns gain of left and right channels, volume is in 0.1 dB, pan is from -128 (left) to 127 (right)
+func Gains(volume int16, pan int8) (left, right float32) {
+	gain := float32(math.Pow(10, float64(volume)/200))
+
+	p := float32(pan) / 127
+	if pan < 0 {
+		p = float32(pan) / 128
+	}
+
+	left, right = gain, gain
+	if p > 0 {
+		left *= 1 - p
+	} else {
+		right *= 1 + p
+	}
+
+	return left, right
+}
+
+// stemName returns WAV file name for user, characters unsafe for file names are replaced
+func stemName(user string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, user)
+
+	return "stem_" + name + ".wav"
+}
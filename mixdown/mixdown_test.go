@@ -0,0 +1,172 @@
+package mixdown
+
+import (
+	"encoding/binary"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readWAV checks header written by wavTrack and returns sample rate and samples
+func readWAV(t *testing.T, path string) (int, []int16) {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, len(data) >= 44)
+
+	assert.Equal(t, "RIFF", string(data[0:4]))
+	assert.Equal(t, uint32(len(data)-8), binary.LittleEndian.Uint32(data[4:]))
+	assert.Equal(t, "WAVEfmt ", string(data[8:16]))
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(data[20:]))
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(data[22:]))
+	assert.Equal(t, uint16(16), binary.LittleEndian.Uint16(data[34:]))
+	assert.Equal(t, "data", string(data[36:40]))
+	assert.Equal(t, uint32(len(data)-44), binary.LittleEndian.Uint32(data[40:]))
+
+	samples := make([]int16, (len(data)-44)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[44+i*2:]))
+	}
+
+	return int(binary.LittleEndian.Uint32(data[24:])), samples
+}
+
+func copyClip(t *testing.T, sessionDir, guid string) {
+	require.NoError(t, os.MkdirAll(filepath.Join(sessionDir, guid[:1]), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sessionDir, guid[:1], guid+".ogg"), ninjamtest.Sound(), 0644))
+}
+
+func TestRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixdown")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// ninjamtest.Sound - 1 секунда моно 44100 Гц, интервал 120 BPM, 2 BPI - тоже 1 секунда
+	session := filepath.Join(dir, "session")
+	require.NoError(t, os.MkdirAll(session, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(session, LogName), []byte(`channel "Vasya@127.0.0.x" 0 "guitar" 0 -128
+channel "Petya@127.0.0.x" 0 "bass" -60 127
+interval 0 120 2
+user AB010000000000000000000000000000 "Vasya@127.0.0.x" 0 "guitar"
+interval 1 120 2
+user CD010000000000000000000000000000 "Petya@127.0.0.x" 0 "bass"
+user EF010000000000000000000000000000 "Petya@127.0.0.x" 1 "missing"
+end
+`), 0644))
+	copyClip(t, session, "AB010000000000000000000000000000")
+	copyClip(t, session, "CD010000000000000000000000000000")
+
+	out := filepath.Join(dir, "out")
+	result, err := Render(session, out)
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(out, MixName), result.Mix)
+	assert.Equal(t, map[string]string{
+		"Vasya@127.0.0.x": filepath.Join(out, "stem_Vasya@127.0.0.x.wav"),
+		"Petya@127.0.0.x": filepath.Join(out, "stem_Petya@127.0.0.x.wav"),
+	}, result.Stems)
+	assert.Equal(t, time.Second*2, result.Duration)
+	assert.Equal(t, 1, result.Skipped)
+
+	rate, mix := readWAV(t, result.Mix)
+	assert.Equal(t, 44100, rate)
+	require.Len(t, mix, 44100*2*2)
+
+	_, vasya := readWAV(t, result.Stems["Vasya@127.0.0.x"])
+	_, petya := readWAV(t, result.Stems["Petya@127.0.0.x"])
+	require.Len(t, vasya, 44100*2)
+	require.Len(t, petya, 44100*2*2)
+
+	var vasyaLeft, petyaRight int
+	for i := 0; i < 44100*2; i += 2 {
+		// Vasya в первом интервале панорамирован влево, Petya во втором - вправо
+		assert.Equal(t, int16(0), vasya[i+1])
+		assert.Equal(t, int16(0), petya[i], "Petya starts in the second interval")
+		assert.Equal(t, int16(0), petya[44100*2+i])
+		assert.Equal(t, vasya[i], mix[i])
+		assert.Equal(t, petya[44100*2+i+1], mix[44100*2+i+1])
+
+		vasyaLeft += abs(int(vasya[i]))
+		petyaRight += abs(int(petya[44100*2+i+1]))
+	}
+
+	// тот же клип у Petya на 6 дБ тише
+	assert.InDelta(t, 0.5, float64(petyaRight)/float64(vasyaLeft), 0.01)
+}
+
+func TestRender_Empty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixdown")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, LogName), []byte("end\n"), 0644))
+
+	result, err := Render(dir, dir)
+	require.NoError(t, err)
+	assert.Empty(t, result.Stems)
+	assert.Equal(t, time.Duration(0), result.Duration)
+
+	rate, mix := readWAV(t, result.Mix)
+	assert.Equal(t, defaultSampleRate, rate)
+	assert.Empty(t, mix)
+
+	_, err = Render(filepath.Join(dir, "missing"), dir)
+	assert.Error(t, err)
+}
+
+func TestWavTrack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixdown")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "track.wav")
+	track, err := createTrack(path)
+	require.NoError(t, err)
+
+	half := float32(0.5)
+	track.add(0, []float32{half, half, half, half}, 1, 0)
+	require.NoError(t, track.flush(2))
+	// второй клип перекрывает ещё не записанную часть первого, перед третьим - пауза
+	track.add(2, []float32{half, half}, 1, 1)
+	track.add(8, []float32{half, half}, 1, 1)
+	assert.Len(t, track.pending, 8)
+	require.NoError(t, track.flush(12))
+	require.NoError(t, track.close(8000))
+
+	rate, samples := readWAV(t, path)
+	assert.Equal(t, 8000, rate)
+	// записанная тишина после последнего клипа обрезана
+	assert.Equal(t, []int16{16384, 0, 32767, 16384, 0, 0, 0, 0, 16384, 16384}, samples)
+}
+
+func TestToStereo(t *testing.T) {
+	assert.Equal(t, []float32{1, 1, 0.5, 0.5}, ToStereo([]float32{1, 0.5}, 1, 44100, 44100))
+	assert.Equal(t, []float32{1, -1, 0.5, -0.5}, ToStereo([]float32{1, -1, 0.5, -0.5}, 2, 48000, 48000))
+	// передискретизация вдвое - промежуточные сэмплы интерполируются
+	assert.Equal(t, []float32{0, 0, 0.5, 0.5, 1, 1, 1, 1}, ToStereo([]float32{0, 1}, 1, 22050, 44100))
+}
+
+func TestGains(t *testing.T) {
+	left, right := Gains(0, 0)
+	assert.Equal(t, float32(1), left)
+	assert.Equal(t, float32(1), right)
+
+	left, right = Gains(-60, -128)
+	assert.InDelta(t, 0.501, left, 0.001)
+	assert.Equal(t, float32(0), right)
+
+	left, right = Gains(0, 127)
+	assert.Equal(t, float32(0), left)
+	assert.Equal(t, float32(1), right)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
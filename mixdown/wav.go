@@ -0,0 +1,155 @@
+package mixdown
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	wavChannels      = 2
+	wavBitsPerSample = 16
+	wavHeaderSize    = 44
+)
+
+// wavTrack is interleaved stereo 16-bit PCM WAV file which is written while session is rendered.
+// Clips are mixed into pending samples, samples before the position of the next clips are final
+// and go to the file, so only the samples clips still can overlap are kept in memory
+type wavTrack struct {
+	f       *os.File
+	w       *bufio.Writer
+	written int       // samples written to the file
+	pending []float32 // samples from written on
+	end     int       // end of the last clip, silence after it is cut off
+}
+
+// createTrack creates WAV file, its header is written by close when sample rate and size are known
+func createTrack(path string) (*wavTrack, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &wavTrack{f: f, w: bufio.NewWriter(f)}
+	if _, err := t.w.Write(make([]byte, wavHeaderSize)); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// add mixes samples into the track from sample start with gains of left and right channels,
+// the part before written samples is dropped
+func (t *wavTrack) add(start int, samples []float32, left, right float32) {
+	if start < t.written {
+		if t.written-start >= len(samples) {
+			return
+		}
+		samples = samples[t.written-start:]
+		start = t.written
+	}
+
+	offset := start - t.written
+	if end := offset + len(samples); end > len(t.pending) {
+		t.pending = append(t.pending, make([]float32, end-len(t.pending))...)
+	}
+
+	for i := 0; i < len(samples); i += wavChannels {
+		t.pending[offset+i] += samples[i] * left
+		t.pending[offset+i+1] += samples[i+1] * right
+	}
+
+	if end := start + len(samples); end > t.end {
+		t.end = end
+	}
+}
+
+// flush writes samples before until to the file, samples out of [-1, 1] are clipped.
+// Silence is written where there are no clips
+func (t *wavTrack) flush(until int) error {
+	n := until - t.written
+	if n <= 0 {
+		return nil
+	}
+
+	buf := make([]byte, 2)
+	for i := 0; i < n; i++ {
+		var sample float32
+		if i < len(t.pending) {
+			sample = t.pending[i]
+		}
+
+		s := math.Max(-1, math.Min(1, float64(sample)))
+		binary.LittleEndian.PutUint16(buf, uint16(int16(math.Round(s*math.MaxInt16))))
+		if _, err := t.w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	if n < len(t.pending) {
+		t.pending = append(t.pending[:0], t.pending[n:]...)
+	} else {
+		t.pending = t.pending[:0]
+	}
+	t.written = until
+
+	return nil
+}
+
+// close writes the rest of samples up to the end of the last clip and the header
+func (t *wavTrack) close(sampleRate int) error {
+	err := t.flush(t.end)
+	if err == nil {
+		err = t.w.Flush()
+	}
+	if err == nil && t.written > t.end {
+		// тишина после последнего клипа уже записана - обрезаем её
+		t.written = t.end
+		err = t.f.Truncate(int64(wavHeaderSize + t.written*wavBitsPerSample/8))
+	}
+	if err == nil {
+		_, err = t.f.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		_, err = t.f.Write(wavHeader(sampleRate, t.written))
+	}
+
+	if closeErr := t.f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// wavHeader returns header of WAV file with samples of interleaved stereo 16-bit PCM
+func wavHeader(sampleRate, samples int) []byte {
+	blockAlign := wavChannels * wavBitsPerSample / 8
+	dataSize := uint32(samples * wavBitsPerSample / 8)
+
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		wavHeaderSize - 8 + dataSize,
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16), // fmt chunk size
+		uint16(1),  // PCM
+		uint16(wavChannels),
+		uint32(sampleRate),
+		uint32(sampleRate * blockAlign), // bytes per second
+		uint16(blockAlign),
+		uint16(wavBitsPerSample),
+		[4]byte{'d', 'a', 't', 'a'},
+		dataSize,
+	}
+
+	buf := &bytes.Buffer{}
+	for _, field := range header {
+		binary.Write(buf, binary.LittleEndian, field)
+	}
+
+	return buf.Bytes()
+}
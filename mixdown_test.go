@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"github.com/ayvan/ninjam-chatbot/mixdown"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMixdownSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mixdown")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "A"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "A", "AB010000000000000000000000000000.ogg"), ninjamtest.Sound(), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, mixdown.LogName), []byte("interval 0 120 2\n"+
+		"user AB010000000000000000000000000000 \"Vasya@127.0.0.x\" 0 \"guitar\"\n"+
+		"user CD010000000000000000000000000000 \"Petya@127.0.0.x\" 0 \"bass\"\n"+
+		"end\n"), 0644))
+
+	out := &bytes.Buffer{}
+	require.NoError(t, mixdownSession([]string{dir}, out))
+	assert.Equal(t, "Mix: "+filepath.Join(dir, "mix.wav")+" (1s)\n"+
+		"Stem Vasya@127.0.0.x: "+filepath.Join(dir, "stem_Vasya@127.0.0.x.wav")+"\n"+
+		"Skipped clips: 1\n", out.String())
+
+	assert.Error(t, mixdownSession(nil, out))
+	assert.Error(t, mixdownSession([]string{filepath.Join(dir, "missing")}, out))
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"strconv"
+)
+
+// Topic is a TOPIC chat command: server topic was set or sent on join
+type Topic struct {
+	By   string // who has changed the topic, empty when server just informs about current topic
+	Text string
+}
+
+// PrivateMessage is a PRIVMSG chat command from another user
+type PrivateMessage struct {
+	From string
+	Text string
+}
+
+// UserCount is a USERCOUNT chat command
+type UserCount struct {
+	Users    int
+	MaxUsers int
+}
+
+// Session is a SESSION chat command with server's session info
+type Session struct {
+	Info string
+}
+
+// NewTopic parses TOPIC chat command: Arg1 - who has set topic, Arg2 - topic text
+func NewTopic(cm *ChatMessage) Topic {
+	return Topic{
+		By:   string(cm.Arg1),
+		Text: string(cm.Arg2),
+	}
+}
+
+// NewPrivateMessage parses PRIVMSG chat command: Arg1 - sender, Arg2 - text
+func NewPrivateMessage(cm *ChatMessage) PrivateMessage {
+	return PrivateMessage{
+		From: string(cm.Arg1),
+		Text: string(cm.Arg2),
+	}
+}
+
+// NewUserCount parses USERCOUNT chat command: Arg1 - users count, Arg2 - max users
+func NewUserCount(cm *ChatMessage) (uc UserCount, err error) {
+	uc.Users, err = strconv.Atoi(string(cm.Arg1))
+	if err != nil {
+		return
+	}
+
+	uc.MaxUsers, err = strconv.Atoi(string(cm.Arg2))
+
+	return
+}
+
+// NewSession parses SESSION chat command: Arg1 - session info
+func NewSession(cm *ChatMessage) Session {
+	return Session{
+		Info: string(cm.Arg1),
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChatCommands(t *testing.T) {
+	cm := &ChatMessage{}
+	assert.NoError(t, cm.Unmarshal([]byte("TOPIC\x00Vasya@127.0.0.x\x00Blues in A, 120 bpm\x00\x00\x00")))
+	assert.Equal(t, TOPIC, string(cm.Command))
+	assert.Equal(t, Topic{By: "Vasya@127.0.0.x", Text: "Blues in A, 120 bpm"}, NewTopic(cm))
+
+	cm = &ChatMessage{}
+	assert.NoError(t, cm.Unmarshal([]byte("PRIVMSG\x00Petya@127.0.0.x\x00hi there\x00\x00\x00")))
+	assert.Equal(t, PrivateMessage{From: "Petya@127.0.0.x", Text: "hi there"}, NewPrivateMessage(cm))
+
+	cm = &ChatMessage{}
+	assert.NoError(t, cm.Unmarshal([]byte("USERCOUNT\x003\x0016\x00\x00\x00")))
+	userCount, err := NewUserCount(cm)
+	assert.NoError(t, err)
+	assert.Equal(t, UserCount{Users: 3, MaxUsers: 16}, userCount)
+
+	_, err = NewUserCount(&ChatMessage{Command: []byte(USERCOUNT), Arg1: []byte("many")})
+	assert.Error(t, err)
+}
@@ -1,7 +1,6 @@
 package models
 
 import (
-	"bytes"
 	"fmt"
 )
 
@@ -41,55 +40,20 @@ func (cm *ChatMessage) Marshal() (data []byte, err error) {
 	return
 }
 
-func (cm *ChatMessage) Unmarshal(data []byte) (err error) {
+// Unmarshal reads up to 5 NUL-terminated strings, arguments missing at the end of payload stay nil
+func (cm *ChatMessage) Unmarshal(data []byte) error {
+	d := newDecoder(data)
 
-	if len(data) == 0 {
-		return
-	}
-
-	nulTerminator := bytes.Index(data, []byte{0x0})
-
-	cm.Command = data[:nulTerminator]
-
-	data = data[nulTerminator+1:]
-
-	if len(data) == 0 {
-		return
-	}
-
-	nulTerminator = bytes.Index(data, []byte{0x0})
-
-	cm.Arg1 = data[:nulTerminator]
-
-	data = data[nulTerminator+1:]
-
-	if len(data) == 0 {
-		return
-	}
+	fields := []*[]byte{&cm.Command, &cm.Arg1, &cm.Arg2, &cm.Arg3, &cm.Arg4}
+	names := []string{"command", "argument 1", "argument 2", "argument 3", "argument 4"}
 
-	nulTerminator = bytes.Index(data, []byte{0x0})
-
-	cm.Arg2 = data[:nulTerminator]
-
-	data = data[nulTerminator+1:]
-
-	if len(data) == 0 {
-		return
-	}
-
-	nulTerminator = bytes.Index(data, []byte{0x0})
-
-	cm.Arg3 = data[:nulTerminator]
-
-	data = data[nulTerminator+1:]
+	for i, field := range fields {
+		if d.remaining() == 0 {
+			break
+		}
 
-	if len(data) == 0 {
-		return
+		*field = d.string(names[i])
 	}
 
-	nulTerminator = bytes.Index(data, []byte{0x0})
-
-	cm.Arg4 = data[:nulTerminator]
-
-	return nil
+	return d.err
 }
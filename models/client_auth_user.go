@@ -2,7 +2,6 @@ package models
 
 import (
 	"fmt"
-	"github.com/sirupsen/logrus"
 	"crypto/sha1"
 	"encoding/binary"
 )
@@ -48,8 +47,6 @@ func (cau *ClientAuthUser) Marshal() (data []byte, err error) {
 		data = append(data, byte(b))
 	}
 
-	logrus.Info("Username:", string(cau.Username))
-
 	data = append(data, cau.Username...)
 	data = append(data, byte(0x0))
 
@@ -64,3 +61,26 @@ func (cau *ClientAuthUser) Marshal() (data []byte, err error) {
 
 	return
 }
+
+func (cau *ClientAuthUser) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	copy(cau.PasswordHash[:], d.bytes("password hash", 20))
+	cau.Username = d.string("user name")
+	cau.ClientCapabilities = d.uint32("client capabilities")
+	cau.ClientVersion = d.uint32("client version")
+
+	return d.err
+}
+
+// CheckPassword reports whether PasswordHash was made from the password and challenge sent to the client
+func (cau *ClientAuthUser) CheckPassword(password string, challenge [8]uint8) bool {
+	expected := NewClientAuthUser(string(cau.Username), password, false, challenge)
+
+	return expected.PasswordHash == cau.PasswordHash
+}
+
+// HasAgreement reports whether the user accepted server's license agreement
+func (cau *ClientAuthUser) HasAgreement() bool {
+	return hasBit(cau.ClientCapabilities, 0)
+}
@@ -55,3 +55,45 @@ func (c *ClientSetChannelInfo) Marshal() (data []byte, err error) {
 
 	return
 }
+
+// Unmarshal decodes channels the way NINJAM server does: parameters size is read once from the first two bytes,
+// then every channel is a NUL-terminated name followed by parameters of that size (the last one may be shorter)
+func (c *ClientSetChannelInfo) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	c.Channels = make([]ChannelInfo, 0)
+
+	if d.remaining() < 2 {
+		return nil
+	}
+
+	paramsSize := int(d.uint16("parameters size"))
+
+	for d.remaining() > 0 {
+		channel := ChannelInfo{Name: string(d.string("channel name"))}
+
+		if d.err != nil {
+			return d.err
+		}
+
+		size := paramsSize
+		if d.remaining() < size {
+			size = d.remaining()
+		}
+		params := d.bytes("channel parameters", size)
+
+		if len(params) >= 2 {
+			channel.Volume = int16(binary.LittleEndian.Uint16(params[0:2]))
+		}
+		if len(params) >= 3 {
+			channel.Pan = int8(params[2])
+		}
+		if len(params) >= 4 {
+			channel.Flags = params[3]
+		}
+
+		c.Channels = append(c.Channels, channel)
+	}
+
+	return nil
+}
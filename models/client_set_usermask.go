@@ -0,0 +1,62 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// AllChannels is a channel mask subscribing to every channel of the user
+const AllChannels uint32 = 0xFFFFFFFF
+
+// ClientSetUsermask
+// 0x81
+type ClientSetUsermask struct {
+	UserMasks []UserMask
+}
+
+type UserMask struct {
+	Name        string // NUL-terminated
+	ChannelMask uint32 // bit N - subscribe to channel with index N, 0 - unsubscribe from user
+}
+
+func (c *ClientSetUsermask) Marshal() (data []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Marshal error: %s", r)
+			return
+		}
+	}()
+
+	for _, userMask := range c.UserMasks {
+		data = append(data, []byte(userMask.Name)...)
+		data = append(data, byte(0))
+
+		mask := make([]byte, 4)
+		binary.LittleEndian.PutUint32(mask, userMask.ChannelMask)
+
+		data = append(data, mask...)
+	}
+
+	return
+}
+
+func (c *ClientSetUsermask) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	c.UserMasks = make([]UserMask, 0)
+
+	for d.remaining() > 0 {
+		userMask := UserMask{
+			Name:        string(d.string("user name")),
+			ChannelMask: d.uint32("channel mask"),
+		}
+
+		if d.err != nil {
+			return d.err
+		}
+
+		c.UserMasks = append(c.UserMasks, userMask)
+	}
+
+	return nil
+}
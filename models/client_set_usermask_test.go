@@ -0,0 +1,27 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClientSetUsermask_Marshal(t *testing.T) {
+	nm := NewNetMessage(ClientSetUsermaskType)
+	nm.OutPayload = &ClientSetUsermask{
+		UserMasks: []UserMask{
+			{Name: "Vasya@127.0.0.x", ChannelMask: 0x3},
+			{Name: "Petya@127.0.0.x", ChannelMask: AllChannels},
+		},
+	}
+
+	data, err := nm.Marshal()
+	assert.NoError(t, err)
+
+	expected := []byte{ClientSetUsermaskType, 40, 0, 0, 0}
+	expected = append(expected, []byte("Vasya@127.0.0.x\x00")...)
+	expected = append(expected, 0x3, 0, 0, 0)
+	expected = append(expected, []byte("Petya@127.0.0.x\x00")...)
+	expected = append(expected, 0xFF, 0xFF, 0xFF, 0xFF)
+
+	assert.Equal(t, expected, data)
+}
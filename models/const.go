@@ -1,25 +1,74 @@
 package models
 
+import "fmt"
+
 // Message types:
 // https://github.com/wahjam/wahjam/wiki/Ninjam-Protocol
 
 const (
-	ServerAuthChallengeType        uint8 = 0x00
-	ServerAuthReplyType            uint8 = 0x01
-	ServerConfigChangeNotifyType   uint8 = 0x02
-	ServerUserInfoChangeNotifyType uint8 = 0x03
-	ClientAuthUserType             uint8 = 0x80
-	ClientSetUsermaskType          uint8 = 0x81
-	ClientSetChannelInfoType       uint8 = 0x82
-	ClientUploadIntervalBeginType  uint8 = 0x83
-	ClientUploadIntervalWriteType  uint8 = 0x84
-	ChatMessageType                uint8 = 0xC0
-	ClientKeepaliveType            uint8 = 0xfd
+	ServerAuthChallengeType         uint8 = 0x00
+	ServerAuthReplyType             uint8 = 0x01
+	ServerConfigChangeNotifyType    uint8 = 0x02
+	ServerUserInfoChangeNotifyType  uint8 = 0x03
+	ServerDownloadIntervalBeginType uint8 = 0x04
+	ServerDownloadIntervalWriteType uint8 = 0x05
+	ClientAuthUserType              uint8 = 0x80
+	ClientSetUsermaskType           uint8 = 0x81
+	ClientSetChannelInfoType        uint8 = 0x82
+	ClientUploadIntervalBeginType   uint8 = 0x83
+	ClientUploadIntervalWriteType   uint8 = 0x84
+	ChatMessageType                 uint8 = 0xC0
+	ClientKeepaliveType             uint8 = 0xfd
 )
 
+// Chat commands
+const (
+	MSG       = "MSG"
+	JOIN      = "JOIN"
+	PART      = "PART"
+	ADMIN     = "ADMIN"
+	TOPIC     = "TOPIC"
+	PRIVMSG   = "PRIVMSG"
+	USERCOUNT = "USERCOUNT"
+	SESSION   = "SESSION"
+)
+
+// RECORD is the bot command from Telegram or Slack, Text is "start" or "stop", it's never sent to NINJAM server
+const RECORD = "RECORD"
+
+// LICENSE is the notice of NINJAM bot that server requires accepting license agreement, it's never sent to NINJAM server
+const LICENSE = "LICENSE"
+
+// Channel flags
 const (
-	MSG   = "MSG"
-	JOIN  = "JOIN"
-	PART  = "PART"
-	ADMIN = "ADMIN"
+	ChannelFlagVoiceChat   uint8 = 0x02
+	ChannelFlagSessionMode uint8 = 0x04
 )
+
+// IntervalLastFlag in flags of ServerDownloadIntervalWrite and ClientUploadIntervalWrite marks the last chunk of interval
+const IntervalLastFlag uint8 = 0x01
+
+var messageTypeNames = map[uint8]string{
+	ServerAuthChallengeType:         "ServerAuthChallenge",
+	ServerAuthReplyType:             "ServerAuthReply",
+	ServerConfigChangeNotifyType:    "ServerConfigChangeNotify",
+	ServerUserInfoChangeNotifyType:  "ServerUserInfoChangeNotify",
+	ServerDownloadIntervalBeginType: "ServerDownloadIntervalBegin",
+	ServerDownloadIntervalWriteType: "ServerDownloadIntervalWrite",
+	ClientAuthUserType:              "ClientAuthUser",
+	ClientSetUsermaskType:           "ClientSetUsermask",
+	ClientSetChannelInfoType:        "ClientSetChannelInfo",
+	ClientUploadIntervalBeginType:   "ClientUploadIntervalBegin",
+	ClientUploadIntervalWriteType:   "ClientUploadIntervalWrite",
+	ChatMessageType:                 "ChatMessage",
+	ClientKeepaliveType:             "Keepalive",
+}
+
+// MessageTypeName returns name of message type for logs, e.g. "ChatMessage" or "0x42" for unknown types
+func MessageTypeName(t uint8) string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%02x", t)
+}
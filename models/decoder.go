@@ -0,0 +1,132 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTruncated is returned when payload ends before all fields are read
+	ErrTruncated = errors.New("truncated payload")
+	// ErrMissingTerminator is returned when string field has no NUL terminator
+	ErrMissingTerminator = errors.New("missing NUL terminator")
+)
+
+// DecodeError tells which field of payload can't be decoded,
+// errors.Is(err, ErrTruncated) and errors.Is(err, ErrMissingTerminator) work through it
+type DecodeError struct {
+	Field  string
+	Offset int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%s at offset %d: %s", e.Field, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decoder reads payload fields with bounds checks, after the first error
+// all reads return zero values and err keeps that first error
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func newDecoder(data []byte) *decoder {
+	return &decoder{data: data}
+}
+
+func (d *decoder) fail(field string, err error) {
+	if d.err == nil {
+		d.err = &DecodeError{Field: field, Offset: d.offset, Err: err}
+	}
+}
+
+// remaining returns count of unread bytes
+func (d *decoder) remaining() int {
+	if d.err != nil {
+		return 0
+	}
+
+	return len(d.data) - d.offset
+}
+
+// bytes returns next n bytes, the slice refers to payload like the rest of models do
+func (d *decoder) bytes(field string, n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if d.remaining() < n {
+		d.fail(field, ErrTruncated)
+		return nil
+	}
+
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+
+	return b
+}
+
+func (d *decoder) uint8(field string) uint8 {
+	b := d.bytes(field, 1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (d *decoder) uint16(field string) uint16 {
+	b := d.bytes(field, 2)
+	if b == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (d *decoder) uint32(field string) uint32 {
+	b := d.bytes(field, 4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b)
+}
+
+// string returns NUL-terminated string without terminator
+func (d *decoder) string(field string) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	nulTerminator := bytes.IndexByte(d.data[d.offset:], 0)
+	if nulTerminator == -1 {
+		d.fail(field, ErrMissingTerminator)
+		return nil
+	}
+
+	s := d.data[d.offset : d.offset+nulTerminator]
+	d.offset += nulTerminator + 1
+
+	return s
+}
+
+// rest returns all unread bytes
+func (d *decoder) rest() []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	b := d.data[d.offset:]
+	d.offset = len(d.data)
+
+	return b
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUnmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Unmarshaler
+		data []byte
+		err  error
+		msg  string
+	}{
+		{
+			name: "chat argument without terminator",
+			in:   &ChatMessage{},
+			data: []byte("MSG\x00Vasya\x00hello"),
+			err:  ErrMissingTerminator,
+			msg:  "argument 2 at offset 10: missing NUL terminator",
+		},
+		{
+			name: "short config",
+			in:   &ServerConfigChangeNotify{},
+			data: []byte{120, 0, 16},
+			err:  ErrTruncated,
+			msg:  "BPI at offset 2: truncated payload",
+		},
+		{
+			name: "auth reply without terminator",
+			in:   &ServerAuthReply{},
+			data: []byte{0, 'b', 'a', 'd'},
+			err:  ErrMissingTerminator,
+		},
+		{
+			name: "license without terminator",
+			in:   &ServerAuthChallenge{},
+			data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x01, 0x03, 0, 0, 0, 0, 2, 0, 'b', 'e'},
+			err:  ErrMissingTerminator,
+		},
+		{
+			name: "user info without channel name",
+			in:   &ServerUserInfoChangeNotify{},
+			data: []byte{1, 0, 0, 0, 0, 0, 'V', 0, 'g'},
+			err:  ErrMissingTerminator,
+		},
+		{
+			name: "usermask without mask",
+			in:   &ClientSetUsermask{},
+			data: []byte{'V', 0, 0xFF},
+			err:  ErrTruncated,
+		},
+		{
+			name: "auth user without version",
+			in:   &ClientAuthUser{},
+			data: append(make([]byte, 20), 'b', 0, 1, 0, 0, 0),
+			err:  ErrTruncated,
+		},
+		{
+			name: "channel name without terminator",
+			in:   &ClientSetChannelInfo{},
+			data: []byte{4, 0, 'g', 'u', 'i'},
+			err:  ErrMissingTerminator,
+		},
+		{
+			name: "short upload write",
+			in:   &ClientUploadIntervalWrite{},
+			data: make([]byte, 16),
+			err:  ErrTruncated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.in.Unmarshal(tt.data)
+			assert.True(t, errors.Is(err, tt.err), "%v", err)
+
+			var decodeErr *DecodeError
+			assert.True(t, errors.As(err, &decodeErr))
+
+			if tt.msg != "" {
+				assert.EqualError(t, err, tt.msg)
+			}
+		})
+	}
+}
+
+func TestChatMessage_UnmarshalShort(t *testing.T) {
+	// arguments missing at the end stay empty
+	cm := &ChatMessage{}
+	assert.NoError(t, cm.Unmarshal([]byte("USERCOUNT\x003\x00")))
+	assert.Equal(t, &ChatMessage{Command: []byte(USERCOUNT), Arg1: []byte("3")}, cm)
+}
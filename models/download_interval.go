@@ -0,0 +1,93 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// ServerDownloadIntervalBegin
+// 0x04
+type ServerDownloadIntervalBegin struct {
+	GUID          [16]byte
+	EstimatedSize uint32
+	FourCC        [4]byte
+	ChannelIndex  uint8
+	Username      []byte // NUL-terminated
+}
+
+// ServerDownloadIntervalWrite
+// 0x05
+type ServerDownloadIntervalWrite struct {
+	GUID      [16]byte
+	Flags     uint8
+	AudioData []byte
+}
+
+func (s *ServerDownloadIntervalBegin) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	copy(s.GUID[:], d.bytes("GUID", 16))
+	s.EstimatedSize = d.uint32("estimated size")
+	copy(s.FourCC[:], d.bytes("FourCC", 4))
+	s.ChannelIndex = d.uint8("channel index")
+
+	if d.err != nil {
+		return d.err
+	}
+
+	// user name without terminator is taken as is
+	s.Username = d.rest()
+	if nulTerminator := bytes.IndexByte(s.Username, 0); nulTerminator != -1 {
+		s.Username = s.Username[:nulTerminator]
+	}
+
+	return nil
+}
+
+func (s *ServerDownloadIntervalBegin) Marshal() ([]byte, error) {
+	es := make([]byte, 4)
+	binary.LittleEndian.PutUint32(es, s.EstimatedSize)
+
+	var data []byte
+	data = append(data, s.GUID[:]...)
+	data = append(data, es...)
+	data = append(data, s.FourCC[:]...)
+	data = append(data, s.ChannelIndex)
+
+	data, err := appendString(data, "user name", s.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkSize(data)
+}
+
+// IsEmpty reports whether the interval has no audio (the GUID is all zeroes),
+// which the server sends when the user stopped transmitting on the channel
+func (s *ServerDownloadIntervalBegin) IsEmpty() bool {
+	return s.GUID == [16]byte{}
+}
+
+func (s *ServerDownloadIntervalWrite) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	copy(s.GUID[:], d.bytes("GUID", 16))
+	s.Flags = d.uint8("flags")
+	s.AudioData = d.rest()
+
+	return d.err
+}
+
+func (s *ServerDownloadIntervalWrite) Marshal() ([]byte, error) {
+	var data []byte
+	data = append(data, s.GUID[:]...)
+	data = append(data, s.Flags)
+	data = append(data, s.AudioData...)
+
+	return checkSize(data)
+}
+
+// IsLast reports whether this chunk completes the interval
+func (s *ServerDownloadIntervalWrite) IsLast() bool {
+	return s.Flags&IntervalLastFlag != 0
+}
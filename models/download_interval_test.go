@@ -0,0 +1,53 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestServerDownloadIntervalBegin_Unmarshal(t *testing.T) {
+	data := []byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, // GUID
+		0x00, 0x10, 0x00, 0x00, // estimated size
+		'O', 'G', 'G', 'v',
+		2, // channel index
+	}
+	data = append(data, []byte("Vasya@127.0.0.x")...)
+	data = append(data, 0)
+
+	nm := NewInNetMessage([5]byte{ServerDownloadIntervalBeginType})
+	err := nm.Unmarshal(data)
+	assert.NoError(t, err)
+
+	interval, ok := nm.InPayload.(*ServerDownloadIntervalBegin)
+	assert.True(t, ok)
+	assert.Equal(t, [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, interval.GUID)
+	assert.Equal(t, uint32(4096), interval.EstimatedSize)
+	assert.Equal(t, [4]byte{'O', 'G', 'G', 'v'}, interval.FourCC)
+	assert.Equal(t, uint8(2), interval.ChannelIndex)
+	assert.Equal(t, "Vasya@127.0.0.x", string(interval.Username))
+	assert.False(t, interval.IsEmpty())
+}
+
+func TestServerDownloadIntervalBegin_UnmarshalShort(t *testing.T) {
+	interval := &ServerDownloadIntervalBegin{}
+	assert.Error(t, interval.Unmarshal([]byte{1, 2, 3}))
+}
+
+func TestServerDownloadIntervalWrite_Unmarshal(t *testing.T) {
+	data := []byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, // GUID
+		0x1, // flags
+		0xAA, 0xBB, 0xCC,
+	}
+
+	nm := NewInNetMessage([5]byte{ServerDownloadIntervalWriteType})
+	err := nm.Unmarshal(data)
+	assert.NoError(t, err)
+
+	interval, ok := nm.InPayload.(*ServerDownloadIntervalWrite)
+	assert.True(t, ok)
+	assert.Equal(t, uint8(1), interval.Flags)
+	assert.True(t, interval.IsLast())
+	assert.Equal(t, []byte{0xAA, 0xBB, 0xCC}, interval.AudioData)
+}
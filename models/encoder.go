@@ -0,0 +1,34 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNulInString is returned when string field has NUL byte, it would end the string early
+	ErrNulInString = errors.New("NUL byte in string")
+	// ErrPayloadTooLarge is returned when payload is bigger than DefaultMaxFrameSize, readers reject such frames
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
+// appendString appends NUL-terminated string field
+func appendString(data []byte, field string, s []byte) ([]byte, error) {
+	if bytes.IndexByte(s, 0) != -1 {
+		return nil, fmt.Errorf("%s: %w", field, ErrNulInString)
+	}
+
+	data = append(data, s...)
+
+	return append(data, 0), nil
+}
+
+// checkSize returns data if it fits in a frame
+func checkSize(data []byte) ([]byte, error) {
+	if uint64(len(data)) > uint64(DefaultMaxFrameSize) {
+		return nil, fmt.Errorf("%d bytes: %w", len(data), ErrPayloadTooLarge)
+	}
+
+	return data, nil
+}
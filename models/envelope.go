@@ -0,0 +1,28 @@
+package models
+
+// Envelope is a message which router passes between bridges: NINJAM servers and chats.
+// Type of the message tells what it is:
+//
+//	MSG     - chat message
+//	JOIN    - user Name joined NINJAM server, PART - left it
+//	PRIVMSG - private message from Name or to user To
+//	TOPIC   - topic Text was set by Name or has to be set, chats prefix it with server's port
+//	RECORD  - recording command from chat, Text is "start" or "stop"
+//	ADMIN   - service message for admins
+//	LICENSE - NINJAM server requires accepting License
+//
+// Bridges ignore types they can't send
+type Envelope struct {
+	Message
+	Source  string   // name of the bridge the message came from, router sets it
+	Private bool     // message came from private chat with the bot, replies to it are private too
+	UserID  string   // chat's ID of sender Name or of recipient To, names may be not unique or unknown to chat
+	License *License // agreement of LICENSE envelope
+}
+
+// License is license agreement NINJAM server requires to accept
+type License struct {
+	Text     string
+	Hash     string // SHA-256 of Text in hex
+	Accepted bool   // whether the bot accepted it
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxFrameSize limits payload length of one message,
+// ninjamsrv never sends anything close to it, so bigger frames mean broken stream
+const DefaultMaxFrameSize uint32 = 1 << 20
+
+// header: message type (1 byte) + payload length (4 bytes)
+const frameHeaderSize = 5
+
+var (
+	// ErrFrameTooLarge is returned when header declares payload longer than the reader accepts
+	ErrFrameTooLarge = errors.New("frame too large")
+	// ErrTruncatedFrame is returned when stream ends in the middle of a frame
+	ErrTruncatedFrame = errors.New("truncated frame")
+)
+
+// FrameError describes a frame that can't be read, the stream is out of sync after it
+type FrameError struct {
+	Type   uint8
+	Length uint32
+	Err    error
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("frame 0x%02x, length %d: %s", e.Type, e.Length, e.Err)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// PayloadError describes a frame that was read completely but its payload can't be decoded,
+// the stream stays in sync and reading can be continued
+type PayloadError struct {
+	Type uint8
+	Err  error
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("payload of frame 0x%02x: %s", e.Type, e.Err)
+}
+
+func (e *PayloadError) Unwrap() error {
+	return e.Err
+}
+
+// FrameReader reads NINJAM messages from a stream,
+// it is not safe for concurrent use, one connection should have one reader loop
+type FrameReader struct {
+	reader  io.Reader
+	maxSize uint32
+	header  [frameHeaderSize]byte
+}
+
+// NewFrameReader creates reader accepting payloads up to maxSize bytes,
+// maxSize 0 means DefaultMaxFrameSize
+func NewFrameReader(r io.Reader, maxSize uint32) *FrameReader {
+	if maxSize == 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+
+	return &FrameReader{
+		reader:  r,
+		maxSize: maxSize,
+	}
+}
+
+// ReadFrame blocks until the whole next frame is read and returns it decoded.
+// io.EOF is returned only when stream is closed between frames,
+// *PayloadError comes together with the message if payload can't be decoded,
+// *FrameError or errors of underlying reader mean the stream can't be used anymore
+func (fr *FrameReader) ReadFrame() (*NetMessage, error) {
+	_, err := io.ReadFull(fr.reader, fr.header[:])
+	if err == io.ErrUnexpectedEOF {
+		return nil, &FrameError{Type: fr.header[0], Err: ErrTruncatedFrame}
+	} else if err != nil {
+		return nil, err
+	}
+
+	netMessage := NewInNetMessage(fr.header)
+
+	if netMessage.Length > fr.maxSize {
+		return nil, &FrameError{Type: netMessage.Type, Length: netMessage.Length, Err: ErrFrameTooLarge}
+	}
+
+	payload := make([]byte, netMessage.Length)
+
+	_, err = io.ReadFull(fr.reader, payload)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, &FrameError{Type: netMessage.Type, Length: netMessage.Length, Err: ErrTruncatedFrame}
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = netMessage.Unmarshal(payload)
+	if err != nil {
+		return netMessage, &PayloadError{Type: netMessage.Type, Err: err}
+	}
+
+	return netMessage, nil
+}
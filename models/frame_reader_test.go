@@ -0,0 +1,167 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func frame(t uint8, payload []byte) []byte {
+	nm := NewNetMessage(t)
+	nm.OutPayload = rawPayload(payload)
+
+	data, _ := nm.Marshal()
+
+	return data
+}
+
+type rawPayload []byte
+
+func (r rawPayload) Marshal() ([]byte, error) {
+	return r, nil
+}
+
+// chunkReader returns data by chunks of fixed size, splitting frames at arbitrary places
+type chunkReader struct {
+	data  []byte
+	chunk int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+
+	n := c.chunk
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+
+	copy(p, c.data[:n])
+	c.data = c.data[n:]
+
+	return n, nil
+}
+
+func testStream() []byte {
+	stream := frame(ServerConfigChangeNotifyType, []byte{120, 0, 16, 0})
+	stream = append(stream, frame(ChatMessageType, []byte("MSG\x00Vasya\x00hello\x00\x00\x00"))...)
+	stream = append(stream, frame(ServerDownloadIntervalWriteType, append(make([]byte, 17), bytes.Repeat([]byte{0xAB}, 3000)...))...)
+	stream = append(stream, frame(ClientKeepaliveType, nil)...)
+
+	return stream
+}
+
+func readAll(t *testing.T, fr *FrameReader) []*NetMessage {
+	messages := make([]*NetMessage, 0)
+
+	for {
+		nm, err := fr.ReadFrame()
+		if err == io.EOF {
+			return messages
+		}
+		if !assert.NoError(t, err) {
+			return messages
+		}
+
+		messages = append(messages, nm)
+	}
+}
+
+func assertTestStream(t *testing.T, messages []*NetMessage) {
+	if !assert.Len(t, messages, 4) {
+		return
+	}
+
+	config := messages[0].InPayload.(*ServerConfigChangeNotify)
+	assert.Equal(t, uint16(120), config.BPM)
+	assert.Equal(t, uint16(16), config.BPI)
+
+	chat := messages[1].InPayload.(*ChatMessage)
+	assert.Equal(t, "Vasya", string(chat.Arg1))
+	assert.Equal(t, "hello", string(chat.Arg2))
+
+	write := messages[2].InPayload.(*ServerDownloadIntervalWrite)
+	assert.Len(t, write.AudioData, 3000)
+
+	assert.Equal(t, ClientKeepaliveType, messages[3].Type)
+	assert.Equal(t, uint32(0), messages[3].Length)
+}
+
+func TestFrameReader_Concatenated(t *testing.T) {
+	fr := NewFrameReader(bytes.NewReader(testStream()), 0)
+
+	assertTestStream(t, readAll(t, fr))
+}
+
+func TestFrameReader_Fragmented(t *testing.T) {
+	fr := NewFrameReader(iotest.OneByteReader(bytes.NewReader(testStream())), 0)
+	assertTestStream(t, readAll(t, fr))
+
+	for _, chunk := range []int{2, 3, 7, 1000} {
+		fr := NewFrameReader(&chunkReader{data: testStream(), chunk: chunk}, 0)
+		assertTestStream(t, readAll(t, fr))
+	}
+}
+
+func TestFrameReader_TooLarge(t *testing.T) {
+	fr := NewFrameReader(bytes.NewReader(testStream()), 1000)
+
+	_, err := fr.ReadFrame()
+	assert.NoError(t, err)
+	_, err = fr.ReadFrame()
+	assert.NoError(t, err)
+
+	_, err = fr.ReadFrame()
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+
+	var frameErr *FrameError
+	if assert.True(t, errors.As(err, &frameErr)) {
+		assert.Equal(t, ServerDownloadIntervalWriteType, frameErr.Type)
+		assert.Equal(t, uint32(3017), frameErr.Length)
+	}
+}
+
+func TestFrameReader_Truncated(t *testing.T) {
+	stream := testStream()
+
+	// stream ends inside header
+	fr := NewFrameReader(bytes.NewReader(stream[:3]), 0)
+	_, err := fr.ReadFrame()
+	assert.True(t, errors.Is(err, ErrTruncatedFrame))
+
+	// stream ends inside payload
+	fr = NewFrameReader(bytes.NewReader(stream[:7]), 0)
+	_, err = fr.ReadFrame()
+	assert.True(t, errors.Is(err, ErrTruncatedFrame))
+
+	// header without payload
+	fr = NewFrameReader(bytes.NewReader(stream[:5]), 0)
+	_, err = fr.ReadFrame()
+	assert.True(t, errors.Is(err, ErrTruncatedFrame))
+}
+
+func TestFrameReader_PayloadError(t *testing.T) {
+	stream := frame(ServerConfigChangeNotifyType, []byte{120})
+	stream = append(stream, frame(ServerConfigChangeNotifyType, []byte{100, 0, 8, 0})...)
+
+	fr := NewFrameReader(bytes.NewReader(stream), 0)
+
+	_, err := fr.ReadFrame()
+	var payloadErr *PayloadError
+	assert.True(t, errors.As(err, &payloadErr))
+
+	// stream is still in sync, next frame is readable
+	nm, err := fr.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(100), nm.InPayload.(*ServerConfigChangeNotify).BPM)
+
+	_, err = fr.ReadFrame()
+	assert.Equal(t, io.EOF, err)
+}
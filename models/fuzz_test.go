@@ -0,0 +1,153 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzPayload checks that decoder never panics on arbitrary input
+// and that decoded payload is encoded and decoded back to the same bytes
+func fuzzPayload(f *testing.F, newPayload func() payload, seeds ...payload) {
+	for _, seed := range seeds {
+		data, err := seed.Marshal()
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(data)
+		// the same payload cut in the middle and without the last byte
+		f.Add(data[:len(data)/2])
+		if len(data) > 0 {
+			f.Add(data[:len(data)-1])
+		}
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := newPayload()
+		if err := p.Unmarshal(data); err != nil {
+			return
+		}
+
+		encoded, err := p.Marshal()
+		if err != nil {
+			t.Fatalf("decoded payload can't be encoded: %v", err)
+		}
+
+		decoded := newPayload()
+		if err := decoded.Unmarshal(encoded); err != nil {
+			t.Fatalf("encoded payload can't be decoded: %v\n%x", err, encoded)
+		}
+
+		reencoded, err := decoded.Marshal()
+		if err != nil {
+			t.Fatalf("decoded payload can't be encoded: %v", err)
+		}
+
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("payload changed after round trip:\n%x\n%x", encoded, reencoded)
+		}
+	})
+}
+
+func FuzzServerAuthChallenge(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ServerAuthChallenge{} },
+		&ServerAuthChallenge{Challenge: [8]uint8{1, 2, 3}, ServerCapabilities: 0x00000301, ProtocolVersion: 0x00020000, LicenseAgreement: []byte("be nice")},
+		&ServerAuthChallenge{ServerCapabilities: 0x00000300, ProtocolVersion: 0x00020000},
+	)
+}
+
+func FuzzServerAuthReply(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ServerAuthReply{} },
+		&ServerAuthReply{Flag: 1, ErrorMessage: []byte("bot"), MaxChannels: 2},
+		&ServerAuthReply{ErrorMessage: []byte("invalid login/password")},
+	)
+}
+
+func FuzzServerConfigChangeNotify(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ServerConfigChangeNotify{} },
+		&ServerConfigChangeNotify{BPM: 120, BPI: 16},
+	)
+}
+
+func FuzzServerUserInfoChangeNotify(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ServerUserInfoChangeNotify{} },
+		&ServerUserInfoChangeNotify{UserInfos: []UserInfo{
+			{Active: 1, Volume: -30, Pan: -64, Flags: ChannelFlagVoiceChat, Name: []byte("Vasya@127.0.0.x"), ChannelName: []byte("guitar")},
+			{ChannelIndex: 1, Name: []byte("Petya@127.0.0.x"), ChannelName: []byte{}},
+		}},
+	)
+}
+
+func FuzzServerDownloadIntervalBegin(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ServerDownloadIntervalBegin{} },
+		&ServerDownloadIntervalBegin{GUID: [16]byte{1}, EstimatedSize: 4096, FourCC: [4]byte{'O', 'G', 'G', 'v'}, ChannelIndex: 1, Username: []byte("Vasya@127.0.0.x")},
+	)
+}
+
+func FuzzServerDownloadIntervalWrite(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ServerDownloadIntervalWrite{} },
+		&ServerDownloadIntervalWrite{GUID: [16]byte{1}, Flags: 1, AudioData: []byte("OggS")},
+	)
+}
+
+func FuzzClientAuthUser(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ClientAuthUser{} },
+		NewClientAuthUser("anonymous:bot", "", true, [8]uint8{1, 2, 3}),
+	)
+}
+
+func FuzzClientSetUsermask(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ClientSetUsermask{} },
+		&ClientSetUsermask{UserMasks: []UserMask{{Name: "Vasya@127.0.0.x", ChannelMask: AllChannels}, {Name: "Petya"}}},
+	)
+}
+
+func FuzzClientSetChannelInfo(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ClientSetChannelInfo{} },
+		&ClientSetChannelInfo{Channels: []ChannelInfo{{Name: "metronome", Volume: -60}, {Name: "voice", Pan: -20, Flags: ChannelFlagVoiceChat}}},
+	)
+}
+
+func FuzzClientUploadIntervalBegin(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ClientUploadIntervalBegin{} },
+		&ClientUploadIntervalBegin{GUID: [16]byte{1}, EstimatedSize: 4096, ChannelIndex: 1},
+	)
+}
+
+func FuzzClientUploadIntervalWrite(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ClientUploadIntervalWrite{} },
+		&ClientUploadIntervalWrite{GUID: [16]byte{1}, Flags: 1, AudioData: []byte("OggS")},
+	)
+}
+
+func FuzzChatMessage(f *testing.F) {
+	fuzzPayload(f, func() payload { return &ChatMessage{} },
+		&ChatMessage{Command: []byte(MSG), Arg1: []byte("Vasya"), Arg2: []byte("hello")},
+		&ChatMessage{Command: []byte(PRIVMSG), Arg1: []byte("Vasya"), Arg2: []byte("psst")},
+	)
+}
+
+// FuzzFrameReader feeds arbitrary stream to FrameReader the way the bot reads server connection
+func FuzzFrameReader(f *testing.F) {
+	f.Add(frame(ServerConfigChangeNotifyType, []byte{120, 0, 16, 0}))
+	f.Add(frame(ChatMessageType, []byte("MSG\x00Vasya\x00hello")))
+	f.Add(append(frame(ServerAuthReplyType, []byte{1}), frame(ClientKeepaliveType, nil)...))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		fr := NewFrameReader(bytes.NewReader(stream), 1024)
+		for i := 0; ; i++ {
+			if i > len(stream) {
+				t.Fatal("reader doesn't advance")
+			}
+
+			_, err := fr.ReadFrame()
+			if _, ok := err.(*PayloadError); ok {
+				continue
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
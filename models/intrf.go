@@ -1,9 +1,9 @@
 package models
 
 type Mountser interface {
-	Mounts() map[string][]string
+	Mounts() map[string]Mount
 }
 
 type Userser interface {
-	Users() []string
+	Users() []User
 }
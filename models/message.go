@@ -1,7 +1,25 @@
 package models
 
+import (
+	"strings"
+)
+
 type Message struct {
 	Type string
 	Name string
+	To   string // recipient of PRIVMSG
 	Text string
 }
+
+// SplitRecipient splits "name text" to recipient name and text
+func SplitRecipient(text string) (name, body string, ok bool) {
+	parts := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	name = parts[0]
+	body = strings.TrimSpace(parts[1])
+
+	return name, body, name != "" && body != ""
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitRecipient(t *testing.T) {
+	name, body, ok := SplitRecipient(" @vasya  привет, как дела? ")
+	assert.True(t, ok)
+	assert.Equal(t, "@vasya", name)
+	assert.Equal(t, "привет, как дела?", body)
+
+	_, _, ok = SplitRecipient("vasya")
+	assert.False(t, ok)
+
+	_, _, ok = SplitRecipient("vasya   ")
+	assert.False(t, ok)
+}
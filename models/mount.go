@@ -0,0 +1,51 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mount is a NINJAM server state as chats show it
+type Mount struct {
+	Users  []User
+	Idle   []User // users who don't play, when activity is monitored Users are only those who play
+	Online bool
+	Status string // connection state of offline server, e.g. "offline, retrying in 40s"
+	Stream string // URL of live audio stream of the server, empty when there is no stream or it's unknown
+	// Listeners - number of current listeners of the stream and of stream servers which relay it
+	Listeners int
+}
+
+// Describe returns server state for chat replies
+func (m Mount) Describe(name string) string {
+	if !m.Online {
+		return "Сервер " + name + " " + m.Status
+	}
+
+	var description string
+	users := JoinUsers(m.Users)
+	idle := JoinUsers(m.Idle)
+	switch {
+	case users == "" && idle == "":
+		description = "На сервере " + name + " никого нет. "
+	case idle == "":
+		description = "На сервере " + name + " играют: " + users
+	case users == "":
+		description = "На сервере " + name + " никто не играет, слушают: " + idle
+	default:
+		description = "На сервере " + name + " играют: " + users + "; слушают: " + idle
+	}
+
+	switch {
+	case m.Stream != "":
+		return fmt.Sprintf("%s. Трансляция: %s, слушателей: %d", trimDot(description), m.Stream, m.Listeners)
+	case m.Listeners > 0:
+		return fmt.Sprintf("%s. Слушателей трансляции: %d", trimDot(description), m.Listeners)
+	}
+
+	return description
+}
+
+func trimDot(text string) string {
+	return strings.TrimSuffix(strings.TrimSpace(text), ".")
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMount_Describe(t *testing.T) {
+	m := Mount{Online: true}
+	assert.Equal(t, "На сервере 2050 никого нет. ", m.Describe("2050"))
+
+	m.Users = []User{{Name: "Vasya", Channels: []Channel{{Name: "guitar"}}}}
+	assert.Equal(t, "На сервере 2050 играют: Vasya (guitar)", m.Describe("2050"))
+
+	m.Idle = []User{{Name: "Petya"}, {Name: "Kolya", Channels: []Channel{{Name: "bass"}}}}
+	assert.Equal(t, "На сервере 2050 играют: Vasya (guitar); слушают: Petya, Kolya (bass)", m.Describe("2050"))
+
+	m.Users = nil
+	assert.Equal(t, "На сервере 2050 никто не играет, слушают: Petya, Kolya (bass)", m.Describe("2050"))
+
+	m.Stream = "http://jam.example.com:8000/2050"
+	m.Listeners = 3
+	assert.Equal(t, "На сервере 2050 никто не играет, слушают: Petya, Kolya (bass). Трансляция: http://jam.example.com:8000/2050, слушателей: 3", m.Describe("2050"))
+
+	m = Mount{Online: true, Stream: "http://jam.example.com:8000/2050"}
+	assert.Equal(t, "На сервере 2050 никого нет. Трансляция: http://jam.example.com:8000/2050, слушателей: 0", m.Describe("2050"))
+
+	// слушатели ретранслятора без адреса трансляции
+	m = Mount{Online: true, Users: []User{{Name: "Vasya"}}, Listeners: 12}
+	assert.Equal(t, "На сервере 2050 играют: Vasya. Слушателей трансляции: 12", m.Describe("2050"))
+
+	m = Mount{Status: "offline, retrying in 40s"}
+	assert.Equal(t, "Сервер 2050 offline, retrying in 40s", m.Describe("2050"))
+}
@@ -76,6 +76,27 @@ func (nm *NetMessage) Unmarshal(data []byte) error {
 	case ServerConfigChangeNotifyType:
 		nm.InPayload = &ServerConfigChangeNotify{}
 		return nm.InPayload.Unmarshal(data)
+	case ServerDownloadIntervalBeginType:
+		nm.InPayload = &ServerDownloadIntervalBegin{}
+		return nm.InPayload.Unmarshal(data)
+	case ServerDownloadIntervalWriteType:
+		nm.InPayload = &ServerDownloadIntervalWrite{}
+		return nm.InPayload.Unmarshal(data)
+	case ClientAuthUserType:
+		nm.InPayload = &ClientAuthUser{}
+		return nm.InPayload.Unmarshal(data)
+	case ClientSetUsermaskType:
+		nm.InPayload = &ClientSetUsermask{}
+		return nm.InPayload.Unmarshal(data)
+	case ClientSetChannelInfoType:
+		nm.InPayload = &ClientSetChannelInfo{}
+		return nm.InPayload.Unmarshal(data)
+	case ClientUploadIntervalBeginType:
+		nm.InPayload = &ClientUploadIntervalBegin{}
+		return nm.InPayload.Unmarshal(data)
+	case ClientUploadIntervalWriteType:
+		nm.InPayload = &ClientUploadIntervalWrite{}
+		return nm.InPayload.Unmarshal(data)
 	}
 
 	return nil
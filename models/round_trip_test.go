@@ -0,0 +1,201 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type payload interface {
+	Marshaler
+	Unmarshaler
+}
+
+func TestPayloads_RoundTrip(t *testing.T) {
+	guid := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	tests := []struct {
+		name    string
+		msgType uint8
+		in      payload
+		out     payload
+	}{
+		{
+			name:    "ServerAuthChallenge",
+			msgType: ServerAuthChallengeType,
+			in: &ServerAuthChallenge{
+				Challenge:          [8]uint8{1, 2, 3, 4, 5, 6, 7, 8},
+				ServerCapabilities: 0x00000A01,
+				ProtocolVersion:    0x00020000,
+				LicenseAgreement:   []byte("be nice"),
+			},
+			out: &ServerAuthChallenge{},
+		},
+		{
+			name:    "ServerAuthReply",
+			msgType: ServerAuthReplyType,
+			in:      &ServerAuthReply{Flag: 1, ErrorMessage: []byte("bot"), MaxChannels: 2},
+			out:     &ServerAuthReply{},
+		},
+		{
+			name:    "ServerConfigChangeNotify",
+			msgType: ServerConfigChangeNotifyType,
+			in:      &ServerConfigChangeNotify{BPM: 120, BPI: 16},
+			out:     &ServerConfigChangeNotify{},
+		},
+		{
+			name:    "ServerUserInfoChangeNotify",
+			msgType: ServerUserInfoChangeNotifyType,
+			in: &ServerUserInfoChangeNotify{UserInfos: []UserInfo{
+				{Active: 1, ChannelIndex: 0, Volume: -30, Pan: -64, Flags: ChannelFlagVoiceChat, Name: []byte("Vasya@127.0.0.x"), ChannelName: []byte("guitar")},
+				{Active: 0, ChannelIndex: 1, Volume: 10, Pan: 127, Name: []byte("Petya@127.0.0.x"), ChannelName: []byte("bass")},
+			}},
+			out: &ServerUserInfoChangeNotify{},
+		},
+		{
+			name:    "ServerDownloadIntervalBegin",
+			msgType: ServerDownloadIntervalBeginType,
+			in:      &ServerDownloadIntervalBegin{GUID: guid, EstimatedSize: 12345, FourCC: [4]byte{'O', 'G', 'G', 'v'}, ChannelIndex: 1, Username: []byte("Vasya@127.0.0.x")},
+			out:     &ServerDownloadIntervalBegin{},
+		},
+		{
+			name:    "ServerDownloadIntervalWrite",
+			msgType: ServerDownloadIntervalWriteType,
+			in:      &ServerDownloadIntervalWrite{GUID: guid, Flags: 1, AudioData: []byte{0, 1, 2, 3}},
+			out:     &ServerDownloadIntervalWrite{},
+		},
+		{
+			name:    "ClientAuthUser",
+			msgType: ClientAuthUserType,
+			in:      NewClientAuthUser("anonymous:bot", "", true, [8]uint8{1, 2, 3, 4, 5, 6, 7, 8}),
+			out:     &ClientAuthUser{},
+		},
+		{
+			name:    "ClientSetUsermask",
+			msgType: ClientSetUsermaskType,
+			in: &ClientSetUsermask{UserMasks: []UserMask{
+				{Name: "Vasya@127.0.0.x", ChannelMask: AllChannels},
+				{Name: "Petya@127.0.0.x", ChannelMask: 0},
+			}},
+			out: &ClientSetUsermask{},
+		},
+		{
+			name:    "ClientSetChannelInfo",
+			msgType: ClientSetChannelInfoType,
+			in: &ClientSetChannelInfo{Channels: []ChannelInfo{
+				{Name: "metronome", Volume: -60, Pan: 0, Flags: 0},
+				{Name: "voice", Volume: 10, Pan: -20, Flags: ChannelFlagVoiceChat},
+			}},
+			out: &ClientSetChannelInfo{},
+		},
+		{
+			name:    "ClientUploadIntervalBegin",
+			msgType: ClientUploadIntervalBeginType,
+			in:      &ClientUploadIntervalBegin{GUID: guid, EstimatedSize: 4096, ChannelIndex: 1},
+			out:     &ClientUploadIntervalBegin{},
+		},
+		{
+			name:    "ClientUploadIntervalWrite",
+			msgType: ClientUploadIntervalWriteType,
+			in:      &ClientUploadIntervalWrite{GUID: guid, Flags: 1, AudioData: []byte{4, 5, 6}},
+			out:     &ClientUploadIntervalWrite{},
+		},
+		{
+			name:    "ChatMessage",
+			msgType: ChatMessageType,
+			in:      &ChatMessage{Command: []byte(PRIVMSG), Arg1: []byte("Vasya"), Arg2: []byte("hello"), Arg3: []byte{}, Arg4: []byte{}},
+			out:     &ChatMessage{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.in.Marshal()
+			require.NoError(t, err)
+
+			require.NoError(t, tt.out.Unmarshal(data))
+			assert.Equal(t, tt.in, tt.out)
+
+			// the same payload framed by NetMessage and read back by FrameReader
+			nm := NewNetMessage(tt.msgType)
+			nm.OutPayload = tt.in
+			frame, err := nm.Marshal()
+			require.NoError(t, err)
+
+			read, err := NewFrameReader(bytes.NewReader(frame), 0).ReadFrame()
+			require.NoError(t, err)
+			assert.Equal(t, tt.msgType, read.Type)
+			assert.Equal(t, tt.in, read.InPayload)
+		})
+	}
+}
+
+func TestServerAuthChallenge_MarshalWithoutAgreement(t *testing.T) {
+	sac := &ServerAuthChallenge{ServerCapabilities: 0x00000A00, LicenseAgreement: []byte("ignored")}
+
+	data, err := sac.Marshal()
+	require.NoError(t, err)
+	assert.Len(t, data, 16)
+}
+
+func TestPayloads_MarshalInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Marshaler
+		err  error
+	}{
+		{"ServerAuthChallenge", &ServerAuthChallenge{ServerCapabilities: 0x00000A01, LicenseAgreement: []byte("be\x00nice")}, ErrNulInString},
+		{"ServerAuthReply", &ServerAuthReply{ErrorMessage: []byte("bad\x00")}, ErrNulInString},
+		{"ServerUserInfoChangeNotify", &ServerUserInfoChangeNotify{UserInfos: []UserInfo{{Name: []byte("Vasya"), ChannelName: []byte("\x00")}}}, ErrNulInString},
+		{"ServerDownloadIntervalBegin", &ServerDownloadIntervalBegin{Username: []byte("Vasya\x00")}, ErrNulInString},
+		{"ServerDownloadIntervalWrite", &ServerDownloadIntervalWrite{AudioData: make([]byte, DefaultMaxFrameSize)}, ErrPayloadTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.in.Marshal()
+			assert.True(t, errors.Is(err, tt.err), "%v", err)
+			assert.Nil(t, data)
+		})
+	}
+}
+
+func TestClientAuthUser_CheckPassword(t *testing.T) {
+	challenge := [8]uint8{8, 7, 6, 5, 4, 3, 2, 1}
+	cau := NewClientAuthUser("vasya", "secret", false, challenge)
+
+	assert.True(t, cau.CheckPassword("secret", challenge))
+	assert.False(t, cau.CheckPassword("wrong", challenge))
+	assert.False(t, cau.CheckPassword("secret", [8]uint8{}))
+	assert.False(t, cau.HasAgreement())
+}
+
+func TestClientSetChannelInfo_UnmarshalProtocol(t *testing.T) {
+	// parameters size 5 - a newer client sends one more parameter byte the server doesn't know
+	data := []byte{5, 0}
+	data = append(data, "guitar\x00"...)
+	data = append(data, 0xF6, 0xFF, 0x10, ChannelFlagSessionMode, 0xAA)
+	data = append(data, "voice\x00"...)
+	data = append(data, 0x0A, 0x00, 0xF0, ChannelFlagVoiceChat, 0xBB)
+
+	c := &ClientSetChannelInfo{}
+	require.NoError(t, c.Unmarshal(data))
+	assert.Equal(t, []ChannelInfo{
+		{Name: "guitar", Volume: -10, Pan: 16, Flags: ChannelFlagSessionMode},
+		{Name: "voice", Volume: 10, Pan: -16, Flags: ChannelFlagVoiceChat},
+	}, c.Channels)
+}
+
+func TestClientUploadIntervalWrite_SetLast(t *testing.T) {
+	write := &ClientUploadIntervalWrite{Flags: 0x02}
+	assert.False(t, write.IsLast())
+
+	write.SetLast(true)
+	assert.True(t, write.IsLast())
+	assert.Equal(t, uint8(0x03), write.Flags)
+
+	write.SetLast(false)
+	assert.Equal(t, uint8(0x02), write.Flags)
+}
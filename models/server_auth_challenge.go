@@ -3,8 +3,6 @@ package models
 import (
 	"time"
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"bytes"
 	"encoding/binary"
 )
 
@@ -16,30 +14,42 @@ type ServerAuthChallenge struct {
 	LicenseAgreement   []byte // NUL-terminated
 }
 
-func (sac *ServerAuthChallenge) Unmarshal(data []byte) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("Input data error: %s", r)
-			return
-		}
-	}()
+func (sac *ServerAuthChallenge) Unmarshal(data []byte) error {
+	d := newDecoder(data)
 
-	sac.Challenge = [8]uint8{}
-	for i := 0; i < 8; i++ {
-		sac.Challenge[i] = uint8(data[i])
+	copy(sac.Challenge[:], d.bytes("challenge", 8))
+	sac.ServerCapabilities = d.uint32("server capabilities")
+	sac.ProtocolVersion = d.uint32("protocol version")
+
+	// license agreement is sent only when server requires it
+	sac.LicenseAgreement = nil
+	if sac.HasAgreement() {
+		sac.LicenseAgreement = d.string("license agreement")
 	}
 
-	sac.ServerCapabilities = binary.LittleEndian.Uint32(data[8:12])
+	return d.err
+}
 
-	sac.ProtocolVersion = binary.LittleEndian.Uint32(data[12:16])
+func (sac *ServerAuthChallenge) Marshal() ([]byte, error) {
+	var data []byte
+	data = append(data, sac.Challenge[:]...)
 
-	nulTerminator := bytes.Index(data[16:], []byte{0x0})
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:4], sac.ServerCapabilities)
+	binary.LittleEndian.PutUint32(b[4:8], sac.ProtocolVersion)
+	data = append(data, b...)
 
-	if nulTerminator != -1 {
-		sac.LicenseAgreement = data[16:nulTerminator+16]
+	// license agreement is sent only when server requires it
+	if !sac.HasAgreement() {
+		return data, nil
 	}
 
-	return nil
+	data, err := appendString(data, "license agreement", sac.LicenseAgreement)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkSize(data)
 }
 
 func (sac *ServerAuthChallenge) KeepAliveInterval() (interval time.Duration, err error) {
@@ -56,8 +66,6 @@ func (sac *ServerAuthChallenge) KeepAliveInterval() (interval time.Duration, err
 
 	i := binary.LittleEndian.Uint16(b[1:])
 
-	logrus.Infof("Keep alive interval: %ds", i)
-
 	return time.Second * time.Duration(i), nil
 }
 
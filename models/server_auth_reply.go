@@ -1,10 +1,5 @@
 package models
 
-import (
-	"fmt"
-	"bytes"
-)
-
 // ServerAuthReply
 //0x01
 type ServerAuthReply struct {
@@ -13,21 +8,30 @@ type ServerAuthReply struct {
 	MaxChannels  uint8
 }
 
-func (sac *ServerAuthReply) Unmarshal(data []byte) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("Input data error: %s", r)
-			return
-		}
-	}()
+func (sac *ServerAuthReply) Unmarshal(data []byte) error {
+	d := newDecoder(data)
 
-	sac.Flag = uint8(data[0])
+	sac.Flag = d.uint8("flag")
 
-	nulTerminator := bytes.Index(data[1:], []byte{0x0})
+	// the message and max channels are optional, ninjamsrv omits them on some errors
+	sac.ErrorMessage = nil
+	sac.MaxChannels = 0
+	if d.remaining() > 0 {
+		sac.ErrorMessage = d.string("error message")
+	}
+	if d.remaining() > 0 {
+		sac.MaxChannels = d.uint8("max channels")
+	}
 
-	sac.ErrorMessage = data[1:nulTerminator+1]
+	return d.err
+}
 
-	sac.MaxChannels = data[nulTerminator+2]
+func (sac *ServerAuthReply) Marshal() ([]byte, error) {
+	data, err := appendString([]byte{sac.Flag}, "error message", sac.ErrorMessage)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, sac.MaxChannels)
 
-	return nil
+	return checkSize(data)
 }
@@ -2,7 +2,6 @@ package models
 
 import (
 	"encoding/binary"
-	"fmt"
 )
 
 // ServerAuthReply
@@ -12,16 +11,19 @@ type ServerConfigChangeNotify struct {
 	BPI uint16
 }
 
-func (sac *ServerConfigChangeNotify) Unmarshal(data []byte) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("Input data error: %s", r)
-			return
-		}
-	}()
+func (sac *ServerConfigChangeNotify) Unmarshal(data []byte) error {
+	d := newDecoder(data)
 
-	sac.BPM = binary.LittleEndian.Uint16(data[:2])
-	sac.BPI = binary.LittleEndian.Uint16(data[2:4])
+	sac.BPM = d.uint16("BPM")
+	sac.BPI = d.uint16("BPI")
 
-	return nil
+	return d.err
+}
+
+func (sac *ServerConfigChangeNotify) Marshal() ([]byte, error) {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint16(data[0:2], sac.BPM)
+	binary.LittleEndian.PutUint16(data[2:4], sac.BPI)
+
+	return data, nil
 }
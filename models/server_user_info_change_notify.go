@@ -1,81 +1,93 @@
 package models
 
 import (
-	"fmt"
-	"bytes"
-	"github.com/sirupsen/logrus"
-	"runtime/debug"
+	"encoding/binary"
 )
 
+// ServerUserInfoChangeNotify
+// 0x03
 type ServerUserInfoChangeNotify struct {
 	UserInfos []UserInfo
 }
 
+// UserInfo describes one channel of the user, users with several channels come as several records
 type UserInfo struct {
 	Active       uint8
 	ChannelIndex uint8
-	Volume       [2]byte
-	Pan          byte
-	Flags        uint8
+	Volume       int16 // (dB gain, 0=0dB, 10=1dB, -30=-3dB, etc)
+	Pan          int8  // [-128, 127]
+	Flags        uint8 // 0 - ninjam interval based , 2 - voice chat, 4 - session mode
 	Name         []byte
-	Channels     [][]byte
+	ChannelName  []byte
 }
 
-func (s *ServerUserInfoChangeNotify) Unmarshal(data []byte) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("Input data error: %s", r)
-			logrus.Debug(string(debug.Stack()))
-			return
-		}
-	}()
+// minimal record: 6 bytes of fields and two NUL-terminated strings
+const userInfoMinLength = 8
+
+func (s *ServerUserInfoChangeNotify) Unmarshal(data []byte) error {
+	d := newDecoder(data)
 
 	if s.UserInfos == nil {
 		s.UserInfos = make([]UserInfo, 0)
 	}
 
-	for {
-		if len(data) < 7 {
-			break
-		}
-
+	// shorter tail can't be a record, ninjamsrv ignores it too
+	for d.remaining() >= userInfoMinLength {
 		userInfo := UserInfo{}
-		userInfo.Active = uint8(data[0])
-		userInfo.ChannelIndex = uint8(data[1])
-
-		copy(userInfo.Volume[:], data[2:4])
-
-		userInfo.Pan = data[4]
-		userInfo.Flags = uint8(data[5])
-
-		data = data[6:]
-
-		nulTerminator := bytes.Index(data, []byte{0x0})
+		userInfo.Active = d.uint8("active")
+		userInfo.ChannelIndex = d.uint8("channel index")
+		userInfo.Volume = int16(d.uint16("volume"))
+		userInfo.Pan = int8(d.uint8("pan"))
+		userInfo.Flags = d.uint8("flags")
+		userInfo.Name = d.string("user name")
+		userInfo.ChannelName = d.string("channel name")
+
+		if d.err != nil {
+			return d.err
+		}
 
-		userInfo.Name = data[:nulTerminator]
+		s.UserInfos = append(s.UserInfos, userInfo)
+	}
 
-		data = data[nulTerminator+1:]
+	return nil
+}
 
-		nulTerminator = bytes.Index(data, []byte{0x0})
+func (s *ServerUserInfoChangeNotify) Marshal() ([]byte, error) {
+	var data []byte
+	var err error
 
-		channel := data[:nulTerminator]
+	for _, userInfo := range s.UserInfos {
+		volume := make([]byte, 2)
+		binary.LittleEndian.PutUint16(volume, uint16(userInfo.Volume))
 
-		data = data[nulTerminator+1:]
+		data = append(data, userInfo.Active, userInfo.ChannelIndex)
+		data = append(data, volume...)
+		data = append(data, byte(userInfo.Pan), userInfo.Flags)
 
-		if userInfo.Channels == nil {
-			userInfo.Channels = make([][]byte, 0)
+		if data, err = appendString(data, "user name", userInfo.Name); err != nil {
+			return nil, err
+		}
+		if data, err = appendString(data, "channel name", userInfo.ChannelName); err != nil {
+			return nil, err
 		}
+	}
 
-		userInfo.Channels = append(userInfo.Channels, channel)
+	return checkSize(data)
+}
 
-		nulTerminator = bytes.Index(data, []byte{0x0})
+func (u UserInfo) IsActive() bool {
+	return u.Active != 0
+}
 
-		s.UserInfos = append(s.UserInfos, userInfo)
+// VolumeDB returns channel volume in dB
+func (u UserInfo) VolumeDB() float64 {
+	return float64(u.Volume) / 10
+}
 
-		if nulTerminator == -1 {
-			break
-		}
-	}
+func (u UserInfo) IsVoiceChat() bool {
+	return u.Flags&ChannelFlagVoiceChat != 0
+}
 
-	return nil
+func (u UserInfo) IsSessionMode() bool {
+	return u.Flags&ChannelFlagSessionMode != 0
 }
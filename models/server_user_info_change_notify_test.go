@@ -0,0 +1,56 @@
+package models
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestServerUserInfoChangeNotify_Unmarshal(t *testing.T) {
+	data := []byte{1, 0, 0xE2, 0xFF, 0x80, 0x0} // active, channel 0, -3dB, pan -128
+	data = append(data, []byte("Vasya@127.0.0.x\x00guitar\x00")...)
+	data = append(data, 1, 1, 10, 0, 127, 0x2) // active, channel 1, 1dB, pan 127, voice chat
+	data = append(data, []byte("Vasya@127.0.0.x\x00vocals\x00")...)
+	data = append(data, 0, 0, 0, 0, 0, 0x4) // inactive, channel 0, session mode
+	data = append(data, []byte("Petya@127.0.0.x\x00\x00")...)
+
+	s := &ServerUserInfoChangeNotify{}
+	err := s.Unmarshal(data)
+	assert.NoError(t, err)
+
+	if !assert.Len(t, s.UserInfos, 3) {
+		return
+	}
+
+	guitar := s.UserInfos[0]
+	assert.True(t, guitar.IsActive())
+	assert.Equal(t, "Vasya@127.0.0.x", string(guitar.Name))
+	assert.Equal(t, "guitar", string(guitar.ChannelName))
+	assert.Equal(t, int16(-30), guitar.Volume)
+	assert.Equal(t, -3.0, guitar.VolumeDB())
+	assert.Equal(t, int8(-128), guitar.Pan)
+	assert.False(t, guitar.IsVoiceChat())
+
+	vocals := s.UserInfos[1]
+	assert.Equal(t, uint8(1), vocals.ChannelIndex)
+	assert.Equal(t, "vocals", string(vocals.ChannelName))
+	assert.Equal(t, 1.0, vocals.VolumeDB())
+	assert.Equal(t, int8(127), vocals.Pan)
+	assert.True(t, vocals.IsVoiceChat())
+
+	petya := s.UserInfos[2]
+	assert.False(t, petya.IsActive())
+	assert.Equal(t, "Petya@127.0.0.x", string(petya.Name))
+	assert.Equal(t, "", string(petya.ChannelName))
+	assert.True(t, petya.IsSessionMode())
+}
+
+func TestUser_String(t *testing.T) {
+	users := []User{
+		{Name: "Vasya", Channels: []Channel{{Name: "guitar"}, {Index: 1, Name: "vocals"}}},
+		{Name: "Petya", Channels: []Channel{{}}},
+	}
+
+	assert.Equal(t, "Vasya (guitar, vocals)", users[0].String())
+	assert.Equal(t, "Petya", users[1].String())
+	assert.Equal(t, "Vasya (guitar, vocals), Petya", JoinUsers(users))
+}
@@ -59,3 +59,38 @@ func (c *ClientUploadIntervalWrite) Marshal() (data []byte, err error) {
 
 	return
 }
+
+// SetLast marks this chunk as the last one of the interval when last is true
+func (c *ClientUploadIntervalWrite) SetLast(last bool) {
+	if last {
+		c.Flags |= IntervalLastFlag
+	} else {
+		c.Flags &^= IntervalLastFlag
+	}
+}
+
+// IsLast reports whether this chunk completes the interval
+func (c *ClientUploadIntervalWrite) IsLast() bool {
+	return c.Flags&IntervalLastFlag != 0
+}
+
+func (c *ClientUploadIntervalBegin) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	copy(c.GUID[:], d.bytes("GUID", 16))
+	c.EstimatedSize = d.uint32("estimated size")
+	copy(c.FourCC[:], d.bytes("FourCC", 4))
+	c.ChannelIndex = d.uint8("channel index")
+
+	return d.err
+}
+
+func (c *ClientUploadIntervalWrite) Unmarshal(data []byte) error {
+	d := newDecoder(data)
+
+	copy(c.GUID[:], d.bytes("GUID", 16))
+	c.Flags = d.uint8("flags")
+	c.AudioData = d.rest()
+
+	return d.err
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+)
+
+// User is a NINJAM server user with his channels
+type User struct {
+	Name     string
+	Channels []Channel
+}
+
+// Channel is one of user's channels, as the server reported it
+type Channel struct {
+	Index  uint8
+	Name   string
+	Volume int16 // (dB gain, 0=0dB, 10=1dB, -30=-3dB, etc)
+	Pan    int8  // [-128, 127]
+	Flags  uint8 // 0 - ninjam interval based , 2 - voice chat, 4 - session mode
+}
+
+// ChannelNames returns names of user's channels, unnamed channels are skipped
+func (u User) ChannelNames() []string {
+	names := make([]string, 0, len(u.Channels))
+	for _, channel := range u.Channels {
+		if channel.Name != "" {
+			names = append(names, channel.Name)
+		}
+	}
+
+	return names
+}
+
+// String returns user name with his channels: "Vasya (guitar, vocals)"
+func (u User) String() string {
+	names := u.ChannelNames()
+	if len(names) == 0 {
+		return u.Name
+	}
+
+	return u.Name + " (" + strings.Join(names, ", ") + ")"
+}
+
+// JoinUsers returns comma separated users with their channels
+func JoinUsers(users []User) string {
+	s := make([]string, 0, len(users))
+	for _, user := range users {
+		s = append(s, user.String())
+	}
+
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,86 @@
+package ninjam_bot
+
+import (
+	"bytes"
+	"github.com/ayvan/ninjam-chatbot/capture"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNinJamBot_CaptureAndReplay(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.OnLogin(
+		ninjamtest.ConfigChangeNotify(95, 8),
+		ninjamtest.UserJoined("Vasya", 0, "guitar"),
+		ninjamtest.ChatMessage(models.MSG, "Vasya", "hello"),
+	)
+
+	buf := &bytes.Buffer{}
+	w, err := capture.NewWriter(buf)
+	require.NoError(t, err)
+
+	n := NewNinJamBot(server.Host(), server.Port(), "bot", "", true)
+	n.Capture(w)
+	events := n.Subscribe()
+	runBot(n)
+
+	// авторизация и три фрейма OnLogin
+	nextEvent(t, events)
+	nextEvent(t, events)
+	nextEvent(t, events)
+	nextEvent(t, events)
+
+	n.SendMessage("hi")
+	nextFrame(t, server)
+	n.Stop()
+	require.NoError(t, w.Close())
+
+	captured := buf.Bytes()
+
+	r, err := capture.NewReader(bytes.NewReader(captured))
+	require.NoError(t, err)
+	records, err := r.ReadAll()
+	require.NoError(t, err)
+
+	var sent []uint8
+	var received []uint8
+	for _, record := range records {
+		if record.Type == models.ClientKeepaliveType {
+			continue
+		}
+		if record.Direction == capture.Out {
+			sent = append(sent, record.Type)
+		} else {
+			received = append(received, record.Type)
+		}
+	}
+	assert.Equal(t, []uint8{models.ClientAuthUserType, models.ChatMessageType}, sent)
+	assert.Equal(t, []uint8{
+		models.ServerAuthChallengeType,
+		models.ServerAuthReplyType,
+		models.ServerConfigChangeNotifyType,
+		models.ServerUserInfoChangeNotifyType,
+		models.ChatMessageType,
+	}, received)
+
+	// записанный трафик проигрывается тестовым сервером новому клиенту
+	replay := ninjamtest.NewServer()
+	defer replay.Close()
+	require.NoError(t, replay.Replay(bytes.NewReader(captured)))
+
+	n = NewNinJamBot(replay.Host(), replay.Port(), "bot", "", true)
+	events = n.Subscribe()
+	runBot(n)
+	defer n.Stop()
+
+	_, ok := nextEvent(t, events).(AuthEvent)
+	assert.True(t, ok)
+	assert.Equal(t, ConfigChangeEvent{BPM: 95, BPI: 8}, nextEvent(t, events))
+	_, ok = nextEvent(t, events).(UserJoinedEvent)
+	assert.True(t, ok)
+	assert.Equal(t, ChatEvent{Message: models.Message{Type: models.MSG, Name: "Vasya", Text: "hello"}}, nextEvent(t, events))
+}
@@ -0,0 +1,276 @@
+package ninjam_bot
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second * 5):
+		t.Fatal("event was not received")
+		return nil
+	}
+}
+
+func nextFrame(t *testing.T, server *ninjamtest.Server) ninjamtest.Frame {
+	f, err := server.Next(time.Second * 5)
+	require.NoError(t, err)
+
+	return f
+}
+
+// unreachablePort returns port nobody listens on
+func unreachablePort() string {
+	server := ninjamtest.NewServer()
+	server.Close()
+
+	return server.Port()
+}
+
+// waitGoroutines waits until all goroutines started after baseline are finished
+func waitGoroutines(t *testing.T, baseline int) {
+	deadline := time.Now().Add(time.Second * 5)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("goroutines leaked: %d > %d\n%s", runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func runBot(n *NinJamBot) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- n.Connect(context.Background())
+	}()
+
+	return result
+}
+
+func TestNinJamBot_Connect(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	server := ninjamtest.NewServer()
+	server.OnLogin(
+		ninjamtest.ConfigChangeNotify(120, 16),
+		ninjamtest.UserJoined("Vasya", 0, "guitar"),
+		ninjamtest.ChatMessage(models.MSG, "Vasya", "hello"),
+	)
+
+	n := NewNinJamBot(server.Host(), server.Port(), "bot", "", true)
+	events := n.Subscribe()
+
+	result := runBot(n)
+
+	assert.Equal(t, AuthEvent{Success: true, Message: "bot", MaxChannels: ninjamtest.DefaultMaxChannels}, nextEvent(t, events))
+	assert.Equal(t, StateOnline, n.Status().State)
+
+	// пока бот работает, состояние читаем из других горутин
+	stopReading := make(chan struct{})
+	readersDone := make(chan struct{})
+	go func() {
+		defer close(readersDone)
+		for {
+			select {
+			case <-stopReading:
+				return
+			default:
+				n.Users()
+				n.Topic()
+				n.Subscriptions()
+			}
+		}
+	}()
+
+	assert.Equal(t, ConfigChangeEvent{BPM: 120, BPI: 16}, nextEvent(t, events))
+
+	joined, ok := nextEvent(t, events).(UserJoinedEvent)
+	if assert.True(t, ok) {
+		assert.Equal(t, "Vasya (guitar)", joined.User.String())
+	}
+
+	assert.Equal(t, ChatEvent{Message: models.Message{Type: models.MSG, Name: "Vasya", Text: "hello"}}, nextEvent(t, events))
+
+	n.SendMessage("hi")
+	assert.Equal(t, []string{models.MSG, "hi"}, nextFrame(t, server).ChatArgs())
+
+	n.SubscribeChannels("Vasya", models.AllChannels)
+	assert.Equal(t, models.ClientSetUsermaskType, nextFrame(t, server).Type)
+
+	close(stopReading)
+	<-readersDone
+
+	n.Stop()
+
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Connect did not return after Stop")
+	}
+
+	assert.IsType(t, UserLeftEvent{}, nextEvent(t, events))
+	_, ok = nextEvent(t, events).(DisconnectEvent)
+	assert.True(t, ok)
+	assert.Empty(t, n.Users())
+
+	// Stop of stopped bot does nothing
+	n.Stop()
+
+	server.Close()
+	waitGoroutines(t, baseline)
+}
+
+func TestNinJamBot_ConnectWithPassword(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+
+	server.AddUser("bot", "secret")
+	server.Anonymous(false)
+
+	n := NewNinJamBot(server.Host(), server.Port(), "bot", "secret", false)
+	events := n.Subscribe()
+
+	result := runBot(n)
+
+	assert.Equal(t, AuthEvent{Success: true, Message: "bot", MaxChannels: ninjamtest.DefaultMaxChannels}, nextEvent(t, events))
+
+	n.Stop()
+	assert.NoError(t, <-result)
+
+	n = NewNinJamBot(server.Host(), server.Port(), "bot", "wrong", false)
+	n.ReconnectPolicy(ReconnectPolicy{InitialDelay: time.Hour})
+	events = n.Subscribe()
+
+	result = runBot(n)
+
+	assert.Equal(t, AuthEvent{Success: false, Message: "invalid login/password"}, nextEvent(t, events))
+
+	n.Stop()
+	assert.NoError(t, <-result)
+}
+
+func TestNinJamBot_ConnectTwice(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+
+	n := NewNinJamBot(server.Host(), server.Port(), "bot", "", true)
+	events := n.Subscribe()
+
+	result := runBot(n)
+	nextEvent(t, events)
+
+	assert.Equal(t, ErrAlreadyConnected, n.Connect(context.Background()))
+
+	n.Stop()
+	assert.NoError(t, <-result)
+}
+
+func TestNinJamBot_Reconnect(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	server := ninjamtest.NewServer()
+	server.OnLogin(ninjamtest.UserJoined("Vasya", 0, "guitar"))
+
+	n := NewNinJamBot(server.Host(), server.Port(), "bot", "", true)
+	n.ReconnectPolicy(ReconnectPolicy{InitialDelay: time.Millisecond * 10})
+	events := n.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- n.Connect(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		assert.IsType(t, AuthEvent{}, nextEvent(t, events))
+		assert.IsType(t, UserJoinedEvent{}, nextEvent(t, events))
+
+		_, err := server.WaitLogin(time.Second * 5)
+		require.NoError(t, err)
+		server.DropClients()
+
+		// пользователи отключённого сервера уходят, после входа сервер присылает их заново
+		left, ok := nextEvent(t, events).(UserLeftEvent)
+		if assert.True(t, ok) {
+			assert.Equal(t, "Vasya", left.User.Name)
+		}
+		assert.IsType(t, DisconnectEvent{}, nextEvent(t, events))
+	}
+
+	// отмена контекста завершает Connect так же, как Stop
+	cancel()
+	assert.NoError(t, <-result)
+
+	server.Close()
+	waitGoroutines(t, baseline)
+}
+
+func TestNinJamBot_StopWhileUnreachable(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	n := NewNinJamBot("127.0.0.1", unreachablePort(), "bot", "", true)
+	n.ReconnectPolicy(ReconnectPolicy{InitialDelay: time.Hour})
+
+	result := runBot(n)
+
+	// даём боту получить ошибку соединения и уйти в ожидание реконнекта
+	time.Sleep(time.Millisecond * 100)
+
+	n.Stop()
+	assert.NoError(t, <-result)
+
+	waitGoroutines(t, baseline)
+}
+
+func TestNinJamBot_SendOffline(t *testing.T) {
+	n := New("127.0.0.1", "2049", WithBufferSize(1))
+
+	// до авторизации сервер отключает клиента за любое сообщение, кроме входа
+	n.ChannelInit("jukebox")
+	n.SubscribeChannels("Vasya", models.AllChannels)
+	n.IntervalBegin([16]byte{1}, 0)
+	n.IntervalWrite([16]byte{1}, []byte("ogg data"), 1)
+	assert.Empty(t, n.toServerChan)
+	assert.Equal(t, map[string]uint32{"Vasya": models.AllChannels}, n.Subscriptions())
+
+	// очередь полна, а соединение закрыто - отправка не блокируется
+	connDone := make(chan struct{})
+	close(connDone)
+	n.setState(StateOnline)
+	n.connDone = connDone
+	n.toServerChan <- []byte{models.ClientKeepaliveType, 0, 0, 0, 0}
+
+	sent := make(chan bool)
+	go func() {
+		sent <- n.sendFrame([]byte{models.ClientKeepaliveType, 0, 0, 0, 0})
+	}()
+	select {
+	case ok := <-sent:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("sendFrame blocked on closed connection")
+	}
+
+	// подписки не ждут места в очереди, изменённые маски уходят одним сообщением
+	n.SubscribeChannels("Petya", 0x01)
+	frame, err := n.usermaskFrame()
+	require.NoError(t, err)
+	usermask := &models.ClientSetUsermask{}
+	require.NoError(t, usermask.Unmarshal(frame[5:]))
+	assert.Equal(t, []models.UserMask{{Name: "Petya", ChannelMask: 0x01}, {Name: "Vasya", ChannelMask: models.AllChannels}}, usermask.UserMasks)
+
+	// кадры прошлого соединения новому не отправляются
+	n.dropFrames()
+	assert.Empty(t, n.toServerChan)
+}
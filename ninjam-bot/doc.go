@@ -0,0 +1,40 @@
+// Package ninjam_bot is a NINJAM client library: it logs in to NINJAM server, keeps the connection
+// reconnecting after failures, reports server's events and sends chat messages, channel info and intervals.
+// It depends on protocol types of models package and on capture for recording traffic, it knows nothing
+// about chats: router.NinJam bridges it to Telegram and Slack.
+//
+// Create client with New and options, subscribe to events before Connect and read them:
+//
+//	bot := ninjam_bot.New("ninbot.com", "2049",
+//		ninjam_bot.WithUser("recorder", "secret"),
+//		ninjam_bot.WithLogger(logger),
+//		ninjam_bot.WithReconnectPolicy(ninjam_bot.ReconnectPolicy{MaxAttempts: 10}),
+//	)
+//	events := bot.Subscribe()
+//	go bot.Connect(ctx)
+//
+//	for event := range events {
+//		switch event := event.(type) {
+//		case ninjam_bot.AuthEvent:
+//			if event.Success {
+//				bot.SendChat(ctx, models.MSG, "recording started")
+//			}
+//		case ninjam_bot.IntervalWriteEvent:
+//			// event.Interval.AudioData is a chunk of OGG Vorbis
+//		}
+//	}
+//
+// Events come in the order server sent them, every subscriber must read its channel or Unsubscribe,
+// otherwise processing of server messages stops when the channel is full (see WithBufferSize).
+//
+// SendChat waits until message is written to connection and returns error when the bot is offline,
+// SendMessage, SetTopic and other methods queue messages and never fail.
+//
+// Bots playing to their channels upload every interval with UploadInterval at ticks of IntervalTicker:
+//
+//	ticker.Start(bpm, bpi)
+//	for range ticker.C() {
+//		bot.UploadInterval(channelIndex, oggData)
+//		ticker.Next()
+//	}
+package ninjam_bot
@@ -0,0 +1,102 @@
+package ninjam_bot
+
+import (
+	"github.com/ayvan/ninjam-chatbot/models"
+)
+
+// Event is one of the events below, subscribers get them from Subscribe() in the order they came from server
+type Event interface {
+	event()
+}
+
+// AuthEvent is sent when server replies to bot's authorization
+type AuthEvent struct {
+	Success     bool
+	Message     string // error text or, on success, user name assigned by server
+	MaxChannels uint8
+}
+
+// ConfigChangeEvent is sent when server changes BPM/BPI, server sends it after every login too
+type ConfigChangeEvent struct {
+	BPM uint
+	BPI uint
+}
+
+// UserJoinedEvent is sent when user appears in server's user list
+type UserJoinedEvent struct {
+	User models.User
+}
+
+// UserLeftEvent is sent when user disappears from server's user list, User is the last known state
+type UserLeftEvent struct {
+	User models.User
+}
+
+// UserChannelsChangedEvent is sent when connected user adds, removes or changes channels
+type UserChannelsChangedEvent struct {
+	User models.User
+}
+
+// ChatEvent is MSG, JOIN or PART chat command
+type ChatEvent struct {
+	Message models.Message
+}
+
+// TopicEvent is sent when server informs about topic on join or when somebody changes it
+type TopicEvent struct {
+	Topic models.Topic
+}
+
+// PrivateMessageEvent is PRIVMSG sent to the bot
+type PrivateMessageEvent struct {
+	Message models.PrivateMessage
+}
+
+// UserCountEvent is USERCOUNT chat command
+type UserCountEvent struct {
+	UserCount models.UserCount
+}
+
+// SessionEvent is SESSION chat command
+type SessionEvent struct {
+	Session models.Session
+}
+
+// IntervalBeginEvent is the start of another user's interval, bot gets it only for subscribed channels
+type IntervalBeginEvent struct {
+	Interval *models.ServerDownloadIntervalBegin
+}
+
+// IntervalWriteEvent is a chunk of another user's interval audio
+type IntervalWriteEvent struct {
+	Interval *models.ServerDownloadIntervalWrite
+}
+
+// LicenseEvent is sent when server requires accepting license agreement the bot hasn't seen before
+// or the agreement has changed, see NinJamBot.LicenseState to remember it between restarts
+type LicenseEvent struct {
+	Text     string
+	Hash     string // SHA-256 of Text in hex, see LicenseHash
+	Accepted bool   // whether the bot accepted it by LicensePolicy
+}
+
+// DisconnectEvent is sent when connection to server is lost or closed,
+// UserLeftEvent is sent before it for every user who was on server
+type DisconnectEvent struct {
+	Err error
+}
+
+func (AuthEvent) event()                {}
+func (ConfigChangeEvent) event()        {}
+func (UserJoinedEvent) event()          {}
+func (UserLeftEvent) event()            {}
+func (UserChannelsChangedEvent) event() {}
+func (ChatEvent) event()                {}
+func (TopicEvent) event()               {}
+func (PrivateMessageEvent) event()      {}
+func (UserCountEvent) event()           {}
+func (SessionEvent) event()             {}
+func (IntervalBeginEvent) event()       {}
+func (IntervalWriteEvent) event()       {}
+func (LicenseEvent) event()             {}
+func (DisconnectEvent) event()          {}
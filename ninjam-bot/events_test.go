@@ -0,0 +1,99 @@
+package ninjam_bot
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func userInfoMessage(userInfos ...models.UserInfo) *models.NetMessage {
+	nm := models.NewNetMessage(models.ServerUserInfoChangeNotifyType)
+	nm.InPayload = &models.ServerUserInfoChangeNotify{UserInfos: userInfos}
+
+	return nm
+}
+
+func TestNinJamBot_SubscribeUserEvents(t *testing.T) {
+	n := NewNinJamBot("localhost", "2049", "bot", "", true)
+
+	first := n.Subscribe()
+	second := n.Subscribe()
+
+	n.handle(context.Background(), userInfoMessage(
+		userInfo(true, "Vasya", 0, "guitar"),
+		userInfo(true, "Vasya", 1, "vocals"),
+		userInfo(true, "Petya", 0, "bass"),
+	))
+	n.handle(context.Background(), userInfoMessage(userInfo(false, "Vasya", 1, "")))
+	n.handle(context.Background(), userInfoMessage(userInfo(false, "Petya", 0, "")))
+
+	for _, events := range []<-chan Event{first, second} {
+		if assert.Len(t, events, 4) {
+			assert.Equal(t, "Vasya (guitar, vocals)", (<-events).(UserJoinedEvent).User.String())
+			assert.Equal(t, "Petya (bass)", (<-events).(UserJoinedEvent).User.String())
+			assert.Equal(t, "Vasya (guitar)", (<-events).(UserChannelsChangedEvent).User.String())
+			assert.Equal(t, "Petya (bass)", (<-events).(UserLeftEvent).User.String())
+		}
+	}
+
+	n.Unsubscribe(first)
+	_, ok := <-first
+	assert.False(t, ok)
+
+	n.handle(context.Background(), userInfoMessage(userInfo(true, "Sidor", 0, "drums")))
+	assert.Len(t, second, 1)
+}
+
+func TestNinJamBot_UnsubscribeFull(t *testing.T) {
+	n := New("localhost", "2049", WithBufferSize(1))
+	slow := n.Subscribe()
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		n.publish(context.Background(), UserCountEvent{})
+		// канал полон, publish ждёт подписчика
+		n.publish(context.Background(), UserCountEvent{})
+	}()
+
+	// пока publish ждёт, подписка и отписка других подписчиков не блокируются
+	time.Sleep(time.Millisecond * 50)
+	other := n.Subscribe()
+	n.Unsubscribe(other)
+
+	n.Unsubscribe(slow)
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish is blocked by unsubscribed subscriber")
+	}
+
+	_, ok := <-slow
+	assert.True(t, ok)
+	_, ok = <-slow
+	assert.False(t, ok)
+}
+
+func TestNinJamBot_SubscribeChatEvents(t *testing.T) {
+	n := NewNinJamBot("localhost", "2049", "bot", "", true)
+	events := n.Subscribe()
+
+	for _, payload := range []string{
+		"TOPIC\x00Vasya\x00Blues in A\x00\x00\x00",
+		"MSG\x00Vasya\x00hello\x00\x00\x00",
+		"PRIVMSG\x00Petya\x00@vasya hi\x00\x00\x00",
+	} {
+		nm := models.NewInNetMessage([5]byte{models.ChatMessageType})
+		assert.NoError(t, nm.Unmarshal([]byte(payload)))
+		n.handle(context.Background(), nm)
+	}
+
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, TopicEvent{Topic: models.Topic{By: "Vasya", Text: "Blues in A"}}, <-events)
+		assert.Equal(t, ChatEvent{Message: models.Message{Type: models.MSG, Name: "Vasya", Text: "hello"}}, <-events)
+		assert.Equal(t, PrivateMessageEvent{Message: models.PrivateMessage{From: "Petya", Text: "@vasya hi"}}, <-events)
+	}
+	assert.Equal(t, "Blues in A", n.Topic())
+}
@@ -0,0 +1,102 @@
+package ninjam_bot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// LicenseMode tells what the bot answers to server which requires accepting license agreement
+type LicenseMode int
+
+const (
+	// LicenseAccept accepts any agreement
+	LicenseAccept LicenseMode = iota
+	// LicenseReject never accepts, server refuses login
+	LicenseReject
+	// LicenseAcceptHash accepts only the agreement with LicensePolicy.Hash
+	LicenseAcceptHash
+)
+
+func (m LicenseMode) String() string {
+	switch m {
+	case LicenseAccept:
+		return "accept"
+	case LicenseReject:
+		return "reject"
+	case LicenseAcceptHash:
+		return "hash"
+	}
+
+	return fmt.Sprintf("LicenseMode(%d)", int(m))
+}
+
+// ParseLicenseMode parses mode from config: "accept" (or empty), "reject" or "hash"
+func ParseLicenseMode(s string) (LicenseMode, error) {
+	switch strings.ToLower(s) {
+	case "", "accept":
+		return LicenseAccept, nil
+	case "reject":
+		return LicenseReject, nil
+	case "hash":
+		return LicenseAcceptHash, nil
+	}
+
+	return LicenseAccept, fmt.Errorf("unknown license policy %q, expected accept, reject or hash", s)
+}
+
+// LicensePolicy decides whether the bot accepts server's license agreement, zero value accepts everything
+type LicensePolicy struct {
+	Mode LicenseMode
+	Hash string // SHA-256 of the agreement text in hex, see LicenseHash
+}
+
+// Accepts reports whether the agreement with hash may be accepted
+func (p LicensePolicy) Accepts(hash string) bool {
+	switch p.Mode {
+	case LicenseAccept:
+		return true
+	case LicenseAcceptHash:
+		return strings.EqualFold(p.Hash, hash)
+	}
+
+	return false
+}
+
+// LicenseHash returns SHA-256 of the agreement text in hex
+func LicenseHash(text []byte) string {
+	sum := sha256.Sum256(text)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// loadLicenseState returns hash saved by saveLicenseState, empty when there is no state file
+func (n *NinJamBot) loadLicenseState() string {
+	if n.licenseState == "" {
+		return ""
+	}
+
+	data, err := ioutil.ReadFile(n.licenseState)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			n.log.Errorf("Can't read license state %s: %s", n.licenseState, err)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
+
+// saveLicenseState keeps hash of reported agreement between restarts
+func (n *NinJamBot) saveLicenseState(hash string) {
+	if n.licenseState == "" {
+		return
+	}
+
+	if err := ioutil.WriteFile(n.licenseState, []byte(hash+"\n"), 0644); err != nil {
+		n.log.Errorf("Can't save license state %s: %s", n.licenseState, err)
+	}
+}
@@ -0,0 +1,110 @@
+package ninjam_bot
+
+import (
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLicensePolicy(t *testing.T) {
+	hash := LicenseHash([]byte("be nice"))
+	assert.Len(t, hash, 64)
+
+	assert.True(t, LicensePolicy{}.Accepts(hash))
+	assert.False(t, LicensePolicy{Mode: LicenseReject}.Accepts(hash))
+	assert.True(t, LicensePolicy{Mode: LicenseAcceptHash, Hash: hash}.Accepts(hash))
+	assert.False(t, LicensePolicy{Mode: LicenseAcceptHash, Hash: LicenseHash([]byte("be rude"))}.Accepts(hash))
+	// хеш без заданного значения не подходит ни к какому соглашению
+	assert.False(t, LicensePolicy{Mode: LicenseAcceptHash}.Accepts(hash))
+
+	for s, mode := range map[string]LicenseMode{"": LicenseAccept, "accept": LicenseAccept, "Reject": LicenseReject, "hash": LicenseAcceptHash} {
+		parsed, err := ParseLicenseMode(s)
+		assert.NoError(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+	_, err := ParseLicenseMode("maybe")
+	assert.Error(t, err)
+}
+
+func TestNinJamBot_License(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.License("be nice")
+
+	hash := LicenseHash([]byte("be nice"))
+
+	tests := []struct {
+		name    string
+		policy  LicensePolicy
+		event   bool
+		success bool
+	}{
+		{name: "accept", policy: LicensePolicy{}, event: true, success: true},
+		{name: "reject", policy: LicensePolicy{Mode: LicenseReject}, event: true, success: false},
+		{name: "known hash", policy: LicensePolicy{Mode: LicenseAcceptHash, Hash: hash}, event: false, success: true},
+		{name: "changed hash", policy: LicensePolicy{Mode: LicenseAcceptHash, Hash: LicenseHash([]byte("old"))}, event: true, success: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := NewNinJamBot(server.Host(), server.Port(), "bot", "", true)
+			n.ReconnectPolicy(ReconnectPolicy{InitialDelay: time.Hour})
+			n.LicensePolicy(tt.policy)
+			events := n.Subscribe()
+
+			result := runBot(n)
+			defer func() {
+				n.Stop()
+				assert.NoError(t, <-result)
+			}()
+
+			if tt.event {
+				assert.Equal(t, LicenseEvent{Text: "be nice", Hash: hash, Accepted: tt.success}, nextEvent(t, events))
+			}
+
+			auth, ok := nextEvent(t, events).(AuthEvent)
+			if assert.True(t, ok) {
+				assert.Equal(t, tt.success, auth.Success)
+			}
+		})
+	}
+}
+
+func TestNinJamBot_LicenseState(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.License("be nice")
+
+	dir, err := ioutil.TempDir("", "license")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "license")
+	hash := LicenseHash([]byte("be nice"))
+
+	// после перезапуска о том же соглашении бот не сообщает
+	for _, event := range []bool{true, false} {
+		n := New(server.Host(), server.Port(), WithLicenseState(state), WithReconnectPolicy(ReconnectPolicy{InitialDelay: time.Hour}))
+		events := n.Subscribe()
+		result := runBot(n)
+
+		if event {
+			assert.Equal(t, LicenseEvent{Text: "be nice", Hash: hash, Accepted: true}, nextEvent(t, events))
+		}
+		auth, ok := nextEvent(t, events).(AuthEvent)
+		if assert.True(t, ok) {
+			assert.True(t, auth.Success)
+		}
+
+		n.Stop()
+		assert.NoError(t, <-result)
+	}
+
+	data, err := ioutil.ReadFile(state)
+	require.NoError(t, err)
+	assert.Equal(t, hash+"\n", string(data))
+}
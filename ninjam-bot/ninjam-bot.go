@@ -2,49 +2,143 @@ package ninjam_bot
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/capture"
 	"github.com/ayvan/ninjam-chatbot/models"
 	"github.com/luci/go-render/render"
 	"github.com/sirupsen/logrus"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+var (
+	// ErrAlreadyConnected is returned by Connect when the bot is already running
+	ErrAlreadyConnected = errors.New("ninjam bot is already connected")
+	// ErrNotConnected is returned by SendChat when the bot is not logged in to server
+	ErrNotConnected = errors.New("ninjam bot is not connected")
+	// ErrTooManyArguments is returned by SendChat for more than 4 chat command arguments
+	ErrTooManyArguments = errors.New("chat command has at most 4 arguments")
+)
+
+const (
+	// authTimeout - если за это время сервер не ответил на авторизацию, снова начинаем слать KeepAlive,
+	// при ошибке отправки переконнектимся
+	authTimeout = time.Second * 10
+	// defaultKeepAlive используется до того, как сервер сообщит свой интервал
+	defaultKeepAlive = time.Second * 10
+)
+
+// NinJamBot is a NINJAM client: it keeps connection to server, reports server's events to subscribers
+// and sends chat messages, channel info and intervals. See package documentation for usage
 type NinJamBot struct {
-	keepAliveTicker    *time.Ticker
-	toServerChan       chan []byte
-	inAuthNow          bool
-	sigChan            chan bool
-	users              map[string]string
 	anonymous          bool
 	userName           string
 	password           string
 	host               string
 	port               string
-	messagesFromNinJam chan models.Message
+	dialer             Dialer
+	log                logrus.FieldLogger
+	bufferSize         int
+	keepAliveOverride  time.Duration
+	clientVersion      uint32
+	clientCapabilities uint32
+	reconnectPolicy    ReconnectPolicy
+	licensePolicy      LicensePolicy
+	licenseHash        string // последнее увиденное соглашение, меняется только в горутине чтения
+	licenseState       string // файл с хешем последнего соглашения, о котором сообщил бот
+	capture            *capture.Writer
+	toServerChan       chan []byte
 	messagesToNinJam   chan string
 	adminMessages      chan string
-	channelInfo        *models.ClientSetChannelInfo
+	commandsToNinJam   chan *models.ChatMessage
+	chatRequests       chan chatRequest
+	masksChanged       chan struct{} // pendingMasks changed, the writer sends them
+
+	// stateMutex охраняет всё состояние ниже, его меняют горутины соединения и методы API
+	stateMutex   sync.Mutex
+	status       Status
+	inAuthNow    bool
+	authDeadline time.Time
+	keepAlive    time.Duration
+	users        map[string]*models.User
+	channelInfo  *models.ClientSetChannelInfo
+	usermasks    map[string]uint32
+	pendingMasks map[string]uint32   // изменённые маски, которые ещё не отправлены серверу
+	channelRefs  map[string]*[32]int // сколько раз подписались на каждый канал пользователя
+	topic        string
+	userCount    models.UserCount
+	cancel       context.CancelFunc
+	done         chan struct{}
+	connDone     <-chan struct{} // закрывается, когда текущее соединение завершено
+
+	subscribers      []*subscriber
+	subscribersMutex sync.Mutex
+}
+
+// subscriber - канал подписчика, done закрывается в Unsubscribe и прерывает ожидание отправки в events,
+// mutex не даёт закрыть events во время отправки
+type subscriber struct {
+	events chan Event
+	done   chan struct{}
+	mutex  sync.Mutex
+	closed bool
+}
+
+// chatRequest - сообщение SendChat, в result приходит результат записи в сокет
+type chatRequest struct {
+	frame  []byte
+	result chan error
+}
+
+// New creates client of server host:port, it doesn't connect until Connect is called.
+// Without WithUser or WithAnonymous option the bot logs in as anonymous user "bot"
+func New(host, port string, opts ...Option) *NinJamBot {
+	n := &NinJamBot{
+		anonymous: true,
+		userName:  "bot",
+		host:      host,
+		port:      port,
+		dialer: &net.Dialer{
+			KeepAlive: time.Hour * 24,
+			Timeout:   time.Second * 10,
+		},
+		log:             logrus.StandardLogger(),
+		bufferSize:      DefaultBufferSize,
+		clientVersion:   DefaultClientVersion,
+		reconnectPolicy: DefaultReconnectPolicy(),
+		keepAlive:       defaultKeepAlive,
+		users:           make(map[string]*models.User),
+		usermasks:       make(map[string]uint32),
+		channelRefs:     make(map[string]*[32]int),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
 
-	onSuccessAuth        func()
-	onServerConfigChange func(bpm, bpi uint)
-	onUserinfoChange     func(user models.UserInfo)
+	n.toServerChan = make(chan []byte, n.bufferSize)
+	n.messagesToNinJam = make(chan string, n.bufferSize)
+	n.adminMessages = make(chan string, n.bufferSize)
+	n.commandsToNinJam = make(chan *models.ChatMessage, n.bufferSize)
+	n.chatRequests = make(chan chatRequest)
+	n.masksChanged = make(chan struct{}, 1)
+
+	return n
 }
 
-func NewNinJamBot(host, port, userName, password string, anonymous bool) *NinJamBot {
-	return &NinJamBot{
-		keepAliveTicker:    time.NewTicker(time.Second * 10),
-		toServerChan:       make(chan []byte, 1000),
-		sigChan:            make(chan bool, 1),
-		users:              make(map[string]string),
-		anonymous:          anonymous,
-		userName:           userName,
-		password:           password,
-		host:               host,
-		port:               port,
-		messagesFromNinJam: make(chan models.Message, 1000),
-		messagesToNinJam:   make(chan string, 1000),
-		adminMessages:      make(chan string, 1000),
+// NewNinJamBot is a shortcut for New with WithUser or, if anonymous is set, WithAnonymous option
+func NewNinJamBot(host, port, userName, password string, anonymous bool, opts ...Option) *NinJamBot {
+	user := WithUser(userName, password)
+	if anonymous {
+		user = WithAnonymous(userName)
 	}
+
+	return New(host, port, append([]Option{user}, opts...)...)
 }
 
 func (n *NinJamBot) Host() string {
@@ -59,28 +153,260 @@ func (n *NinJamBot) UserName() string {
 	return n.userName
 }
 
-func (n *NinJamBot) Connect() {
-f:
+// ReconnectPolicy sets pauses between reconnects, zero delays are replaced by defaults.
+// It must be called before Connect
+func (n *NinJamBot) ReconnectPolicy(policy ReconnectPolicy) {
+	n.reconnectPolicy = policy.withDefaults()
+}
+
+// LicensePolicy sets what to answer to server which requires accepting license agreement,
+// the agreement with policy's Hash is considered already known and LicenseEvent is not sent for it.
+// It must be called before Connect
+func (n *NinJamBot) LicensePolicy(policy LicensePolicy) {
+	n.licensePolicy = policy
+	n.licenseHash = policy.Hash
+}
+
+// LicenseState sets file which keeps hash of the last agreement reported by LicenseEvent,
+// so the same agreement is not reported again after restart. Empty path keeps it only in memory.
+// It must be called before Connect
+func (n *NinJamBot) LicenseState(path string) {
+	n.licenseState = path
+}
+
+// Capture makes the bot write every frame received from and sent to server to w,
+// nil disables capturing. It must be called before Connect
+func (n *NinJamBot) Capture(w *capture.Writer) {
+	n.capture = w
+}
+
+func (n *NinJamBot) captureFrame(direction capture.Direction, msgType uint8, payload []byte) {
+	if n.capture == nil {
+		return
+	}
+
+	if err := n.capture.Write(direction, msgType, payload); err != nil {
+		n.log.Error("Capture write error:", err)
+	}
+}
+
+// Status returns current state of connection to server
+func (n *NinJamBot) Status() Status {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	return n.status
+}
+
+func (n *NinJamBot) setState(state ConnectionState) {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	n.status.State = state
+	n.status.RetryAt = time.Time{}
+	if state == StateOnline {
+		n.status.Attempt = 0
+		n.status.Err = nil
+	}
+}
+
+// Connect connects to server and keeps the connection, reconnecting after failures by ReconnectPolicy.
+// It blocks until ctx is done or Stop is called and returns error only when reconnect attempts are exceeded
+func (n *NinJamBot) Connect(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	n.stateMutex.Lock()
+	if n.cancel != nil {
+		n.stateMutex.Unlock()
+		cancel()
+		return ErrAlreadyConnected
+	}
+	n.cancel = cancel
+	n.done = done
+	n.stateMutex.Unlock()
+
+	defer func() {
+		cancel()
+
+		n.stateMutex.Lock()
+		n.cancel = nil
+		n.done = nil
+		n.status.State = StateOffline
+		n.status.RetryAt = time.Time{}
+		n.stateMutex.Unlock()
+
+		close(done)
+	}()
+
 	for {
-		select {
-		case s := <-n.sigChan:
-			n.sigChan <- s
-			break f
-		default:
+		n.setState(StateConnecting)
+
+		err := n.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n.log.Errorf("Ninjam connection %s:%s error: %v", n.host, n.port, err)
+
+		// попытки считаем подряд: успешная авторизация сбрасывает счётчик
+		n.stateMutex.Lock()
+		n.status.Attempt++
+		n.status.Err = err
+		attempt := n.status.Attempt
+		n.stateMutex.Unlock()
+
+		if n.reconnectPolicy.MaxAttempts > 0 && attempt >= n.reconnectPolicy.MaxAttempts {
+			return fmt.Errorf("%w: %d attempts to %s:%s failed, last error: %v", ErrReconnectAttemptsExceeded, attempt, n.host, n.port, err)
+		}
+
+		delay := n.reconnectPolicy.Delay(attempt)
 
-			n.connect()
-			// если коннект прервался - запустим таймаут перед реконнектом
-			time.Sleep(time.Second * 5)
+		n.stateMutex.Lock()
+		n.status.State = StateBackingOff
+		n.status.RetryAt = time.Now().Add(delay)
+		n.stateMutex.Unlock()
+
+		n.log.Infof("Retry connecting to %s:%s after %s...", n.host, n.port, delay)
+
+		// если коннект прервался - запустим таймаут перед реконнектом
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
 		}
 	}
 }
 
+// Stop closes connection and blocks until all connection's goroutines are finished
 func (n *NinJamBot) Stop() {
-	n.sigChan <- true
+	n.stateMutex.Lock()
+	cancel, done := n.cancel, n.done
+	n.stateMutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+}
+
+// Subscribe returns channel with all bot's events in the order they came from server.
+// Every subscriber gets its own copy of events, subscriber must read them or Unsubscribe,
+// otherwise server messages processing will be blocked when the channel buffer is full.
+// Subscriber may Unsubscribe at any moment, even when the buffer is full
+func (n *NinJamBot) Subscribe() <-chan Event {
+	s := &subscriber{
+		events: make(chan Event, n.bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	n.subscribersMutex.Lock()
+	n.subscribers = append(n.subscribers, s)
+	n.subscribersMutex.Unlock()
+
+	return s.events
+}
+
+// Unsubscribe stops events delivery and closes the channel
+func (n *NinJamBot) Unsubscribe(events <-chan Event) {
+	n.subscribersMutex.Lock()
+	var s *subscriber
+	for i, subscriber := range n.subscribers {
+		if subscriber.events == events {
+			s = subscriber
+			n.subscribers = append(n.subscribers[:i], n.subscribers[i+1:]...)
+			break
+		}
+	}
+	n.subscribersMutex.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	// прерываем отправку, если publish ждёт места в канале, и закрываем канал, когда она закончилась
+	close(s.done)
+	s.mutex.Lock()
+	s.closed = true
+	close(s.events)
+	s.mutex.Unlock()
+}
+
+// publish sends event to all subscribers, it blocks on full subscriber's channel until ctx is done
+// or the subscriber unsubscribes. Subscribers are copied, so Subscribe and Unsubscribe never wait for it
+func (n *NinJamBot) publish(ctx context.Context, event Event) {
+	n.subscribersMutex.Lock()
+	subscribers := append([]*subscriber(nil), n.subscribers...)
+	n.subscribersMutex.Unlock()
+
+	for _, s := range subscribers {
+		s.send(ctx, event, n.log)
+	}
 }
 
-func (n *NinJamBot) IncomingMessages() <-chan models.Message {
-	return n.messagesFromNinJam
+func (s *subscriber) send(ctx context.Context, event Event, log logrus.FieldLogger) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.events <- event:
+		return
+	default:
+	}
+
+	select {
+	case s.events <- event:
+	case <-s.done:
+	case <-ctx.Done():
+		log.Warnf("Event %T dropped, subscriber is not reading events", event)
+	}
+}
+
+// SendChat sends chat command with arguments, e.g. SendChat(ctx, models.MSG, "hello"),
+// and waits until it's written to connection. Unlike SendMessage it returns ErrNotConnected
+// when the bot is not logged in instead of queueing the message till the next connection
+func (n *NinJamBot) SendChat(ctx context.Context, command string, args ...string) error {
+	if len(args) > 4 {
+		return ErrTooManyArguments
+	}
+
+	cm := &models.ChatMessage{Command: []byte(command)}
+	fields := []*[]byte{&cm.Arg1, &cm.Arg2, &cm.Arg3, &cm.Arg4}
+	for i, arg := range args {
+		*fields[i] = []byte(arg)
+	}
+
+	frame, err := chatFrame(cm)
+	if err != nil {
+		return err
+	}
+
+	if n.Status().State != StateOnline {
+		return ErrNotConnected
+	}
+
+	request := chatRequest{frame: frame, result: make(chan error, 1)}
+
+	select {
+	case n.chatRequests <- request:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-request.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (n *NinJamBot) SendMessage(message string) {
@@ -95,104 +421,365 @@ func (n *NinJamBot) SendAdminMessage(message string) {
 	}()
 }
 
-func (n NinJamBot) Users() []string {
-	users := []string{}
-	for userName := range n.users {
-		users = append(users, userName)
+// SendPrivateMessage sends PRIVMSG to NINJAM user
+func (n *NinJamBot) SendPrivateMessage(userName, message string) {
+	n.sendChatCommand(&models.ChatMessage{
+		Command: []byte(models.PRIVMSG),
+		Arg1:    []byte(userName),
+		Arg2:    []byte(message),
+	})
+}
+
+// SetTopic changes server topic, server allows it only for users with topic permission
+func (n *NinJamBot) SetTopic(topic string) {
+	n.sendChatCommand(&models.ChatMessage{
+		Command: []byte(models.TOPIC),
+		Arg1:    []byte(topic),
+	})
+}
+
+func (n *NinJamBot) sendChatCommand(cm *models.ChatMessage) {
+	go func() {
+		n.commandsToNinJam <- cm
+	}()
+}
+
+// Topic returns current server topic
+func (n *NinJamBot) Topic() string {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	return n.topic
+}
+
+// UserCount returns last users count reported by server
+func (n *NinJamBot) UserCount() models.UserCount {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	return n.userCount
+}
+
+// Users returns users connected to server with their channels, sorted by name
+func (n *NinJamBot) Users() []models.User {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	users := make([]models.User, 0, len(n.users))
+	for _, user := range n.users {
+		u := *user
+		u.Channels = append([]models.Channel(nil), user.Channels...)
+		users = append(users, u)
 	}
 
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
 	return users
 }
 
-func (n *NinJamBot) connect() {
-	defer func() {
-		logrus.Info("connect finished")
-	}()
+// FindUser looks up connected user by full NINJAM name ("Vasya@127.0.0.x") or by name without address ("Vasya")
+func (n *NinJamBot) FindUser(name string) (models.User, bool) {
+	for _, user := range n.Users() {
+		if strings.EqualFold(user.Name, name) {
+			return user, true
+		}
+	}
+
+	for _, user := range n.Users() {
+		if i := strings.Index(user.Name, "@"); i != -1 && strings.EqualFold(user.Name[:i], name) {
+			return user, true
+		}
+	}
 
-	conn, err := dialNinjamServer(n.host, n.port)
+	return models.User{}, false
+}
 
-	for err != nil {
-		select {
-		case s := <-n.sigChan:
-			n.sigChan <- s
+// applyUserInfos updates users list and returns events about users which were changed
+func (n *NinJamBot) applyUserInfos(userInfos []models.UserInfo) []Event {
+	names := make([]string, 0)
+	before := make(map[string]models.User)
+
+	for _, userInfo := range userInfos {
+		name := string(userInfo.Name)
+		if _, ok := before[name]; ok {
+			continue
+		}
+		names = append(names, name)
+		before[name], _ = n.user(name)
+	}
+
+	for _, userInfo := range userInfos {
+		n.updateUser(userInfo)
+	}
+
+	events := make([]Event, 0, len(names))
+	for _, name := range names {
+		old := before[name]
+		user, ok := n.user(name)
+
+		switch {
+		case old.Name == "" && ok:
+			events = append(events, UserJoinedEvent{User: user})
+		case old.Name != "" && !ok:
+			events = append(events, UserLeftEvent{User: old})
+		case ok:
+			events = append(events, UserChannelsChangedEvent{User: user})
+		}
+	}
+
+	return events
+}
+
+// user returns copy of connected user by exact name
+func (n *NinJamBot) user(name string) (models.User, bool) {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	user, ok := n.users[name]
+	if !ok {
+		return models.User{}, false
+	}
+
+	u := *user
+	u.Channels = append([]models.Channel(nil), user.Channels...)
+
+	return u, true
+}
+
+// updateUser applies one user's channel record from ServerUserInfoChangeNotify
+func (n *NinJamBot) updateUser(userInfo models.UserInfo) {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	name := string(userInfo.Name)
+
+	user, ok := n.users[name]
+	if !ok {
+		if !userInfo.IsActive() {
 			return
-		default:
-			logrus.Error("Ninjam connection error", err)
-			logrus.Info("Retry connecting after 5 seconds...")
+		}
+		user = &models.User{Name: name}
+		n.users[name] = user
+	}
 
-			// ошибка коннекта, пробуем снова через таймаут 5 секунд
-			time.Sleep(time.Second * 10)
-			conn, err = dialNinjamServer(n.host, n.port)
+	channels := make([]models.Channel, 0, len(user.Channels)+1)
+	for _, channel := range user.Channels {
+		if channel.Index != userInfo.ChannelIndex {
+			channels = append(channels, channel)
 		}
 	}
 
-	returnChan := make(chan bool, 10)
+	if userInfo.IsActive() {
+		channels = append(channels, models.Channel{
+			Index:  userInfo.ChannelIndex,
+			Name:   string(userInfo.ChannelName),
+			Volume: userInfo.Volume,
+			Pan:    userInfo.Pan,
+			Flags:  userInfo.Flags,
+		})
+		sort.Slice(channels, func(i, j int) bool {
+			return channels[i].Index < channels[j].Index
+		})
+	}
+
+	if len(channels) == 0 {
+		delete(n.users, name)
+		return
+	}
+
+	user.Channels = channels
+}
 
-	defer conn.Close()
+// connect serves one connection to server, it returns when connection is broken or ctx is done,
+// all connection's goroutines are finished by then
+func (n *NinJamBot) connect(ctx context.Context) error {
 	defer func() {
-		returnChan <- true
+		n.log.Info("connect finished")
 	}()
 
-	toServerErrorChan := make(chan bool, 1)
+	conn, err := n.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	n.resetSession()
+	n.dropFrames()
+	n.setState(StateAuthenticating)
+
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n.stateMutex.Lock()
+	n.connDone = connCtx.Done()
+	n.stateMutex.Unlock()
 
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+
+	// чтение блокирующее, поэтому при завершении соединения просто закрываем коннект
 	go func() {
-		for {
-			select {
-			case <-n.keepAliveTicker.C:
-				logrus.Debug("keepAliveTicker tick...")
-				// пока авторизуемся - тикер вырубаем
-				if n.inAuthNow {
-					logrus.Debug("keepAliveTicker inAuthNow...")
-					continue
-				}
-				n.toServerChan <- []byte{models.ClientKeepaliveType, 0, 0, 0, 0}
-			case <-toServerErrorChan:
-				returnChan <- true
-				return
-			case <-returnChan:
-				returnChan <- true
-				return
-			case s := <-n.sigChan:
-				returnChan <- true
-				n.sigChan <- s
-				return
-			}
-		}
+		defer wg.Done()
+		<-connCtx.Done()
+		conn.Close()
 	}()
 
-	go n.sendToServer(conn, toServerErrorChan, returnChan)
+	go func() {
+		defer wg.Done()
+		defer cancel()
+		n.keepAliveLoop(connCtx)
+	}()
 
-	// запускаем обработку сообщений, отправляемых в Ninjam чат
 	go func() {
-		for {
+		defer wg.Done()
+		defer cancel()
+		n.sendToServer(connCtx, conn)
+	}()
+
+	// блокирующая функция, если она вылетела - значит ошибка чтения коннекта, пробуем реконнект
+	err = n.read(connCtx, conn)
+
+	cancel()
+	wg.Wait()
+
+	for _, event := range n.dropUsers() {
+		n.publish(ctx, event)
+	}
+	n.publish(ctx, DisconnectEvent{Err: err})
+
+	return err
+}
+
+// resetSession clears state of previous connection, server sends it again after login
+func (n *NinJamBot) resetSession() {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	n.inAuthNow = false
+	n.keepAlive = defaultKeepAlive
+	n.users = make(map[string]*models.User)
+	n.channelInfo = nil
+	n.pendingMasks = nil
+	n.topic = ""
+	n.userCount = models.UserCount{}
+}
+
+// dropUsers forgets users of closed connection and returns UserLeftEvent for every one of them,
+// so subscribers cancel their subscriptions: after login server sends users who are still there
+func (n *NinJamBot) dropUsers() []Event {
+	n.stateMutex.Lock()
+	users := n.users
+	n.users = make(map[string]*models.User)
+	n.stateMutex.Unlock()
+
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	events := make([]Event, 0, len(names))
+	for _, name := range names {
+		user := *users[name]
+		user.Channels = append([]models.Channel(nil), user.Channels...)
+		events = append(events, UserLeftEvent{User: user})
+	}
+
+	return events
+}
+
+// dropFrames drops frames queued for previous connection: server disconnects client which sends
+// anything but ClientAuthUser first
+func (n *NinJamBot) dropFrames() {
+	for {
+		select {
+		case <-n.toServerChan:
+		default:
+			return
+		}
+	}
+}
+
+// sendFrame queues frame for the current connection, it returns false and drops the frame when
+// the bot is not logged in or the connection is closed while the queue is full
+func (n *NinJamBot) sendFrame(frame []byte) bool {
+	n.stateMutex.Lock()
+	online := n.status.State == StateOnline && !(n.inAuthNow && time.Now().Before(n.authDeadline))
+	connDone := n.connDone
+	n.stateMutex.Unlock()
+
+	if !online {
+		n.log.Debugf("Bot is not logged in, frame %d dropped", frame[0])
+		return false
+	}
+
+	select {
+	case n.toServerChan <- frame:
+		return true
+	case <-connDone:
+		return false
+	}
+}
+
+// keepAliveLoop sends KeepAlive with interval requested by server
+func (n *NinJamBot) keepAliveLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	lastSent := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// пока авторизуемся - KeepAlive не шлём
+			if n.isInAuth() || now.Sub(lastSent) < n.keepAliveInterval() {
+				continue
+			}
+			lastSent = now
+
+			n.log.Debug("keepAlive tick...")
+
 			select {
-			case message := <-n.messagesToNinJam:
-				n.sendChatMessage(message, models.MSG)
-			case message := <-n.adminMessages:
-				n.sendChatMessage(message, models.ADMIN)
-			case <-returnChan:
-				returnChan <- true
-				return
-			case s := <-n.sigChan:
-				n.sigChan <- s
-				// получена команда выйти из горутины
+			case n.toServerChan <- []byte{models.ClientKeepaliveType, 0, 0, 0, 0}:
+			case <-ctx.Done():
 				return
 			}
 		}
-	}()
+	}
+}
 
-	// блокирующая функция, если она вылетела - значит ошибка чтения коннекта, пробуем реконнект
-	n.read(conn, returnChan)
+func (n *NinJamBot) keepAliveInterval() time.Duration {
+	if n.keepAliveOverride > 0 {
+		return n.keepAliveOverride
+	}
+
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	return n.keepAlive
 }
 
-func (n *NinJamBot) login(serverAuthChallenge *models.ServerAuthChallenge) (data []byte, err error) {
+func (n *NinJamBot) isInAuth() bool {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
+	return n.inAuthNow && time.Now().Before(n.authDeadline)
+}
+
+func (n *NinJamBot) login(serverAuthChallenge *models.ServerAuthChallenge, acceptAgreement bool) (data []byte, err error) {
 	var userName string
 	if n.anonymous {
 		userName = "anonymous:" + n.userName
 	} else {
 		userName = n.userName
 	}
-	authMessage := models.NewClientAuthUser(userName, n.password, serverAuthChallenge.HasAgreement(), serverAuthChallenge.Challenge)
+	authMessage := models.NewClientAuthUser(userName, n.password, acceptAgreement, serverAuthChallenge.Challenge)
+	authMessage.ClientVersion = n.clientVersion
+	// бит 0 - принятие лицензионного соглашения, его определяет только LicensePolicy
+	authMessage.ClientCapabilities |= n.clientCapabilities &^ 1
 
 	nm := models.NewNetMessage(models.ClientAuthUserType)
 
@@ -201,64 +788,70 @@ func (n *NinJamBot) login(serverAuthChallenge *models.ServerAuthChallenge) (data
 	return nm.Marshal()
 }
 
-func dialNinjamServer(host, port string) (conn net.Conn, err error) {
-	address := host + ":" + port
+// checkLicense decides by policy whether to accept server's license agreement
+// and publishes LicenseEvent when the agreement is new or changed
+func (n *NinJamBot) checkLicense(ctx context.Context, serverAuthChallenge *models.ServerAuthChallenge) bool {
+	if !serverAuthChallenge.HasAgreement() {
+		return false
+	}
+
+	text := serverAuthChallenge.LicenseAgreement
+	hash := LicenseHash(text)
+	accepted := n.licensePolicy.Accepts(hash)
 
-	logrus.Info("Connecting to Ninjam... ", address)
+	if accepted {
+		n.log.Infof("License agreement %s of %s:%s accepted", hash, n.host, n.port)
+	} else {
+		n.log.Warnf("License agreement %s of %s:%s rejected by policy %s", hash, n.host, n.port, n.licensePolicy.Mode)
+	}
 
-	dialer := &net.Dialer{
-		KeepAlive: time.Hour * 24,
-		Timeout:   time.Second * 10,
+	if hash != n.licenseHash && hash != n.loadLicenseState() {
+		n.publish(ctx, LicenseEvent{Text: string(text), Hash: hash, Accepted: accepted})
+		n.saveLicenseState(hash)
 	}
+	n.licenseHash = hash
+
+	return accepted
+}
 
-	conn, err = dialer.Dial("tcp", address)
+func (n *NinJamBot) dial(ctx context.Context) (conn net.Conn, err error) {
+	address := net.JoinHostPort(n.host, n.port)
+
+	n.log.Info("Connecting to Ninjam... ", address)
+
+	conn, err = n.dialer.DialContext(ctx, "tcp", address)
 
 	if err != nil {
 		return nil, err
 	}
 
-	logrus.Info("Successfully connected to ", address)
+	n.log.Info("Successfully connected to ", address)
 
 	return conn, nil
 }
 
-func (n *NinJamBot) sendChatMessage(message string, msgType string) {
-	nm := models.NewNetMessage(models.ChatMessageType)
-
-	cm := &models.ChatMessage{
+func chatMessage(message string, msgType string) *models.ChatMessage {
+	return &models.ChatMessage{
 		Command: []byte(msgType),
 		Arg1:    []byte(message),
 	}
+}
 
-	nm.OutPayload = cm
+func chatFrame(cm *models.ChatMessage) ([]byte, error) {
+	nm := models.NewNetMessage(models.ChatMessageType)
 
-	msg, err := nm.Marshal()
-	if err != nil {
-		logrus.Error("Send message to ninjam marshal error:", err)
-	}
+	nm.OutPayload = cm
 
-	n.toServerChan <- msg
+	return nm.Marshal()
 }
 
 // WaitAuth block until auth completed
 func (n *NinJamBot) WaitAuth() {
-	for n.inAuthNow {
+	for n.isInAuth() {
 		time.Sleep(time.Millisecond)
 	}
 }
 
-func (n *NinJamBot) SetOnSuccessAuth(f func()) {
-	n.onSuccessAuth = f
-}
-
-func (n *NinJamBot) SetOnServerConfigChange(f func(bpm, bpi uint)) {
-	n.onServerConfigChange = f
-}
-
-func (n *NinJamBot) SetOnUserinfoChange(f func(user models.UserInfo)) {
-	n.onUserinfoChange = f
-}
-
 // ChannelInit adds new channel
 // flags:  0 - ninjam interval based , 2 - voice chat, 4 - session mode
 func (n *NinJamBot) ChannelInit(name string, flags ...uint8) {
@@ -267,74 +860,196 @@ func (n *NinJamBot) ChannelInit(name string, flags ...uint8) {
 		f = flags[0]
 	}
 
+	n.ChannelInitExtended(name, f, 0, 0)
+}
+
+// ChannelInitExtended adds new channel with volume and pan
+// flags:  0 - ninjam interval based , 2 - voice chat, 4 - session mode.
+// Channels are cleared on login, so they must be added after AuthEvent, channel info isn't sent before it
+func (n *NinJamBot) ChannelInitExtended(name string, flags uint8, volume int16, pan int8) {
+	n.stateMutex.Lock()
 	if n.channelInfo == nil {
-		n.channelInfo = &models.ClientSetChannelInfo{
-			Channels: []models.ChannelInfo{
-				{
-					Name:  name,
-					Flags: f,
-				},
-			},
-		}
-	} else {
-		n.channelInfo.Channels = append(n.channelInfo.Channels, models.ChannelInfo{
-			Name:  name,
-			Flags: f,
-		}, )
+		n.channelInfo = &models.ClientSetChannelInfo{}
 	}
 
+	n.channelInfo.Channels = append(n.channelInfo.Channels, models.ChannelInfo{
+		Name:   name,
+		Flags:  flags,
+		Volume: volume,
+		Pan:    pan,
+	})
+
 	nm := models.NewNetMessage(models.ClientSetChannelInfoType)
 
-	nm.OutPayload = n.channelInfo
+	nm.OutPayload = &models.ClientSetChannelInfo{
+		Channels: append([]models.ChannelInfo(nil), n.channelInfo.Channels...),
+	}
+	n.stateMutex.Unlock()
 
 	msg, err := nm.Marshal()
 	if err != nil {
-		logrus.Error("Send message to ninjam marshal error:", err)
+		n.log.Error("Send message to ninjam marshal error:", err)
+		return
 	}
 
-	n.toServerChan <- msg
+	n.sendFrame(msg)
 }
 
-// ChannelInit adds new channel
-// flags:  0 - ninjam interval based , 2 - voice chat, 4 - session mode
-func (n *NinJamBot) ChannelInitExtended(name string, flags uint8, volume int16, pan int8) {
+// ChannelIndex returns index of bot's channel with name, channels are added by ChannelInit
+// and cleared on every reconnect
+func (n *NinJamBot) ChannelIndex(name string) (uint8, bool) {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
+
 	if n.channelInfo == nil {
-		n.channelInfo = &models.ClientSetChannelInfo{
-			Channels: []models.ChannelInfo{
-				{
-					Name:   name,
-					Flags:  flags,
-					Volume: volume,
-					Pan:    pan,
-				},
-			},
+		return 0, false
+	}
+
+	for i, channel := range n.channelInfo.Channels {
+		if channel.Name == name {
+			return uint8(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// SubscribeChannels subscribes bot to audio of user's channels,
+// channelMask bit N means channel with index N, models.AllChannels - all user's channels.
+// Subscriptions are counted, so recorder and activity monitor may subscribe to the same channels:
+// channel is unsubscribed when everybody who subscribed to it unsubscribes.
+// Subscriptions are kept and restored after every reconnect
+func (n *NinJamBot) SubscribeChannels(userName string, channelMask uint32) {
+	n.stateMutex.Lock()
+	refs, ok := n.channelRefs[userName]
+	if !ok {
+		refs = &[32]int{}
+		n.channelRefs[userName] = refs
+	}
+	for i := range refs {
+		if channelMask&(1<<uint(i)) != 0 {
+			refs[i]++
 		}
+	}
+	n.usermasks[userName] |= channelMask
+	mask := n.usermasks[userName]
+	n.stateMutex.Unlock()
+
+	n.sendUsermask([]models.UserMask{{Name: userName, ChannelMask: mask}})
+}
+
+// UnsubscribeChannels cancels SubscribeChannels with the same channelMask
+func (n *NinJamBot) UnsubscribeChannels(userName string, channelMask uint32) {
+	n.stateMutex.Lock()
+	refs, ok := n.channelRefs[userName]
+	if !ok {
+		n.stateMutex.Unlock()
+		return
+	}
+
+	mask := n.usermasks[userName]
+	for i := range refs {
+		if channelMask&(1<<uint(i)) != 0 && refs[i] > 0 {
+			refs[i]--
+			if refs[i] == 0 {
+				mask &^= 1 << uint(i)
+			}
+		}
+	}
+
+	if mask == n.usermasks[userName] {
+		// на каналы подписан кто-то ещё
+		n.stateMutex.Unlock()
+		return
+	}
+	if mask == 0 {
+		delete(n.usermasks, userName)
+		delete(n.channelRefs, userName)
 	} else {
-		n.channelInfo.Channels = append(n.channelInfo.Channels, models.ChannelInfo{
-			Name:   name,
-			Flags:  flags,
-			Volume: volume,
-			Pan:    pan,
-		}, )
+		n.usermasks[userName] = mask
 	}
+	n.stateMutex.Unlock()
 
-	nm := models.NewNetMessage(models.ClientSetChannelInfoType)
+	n.sendUsermask([]models.UserMask{{Name: userName, ChannelMask: mask}})
+}
 
-	nm.OutPayload = n.channelInfo
+// Subscriptions returns current channel masks by user name
+func (n *NinJamBot) Subscriptions() map[string]uint32 {
+	n.stateMutex.Lock()
+	defer n.stateMutex.Unlock()
 
-	msg, err := nm.Marshal()
-	if err != nil {
-		logrus.Error("Send message to ninjam marshal error:", err)
+	subscriptions := make(map[string]uint32, len(n.usermasks))
+	for userName, mask := range n.usermasks {
+		subscriptions[userName] = mask
 	}
 
-	n.toServerChan <- msg
+	return subscriptions
 }
 
-func (n *NinJamBot) IntervalBegin(guid [16]byte, channelIndex uint8) {
-	if n.inAuthNow {
+// restoreSubscriptions sends all stored usermasks to server, server forgets them after re-auth
+func (n *NinJamBot) restoreSubscriptions() {
+	subscriptions := n.Subscriptions()
+	if len(subscriptions) == 0 {
 		return
 	}
 
+	userMasks := make([]models.UserMask, 0, len(subscriptions))
+	for userName, mask := range subscriptions {
+		userMasks = append(userMasks, models.UserMask{Name: userName, ChannelMask: mask})
+	}
+
+	n.sendUsermask(userMasks)
+}
+
+// sendUsermask queues masks for the writer and never blocks: masks of the same user are merged,
+// so callers like recorder may change subscriptions under their locks
+func (n *NinJamBot) sendUsermask(userMasks []models.UserMask) {
+	n.stateMutex.Lock()
+	if n.pendingMasks == nil {
+		n.pendingMasks = make(map[string]uint32)
+	}
+	for _, userMask := range userMasks {
+		n.pendingMasks[userMask.Name] = userMask.ChannelMask
+	}
+	n.stateMutex.Unlock()
+
+	select {
+	case n.masksChanged <- struct{}{}:
+	default:
+	}
+}
+
+// usermaskFrame returns ClientSetUsermask of pending masks, nil when there is nothing to send
+func (n *NinJamBot) usermaskFrame() ([]byte, error) {
+	n.stateMutex.Lock()
+	pending := n.pendingMasks
+	n.pendingMasks = nil
+	online := n.status.State == StateOnline && !(n.inAuthNow && time.Now().Before(n.authDeadline))
+	n.stateMutex.Unlock()
+
+	// без авторизации маски не отправляем - они будут восстановлены после успешного входа
+	if !online || len(pending) == 0 {
+		return nil, nil
+	}
+
+	userMasks := make([]models.UserMask, 0, len(pending))
+	for userName, mask := range pending {
+		userMasks = append(userMasks, models.UserMask{Name: userName, ChannelMask: mask})
+	}
+	sort.Slice(userMasks, func(i, j int) bool {
+		return userMasks[i].Name < userMasks[j].Name
+	})
+
+	nm := models.NewNetMessage(models.ClientSetUsermaskType)
+	nm.OutPayload = &models.ClientSetUsermask{
+		UserMasks: userMasks,
+	}
+
+	return nm.Marshal()
+}
+
+// IntervalBegin starts upload of interval to bot's channel, it's dropped when the bot is not logged in
+func (n *NinJamBot) IntervalBegin(guid [16]byte, channelIndex uint8) {
 	nm := models.NewNetMessage(models.ClientUploadIntervalBeginType)
 
 	cm := &models.ClientUploadIntervalBegin{
@@ -345,17 +1060,16 @@ func (n *NinJamBot) IntervalBegin(guid [16]byte, channelIndex uint8) {
 
 	msg, err := nm.Marshal()
 	if err != nil {
-		logrus.Error("Send message to ninjam marshal error:", err)
+		n.log.Error("Send message to ninjam marshal error:", err)
+		return
 	}
 
-	n.toServerChan <- msg
+	n.sendFrame(msg)
 }
 
+// IntervalWrite uploads part of interval, flags bit 0 marks the last part.
+// It's dropped when the bot is not logged in
 func (n *NinJamBot) IntervalWrite(guid [16]byte, data []byte, flags uint8) {
-	if n.inAuthNow {
-		return
-	}
-
 	nm := models.NewNetMessage(models.ClientUploadIntervalWriteType)
 
 	cm := &models.ClientUploadIntervalWrite{
@@ -367,182 +1081,205 @@ func (n *NinJamBot) IntervalWrite(guid [16]byte, data []byte, flags uint8) {
 
 	msg, err := nm.Marshal()
 	if err != nil {
-		logrus.Error("Send message to ninjam marshal error:", err)
+		n.log.Error("Send message to ninjam marshal error:", err)
+		return
 	}
 
-	n.toServerChan <- msg
+	n.sendFrame(msg)
 }
 
-func (n *NinJamBot) read(conn net.Conn, returnChan chan bool) {
-	defer func() {
-		conn.Close()
-		logrus.Info("Conection closed")
-	}()
+// read reads and handles server messages one by one until connection fails or ctx is done
+func (n *NinJamBot) read(ctx context.Context, conn net.Conn) error {
+	defer n.log.Info("Conection closed")
 
-	logrus.Info("Started connect reader...")
+	n.log.Info("Started connect reader...")
 
-	reader := bufio.NewReader(conn)
-	readChan := make(chan []byte, 1)
+	frameReader := models.NewFrameReader(bufio.NewReader(conn), models.DefaultMaxFrameSize)
 
 	for {
-		// в горутине запускаем чтение коннекта
-		go func() {
-			b := make([]byte, 5)
-			length, err := reader.Read(b)
-
-			if err != nil {
-				logrus.Infof("Error reading: %s", err.Error())
-				returnChan <- true
-				return
-			} else if length < 5 {
-				logrus.Info("Error reading: read less than 5 bytes")
-				returnChan <- true
-				return
-			}
-			logrus.Info("Read from server: ", b)
-			readChan <- b
-			return
-		}()
+		netMessage, err := frameReader.ReadFrame()
 
-		select {
-		case <-returnChan:
-			returnChan <- true
-			// получена команда выйти из горутины
-			return
-		case b := <-readChan:
+		// чтение блокирующее, при отмене контекста коннект закрывается и чтение завершается ошибкой
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
-			newMessage := [5]byte{}
-			copy(newMessage[:], b[0:5])
+		// фрейм с нераспознанным payload тоже пишем, ради таких и нужен capture
+		if netMessage != nil {
+			n.captureFrame(capture.In, netMessage.Type, netMessage.RawData)
+		}
 
-			netMessage := models.NewInNetMessage(newMessage)
+		var payloadErr *models.PayloadError
+		if errors.As(err, &payloadErr) {
+			// фрейм прочитан целиком, поток не сбился - просто пропускаем сообщение
+			n.log.Error("Error when unmarshalling payload:", err)
+			continue
+		} else if err != nil {
+			n.log.Infof("Error reading: %s", err)
+			return err
+		}
 
-			// читаем данные сообщения в буфер равный его заявленной длине
-			payload := make([]byte, netMessage.Length)
-			bufLen, err := reader.Read(payload)
-			if err != nil {
-				logrus.Debug("Error reading:", err.Error())
-				return
-			}
+		// аудио-данные не логируем
+		if !isAudioMessage(netMessage.Type) {
+			n.log.Info("Read from server: ", netMessage.Type, " ", netMessage.Length)
 
-			if netMessage.Length != uint32(bufLen) {
-				logrus.Warning("Error: wrong payload length; buffLen=", bufLen, ", expected length=", netMessage.Length)
-				return
+			if netMessage.InPayload != nil {
+				n.log.Info(render.Render(netMessage.InPayload))
 			}
 
-			err = netMessage.Unmarshal(payload)
-
-			if err != nil {
-				logrus.Error("Error when unmarshalling payload:", err)
-			} else {
-				if netMessage.InPayload != nil {
-					logrus.Info(render.Render(netMessage.InPayload))
-				}
-
-				logrus.Info("Raw bytes:", render.Render(netMessage.RawData))
-
-				go n.handle(netMessage)
-			}
+			n.log.Info("Raw bytes:", render.Render(netMessage.RawData))
 		}
+
+		// обрабатываем сообщения строго в порядке получения, подписчики получают события в том же порядке
+		n.handle(ctx, netMessage)
 	}
 }
 
-// получаем из канала ответы и пишем в сокет
-func (n *NinJamBot) sendToServer(conn net.Conn, toServerErrorChan chan bool, returnChan chan bool) {
-	defer logrus.Debug("sendToServer finished")
+func isAudioMessage(t uint8) bool {
+	return t == models.ServerDownloadIntervalBeginType || t == models.ServerDownloadIntervalWriteType
+}
+
+// sendToServer получает из каналов сообщения и пишет в сокет, пока не отменён ctx или не случилась ошибка записи
+func (n *NinJamBot) sendToServer(ctx context.Context, conn net.Conn) {
+	defer n.log.Debug("sendToServer finished")
 	for {
+		var res []byte
+		var err error
+		var request chatRequest
+
 		select {
-		case res := <-n.toServerChan:
-			if len(res) < 200 {
-				logrus.Info("Sending to server: ", res)
+		case request = <-n.chatRequests:
+			res = request.frame
+		case res = <-n.toServerChan:
+		case message := <-n.messagesToNinJam:
+			res, err = chatFrame(chatMessage(message, models.MSG))
+		case message := <-n.adminMessages:
+			res, err = chatFrame(chatMessage(message, models.ADMIN))
+		case cm := <-n.commandsToNinJam:
+			res, err = chatFrame(cm)
+		case <-n.masksChanged:
+			res, err = n.usermaskFrame()
+			if err == nil && res == nil {
+				continue
 			}
-			_, err := conn.Write(res)
+		case <-ctx.Done():
+			return
+		}
 
-			if err != nil {
-				logrus.Error("Error writing sendToServer:", err.Error())
-				toServerErrorChan <- true
-				return
-			}
-		case <-returnChan:
-			returnChan <- true
+		if err != nil {
+			n.log.Error("Chat message marshal error:", err)
+			continue
+		}
+
+		if len(res) < 200 {
+			n.log.Info("Sending to server: ", res)
+		}
+
+		_, err = conn.Write(res)
+		if request.result != nil {
+			request.result <- err
+		}
+
+		if err != nil {
+			n.log.Error("Error writing sendToServer:", err.Error())
+			// закрываем коннект, чтобы read завершился и соединение переподключилось
+			conn.Close()
 			return
 		}
+
+		n.captureFrame(capture.Out, res[0], res[5:])
 	}
 }
 
-func (n *NinJamBot) handle(netMessage *models.NetMessage) {
+func (n *NinJamBot) handle(ctx context.Context, netMessage *models.NetMessage) {
 	defer func() {
 		if r := recover(); r != nil {
-			logrus.Errorf("Handle error: %s", r)
+			n.log.Errorf("Handle error: %s", r)
 			return
 		}
 	}()
 	switch netMessage.Type {
 	case models.ServerAuthChallengeType:
-		n.inAuthNow = true
-		// авторизация - удаляем каналы, затем должны будем заново их добавить - это делается функцией-коллбэком после авторизации
-		n.channelInfo = nil
-		go func() {
-			// через 10 секунд всё равно отключим режим авторизации, если даже не получим ответа - в крайнем случае
-			// по тикеру пошлём KeepAlive и переконнектимся после ошибки отправки
-			time.Sleep(time.Second * 10)
-			n.inAuthNow = false
-		}()
 		serverAuthChallenge := netMessage.InPayload.(*models.ServerAuthChallenge)
 
-		answer, err := n.login(serverAuthChallenge)
+		keepAlive, err := serverAuthChallenge.KeepAliveInterval()
 
 		if err != nil {
-			logrus.Error("Error when logging in:", err)
+			n.log.Error("Error when decode keep alive interval in:", err)
 			return
 		}
 
-		keepAlive, err := serverAuthChallenge.KeepAliveInterval()
+		n.log.Infof("Keep alive interval: %s", keepAlive)
+
+		n.stateMutex.Lock()
+		// авторизация - удаляем каналы, затем должны будем заново их добавить после авторизации;
+		// если ответа не будет, режим авторизации всё равно закончится по authDeadline
+		n.inAuthNow = true
+		n.authDeadline = time.Now().Add(authTimeout)
+		n.channelInfo = nil
+		n.keepAlive = keepAlive
+		n.stateMutex.Unlock()
+
+		answer, err := n.login(serverAuthChallenge, n.checkLicense(ctx, serverAuthChallenge))
 
 		if err != nil {
-			logrus.Error("Error when decode keep alive interval in:", err)
+			n.log.Error("Error when logging in:", err)
 			return
 		}
 
-		n.keepAliveTicker = time.NewTicker(keepAlive)
-
-		n.toServerChan <- answer
+		select {
+		case n.toServerChan <- answer:
+		case <-ctx.Done():
+		}
 	case models.ServerAuthReplyType:
 		serverAuthReply := netMessage.InPayload.(*models.ServerAuthReply)
 
+		n.stateMutex.Lock()
+		n.inAuthNow = false
+		n.stateMutex.Unlock()
+
 		if serverAuthReply.Flag == 0x1 {
-			logrus.Infof("Logged in succesfully: %s", string(serverAuthReply.ErrorMessage))
+			n.log.Infof("Logged in succesfully: %s", string(serverAuthReply.ErrorMessage))
 
-			if n.onSuccessAuth != nil {
-				n.onSuccessAuth()
-			}
+			n.setState(StateOnline)
+			n.restoreSubscriptions()
 		} else {
-			logrus.Errorf("Login failed: %s", string(serverAuthReply.ErrorMessage))
+			n.log.Errorf("Login failed: %s", string(serverAuthReply.ErrorMessage))
 		}
-		n.inAuthNow = false
+
+		n.publish(ctx, AuthEvent{
+			Success:     serverAuthReply.Flag == 0x1,
+			Message:     string(serverAuthReply.ErrorMessage),
+			MaxChannels: serverAuthReply.MaxChannels,
+		})
 	case models.ServerConfigChangeNotifyType:
 		serverConfig := netMessage.InPayload.(*models.ServerConfigChangeNotify)
 
-		if n.onServerConfigChange != nil {
-			n.onServerConfigChange(uint(serverConfig.BPM), uint(serverConfig.BPI))
-		}
+		n.publish(ctx, ConfigChangeEvent{BPM: uint(serverConfig.BPM), BPI: uint(serverConfig.BPI)})
 	case models.ServerUserInfoChangeNotifyType:
 		serverUserInfo := netMessage.InPayload.(*models.ServerUserInfoChangeNotify)
 
-		for _, userInfo := range serverUserInfo.UserInfos {
-			if userInfo.Active == 0x1 {
-				n.users[string(userInfo.Name)] = string(userInfo.Name)
-			} else if _, ok := n.users[string(userInfo.Name)]; ok {
-				delete(n.users, string(userInfo.Name))
-			}
-			if n.onUserinfoChange != nil {
-				n.onUserinfoChange(userInfo)
-			}
+		events := n.applyUserInfos(serverUserInfo.UserInfos)
+
+		n.log.Infof("Users: %s", models.JoinUsers(n.Users()))
+
+		for _, event := range events {
+			n.publish(ctx, event)
 		}
-		logrus.Infof("Users: %v", n.users)
+	case models.ServerDownloadIntervalBeginType:
+		interval := netMessage.InPayload.(*models.ServerDownloadIntervalBegin)
+
+		n.log.Debugf("Interval begin from %s, channel %d", interval.Username, interval.ChannelIndex)
+
+		n.publish(ctx, IntervalBeginEvent{Interval: interval})
+	case models.ServerDownloadIntervalWriteType:
+		interval := netMessage.InPayload.(*models.ServerDownloadIntervalWrite)
+
+		n.publish(ctx, IntervalWriteEvent{Interval: interval})
 	case models.ChatMessageType:
 		chatMessage := netMessage.InPayload.(*models.ChatMessage)
 
-		logrus.Infof("Chat message received: %s %s %s %s %s", chatMessage.Command, chatMessage.Arg1, chatMessage.Arg2, chatMessage.Arg3, chatMessage.Arg4)
+		n.log.Infof("Chat message received: %s %s %s %s %s", chatMessage.Command, chatMessage.Arg1, chatMessage.Arg2, chatMessage.Arg3, chatMessage.Arg4)
 
 		command := string(chatMessage.Command)
 
@@ -553,22 +1290,54 @@ func (n *NinJamBot) handle(netMessage *models.NetMessage) {
 				Name: string(chatMessage.Arg1),
 				Text: string(chatMessage.Arg2),
 			}
-			n.messagesFromNinJam <- m
-			logrus.Infof("%s said: %s", chatMessage.Arg1, chatMessage.Arg2)
+			n.publish(ctx, ChatEvent{Message: m})
+			n.log.Infof("%s said: %s", chatMessage.Arg1, chatMessage.Arg2)
 		case models.JOIN:
 			m := models.Message{
 				Type: command,
 				Name: string(chatMessage.Arg1),
 			}
-			n.messagesFromNinJam <- m
-			logrus.Infof("%s joined", chatMessage.Arg1)
+			n.publish(ctx, ChatEvent{Message: m})
+			n.log.Infof("%s joined", chatMessage.Arg1)
 		case models.PART:
 			m := models.Message{
 				Type: command,
 				Name: string(chatMessage.Arg1),
 			}
-			n.messagesFromNinJam <- m
-			logrus.Infof("%s leaved", chatMessage.Arg1)
+			n.publish(ctx, ChatEvent{Message: m})
+			n.log.Infof("%s leaved", chatMessage.Arg1)
+		case models.TOPIC:
+			topic := models.NewTopic(chatMessage)
+
+			n.stateMutex.Lock()
+			n.topic = topic.Text
+			n.stateMutex.Unlock()
+
+			n.log.Infof("Topic set by %s: %s", topic.By, topic.Text)
+
+			n.publish(ctx, TopicEvent{Topic: topic})
+		case models.PRIVMSG:
+			message := models.NewPrivateMessage(chatMessage)
+
+			n.log.Infof("%s said privately: %s", message.From, message.Text)
+
+			n.publish(ctx, PrivateMessageEvent{Message: message})
+		case models.USERCOUNT:
+			userCount, err := models.NewUserCount(chatMessage)
+			if err != nil {
+				n.log.Errorf("Wrong USERCOUNT: %s", err)
+				return
+			}
+
+			n.stateMutex.Lock()
+			n.userCount = userCount
+			n.stateMutex.Unlock()
+
+			n.publish(ctx, UserCountEvent{UserCount: userCount})
+		case models.SESSION:
+			session := models.NewSession(chatMessage)
+
+			n.publish(ctx, SessionEvent{Session: session})
 		}
 	}
 }
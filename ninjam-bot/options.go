@@ -0,0 +1,114 @@
+package ninjam_bot
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/capture"
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultBufferSize - size of outgoing message queues and of subscribers' event channels
+	DefaultBufferSize = 1000
+	// DefaultClientVersion is sent to server in ClientAuthUser
+	DefaultClientVersion uint32 = 0x00020000
+)
+
+// Dialer opens connections to server, *net.Dialer implements it
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Option configures NinJamBot created by New
+type Option func(n *NinJamBot)
+
+// WithUser logs in as registered user
+func WithUser(name, password string) Option {
+	return func(n *NinJamBot) {
+		n.userName = name
+		n.password = password
+		n.anonymous = false
+	}
+}
+
+// WithAnonymous logs in as "anonymous:name", server must allow anonymous users
+func WithAnonymous(name string) Option {
+	return func(n *NinJamBot) {
+		n.userName = name
+		n.password = ""
+		n.anonymous = true
+	}
+}
+
+// WithDialer sets dialer for connections to server, by default it's net.Dialer with 10s timeout
+func WithDialer(dialer Dialer) Option {
+	return func(n *NinJamBot) {
+		n.dialer = dialer
+	}
+}
+
+// WithLogger sets logger, by default the bot logs to logrus standard logger
+func WithLogger(logger logrus.FieldLogger) Option {
+	return func(n *NinJamBot) {
+		n.log = logger
+	}
+}
+
+// WithBufferSize sets size of outgoing message queues and of subscribers' event channels, DefaultBufferSize by default
+func WithBufferSize(size int) Option {
+	return func(n *NinJamBot) {
+		n.bufferSize = size
+	}
+}
+
+// WithKeepAlive sets interval of KeepAlive messages, by default the bot uses interval requested by server
+func WithKeepAlive(interval time.Duration) Option {
+	return func(n *NinJamBot) {
+		n.keepAliveOverride = interval
+	}
+}
+
+// WithClientVersion sets client version sent to server, DefaultClientVersion by default
+func WithClientVersion(version uint32) Option {
+	return func(n *NinJamBot) {
+		n.clientVersion = version
+	}
+}
+
+// WithClientCapabilities sets client capabilities sent to server.
+// Bit 0 (license agreement accepted) is always set by LicensePolicy
+func WithClientCapabilities(capabilities uint32) Option {
+	return func(n *NinJamBot) {
+		n.clientCapabilities = capabilities
+	}
+}
+
+// WithReconnectPolicy sets pauses between reconnects, see NinJamBot.ReconnectPolicy
+func WithReconnectPolicy(policy ReconnectPolicy) Option {
+	return func(n *NinJamBot) {
+		n.ReconnectPolicy(policy)
+	}
+}
+
+// WithLicensePolicy sets what to answer to server which requires accepting license agreement,
+// see NinJamBot.LicensePolicy
+func WithLicensePolicy(policy LicensePolicy) Option {
+	return func(n *NinJamBot) {
+		n.LicensePolicy(policy)
+	}
+}
+
+// WithLicenseState keeps hash of the last reported agreement in file, see NinJamBot.LicenseState
+func WithLicenseState(path string) Option {
+	return func(n *NinJamBot) {
+		n.LicenseState(path)
+	}
+}
+
+// WithCapture writes all protocol traffic to w, see NinJamBot.Capture
+func WithCapture(w *capture.Writer) Option {
+	return func(n *NinJamBot) {
+		n.Capture(w)
+	}
+}
@@ -0,0 +1,126 @@
+package ninjam_bot
+
+import (
+	"bytes"
+	"context"
+	"github.com/ayvan/ninjam-chatbot/capture"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingDialer struct {
+	net.Dialer
+	dials int32
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	atomic.AddInt32(&d.dials, 1)
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func TestNew_Defaults(t *testing.T) {
+	n := New("127.0.0.1", "2049")
+
+	assert.True(t, n.anonymous)
+	assert.Equal(t, "bot", n.UserName())
+	assert.Equal(t, DefaultBufferSize, cap(n.toServerChan))
+	assert.Equal(t, DefaultClientVersion, n.clientVersion)
+	assert.Equal(t, defaultKeepAlive, n.keepAliveInterval())
+	assert.Equal(t, DefaultReconnectPolicy(), n.reconnectPolicy)
+
+	n = NewNinJamBot("127.0.0.1", "2049", "recorder", "secret", false, WithBufferSize(10), WithKeepAlive(time.Second*3))
+	assert.False(t, n.anonymous)
+	assert.Equal(t, "recorder", n.UserName())
+	assert.Equal(t, "secret", n.password)
+	assert.Equal(t, 10, cap(n.toServerChan))
+	assert.Equal(t, 10, cap(n.Subscribe()))
+	assert.Equal(t, time.Second*3, n.keepAliveInterval())
+}
+
+func TestNew_Options(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.AddUser("recorder", "secret")
+
+	logBuffer := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(logBuffer)
+
+	captured := &bytes.Buffer{}
+	w, err := capture.NewWriter(captured)
+	require.NoError(t, err)
+
+	dialer := &countingDialer{}
+
+	n := New(server.Host(), server.Port(),
+		WithUser("recorder", "secret"),
+		WithDialer(dialer),
+		WithLogger(logger),
+		WithClientVersion(0x00030000),
+		WithClientCapabilities(0x4|0x1),
+		WithCapture(w),
+	)
+	events := n.Subscribe()
+	result := runBot(n)
+
+	assert.Equal(t, AuthEvent{Success: true, Message: "recorder", MaxChannels: ninjamtest.DefaultMaxChannels}, nextEvent(t, events))
+
+	n.Stop()
+	require.NoError(t, <-result)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dialer.dials))
+	assert.Contains(t, logBuffer.String(), "Logged in succesfully")
+
+	r, err := capture.NewReader(captured)
+	require.NoError(t, err)
+	records, err := r.ReadAll()
+	require.NoError(t, err)
+
+	for _, record := range records {
+		if record.Type != models.ClientAuthUserType {
+			continue
+		}
+
+		auth := &models.ClientAuthUser{}
+		require.NoError(t, auth.Unmarshal(record.Payload))
+		assert.Equal(t, uint32(0x00030000), auth.ClientVersion)
+		// сервер не требует соглашения - бит 0 не выставлен, несмотря на опцию
+		assert.Equal(t, uint32(0x4), auth.ClientCapabilities)
+		return
+	}
+	t.Fatal("ClientAuthUser was not captured")
+}
+
+func TestNinJamBot_SendChat(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+
+	n := New(server.Host(), server.Port(), WithAnonymous("bot"), WithReconnectPolicy(ReconnectPolicy{InitialDelay: time.Hour}))
+	ctx := context.Background()
+
+	assert.Equal(t, ErrNotConnected, n.SendChat(ctx, models.MSG, "hello"))
+	assert.Equal(t, ErrTooManyArguments, n.SendChat(ctx, models.MSG, "1", "2", "3", "4", "5"))
+
+	events := n.Subscribe()
+	result := runBot(n)
+	nextEvent(t, events)
+
+	require.NoError(t, n.SendChat(ctx, models.PRIVMSG, "Vasya", "psst"))
+	assert.Equal(t, []string{models.PRIVMSG, "Vasya", "psst"}, nextFrame(t, server).ChatArgs())
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, n.SendChat(cancelled, models.MSG, "late"))
+
+	n.Stop()
+	require.NoError(t, <-result)
+
+	assert.Equal(t, ErrNotConnected, n.SendChat(ctx, models.MSG, "hello"))
+}
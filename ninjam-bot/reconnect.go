@@ -0,0 +1,125 @@
+package ninjam_bot
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrReconnectAttemptsExceeded is returned by Connect when ReconnectPolicy.MaxAttempts connections in a row failed
+var ErrReconnectAttemptsExceeded = errors.New("reconnect attempts exceeded")
+
+const (
+	defaultReconnectInitialDelay = time.Second * 5
+	defaultReconnectMaxDelay     = time.Minute * 5
+)
+
+// ReconnectPolicy describes pauses between reconnects: delay starts from InitialDelay and doubles
+// after every failed connection up to MaxDelay
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// Jitter randomizes delay by the fraction, 0.2 means ±20%
+	Jitter float64
+	// MaxAttempts - failed connections in a row before Connect gives up, 0 - never give up
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy is used when policy is not set or its delays are zero
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: defaultReconnectInitialDelay,
+		MaxDelay:     defaultReconnectMaxDelay,
+	}
+}
+
+func (p ReconnectPolicy) withDefaults() ReconnectPolicy {
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = defaultReconnectInitialDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultReconnectMaxDelay
+	}
+	if p.MaxDelay < p.InitialDelay {
+		p.MaxDelay = p.InitialDelay
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+
+	return p
+}
+
+// Delay returns pause before reconnect after attempt failed connections in a row, attempt starts from 1
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+	p = p.withDefaults()
+
+	delay := p.InitialDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (rand.Float64()*2 - 1))
+	}
+
+	return delay
+}
+
+// ConnectionState is a state of bot's connection to server
+type ConnectionState int
+
+const (
+	StateOffline ConnectionState = iota
+	StateConnecting
+	StateAuthenticating
+	StateOnline
+	StateBackingOff
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateAuthenticating:
+		return "authenticating"
+	case StateOnline:
+		return "online"
+	case StateBackingOff:
+		return "backing off"
+	default:
+		return "offline"
+	}
+}
+
+// Status describes bot's connection
+type Status struct {
+	State ConnectionState
+	// RetryAt - time of next connection attempt when State is StateBackingOff
+	RetryAt time.Time
+	// Attempt - failed connections in a row
+	Attempt int
+	// Err - last connection error
+	Err error
+}
+
+// String returns short description for chats, e.g. "offline, retrying in 40s"
+func (s Status) String() string {
+	if s.State != StateBackingOff {
+		return s.State.String()
+	}
+
+	retryIn := time.Until(s.RetryAt).Round(time.Second)
+	if retryIn < 0 {
+		retryIn = 0
+	}
+
+	return fmt.Sprintf("offline, retrying in %s", retryIn)
+}
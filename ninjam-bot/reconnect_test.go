@@ -0,0 +1,87 @@
+package ninjam_bot
+
+import (
+	"context"
+	"errors"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicy_Delay(t *testing.T) {
+	p := ReconnectPolicy{InitialDelay: time.Second, MaxDelay: time.Second * 10}
+
+	assert.Equal(t, time.Second, p.Delay(1))
+	assert.Equal(t, time.Second*2, p.Delay(2))
+	assert.Equal(t, time.Second*8, p.Delay(4))
+	assert.Equal(t, time.Second*10, p.Delay(5))
+	assert.Equal(t, time.Second*10, p.Delay(1000))
+
+	// zero policy falls back to defaults
+	assert.Equal(t, defaultReconnectInitialDelay, ReconnectPolicy{}.Delay(1))
+	assert.Equal(t, defaultReconnectMaxDelay, ReconnectPolicy{}.Delay(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := p.Delay(2)
+		assert.True(t, delay >= time.Second && delay <= time.Second*3, "delay %s out of jitter range", delay)
+	}
+}
+
+func TestStatus_String(t *testing.T) {
+	assert.Equal(t, "offline", Status{}.String())
+	assert.Equal(t, "online", Status{State: StateOnline}.String())
+	assert.Equal(t, "authenticating", Status{State: StateAuthenticating}.String())
+
+	status := Status{State: StateBackingOff, RetryAt: time.Now().Add(time.Second*40 + time.Millisecond*200)}
+	assert.Equal(t, "offline, retrying in 40s", status.String())
+}
+
+func TestNinJamBot_MaxAttempts(t *testing.T) {
+	n := NewNinJamBot("127.0.0.1", unreachablePort(), "bot", "", true)
+	n.ReconnectPolicy(ReconnectPolicy{InitialDelay: time.Millisecond, MaxAttempts: 3})
+
+	err := n.Connect(context.Background())
+	assert.True(t, errors.Is(err, ErrReconnectAttemptsExceeded), "unexpected error %v", err)
+
+	status := n.Status()
+	assert.Equal(t, StateOffline, status.State)
+	assert.Equal(t, 3, status.Attempt)
+	assert.Error(t, status.Err)
+}
+
+func TestNinJamBot_Status(t *testing.T) {
+	server := ninjamtest.NewServer()
+	defer server.Close()
+
+	n := NewNinJamBot(server.Host(), server.Port(), "bot", "", true)
+	n.ReconnectPolicy(ReconnectPolicy{InitialDelay: time.Hour})
+	events := n.Subscribe()
+
+	assert.Equal(t, StateOffline, n.Status().State)
+
+	result := runBot(n)
+
+	// после авторизации сервер разрывает соединение, бот ждёт реконнекта
+	assert.IsType(t, AuthEvent{}, nextEvent(t, events))
+	_, err := server.WaitLogin(time.Second * 5)
+	require.NoError(t, err)
+	server.DropClients()
+	assert.IsType(t, DisconnectEvent{}, nextEvent(t, events))
+
+	deadline := time.Now().Add(time.Second * 5)
+	for n.Status().State != StateBackingOff && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	status := n.Status()
+	assert.Equal(t, StateBackingOff, status.State)
+	assert.Equal(t, 1, status.Attempt)
+	assert.Equal(t, "offline, retrying in 1h0m0s", status.String())
+
+	n.Stop()
+	assert.NoError(t, <-result)
+	assert.Equal(t, StateOffline, n.Status().State)
+}
@@ -0,0 +1,94 @@
+package ninjam_bot
+
+import (
+	"crypto/rand"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"time"
+)
+
+// UploadChunkSize - size of ClientUploadIntervalWrite audio data sent by UploadInterval
+const UploadChunkSize = 8192
+
+// UploadInterval uploads data to bot's channel as one interval with new GUID, data is sent in chunks
+// of UploadChunkSize. It returns ErrNotConnected when the bot is not logged in
+func (n *NinJamBot) UploadInterval(channelIndex uint8, data []byte) error {
+	if n.Status().State != StateOnline {
+		return ErrNotConnected
+	}
+
+	var guid [16]byte
+	if _, err := rand.Read(guid[:]); err != nil {
+		return err
+	}
+
+	n.IntervalBegin(guid, channelIndex)
+
+	for offset := 0; offset < len(data); offset += UploadChunkSize {
+		end := offset + UploadChunkSize
+		var flags uint8
+		if end >= len(data) {
+			end = len(data)
+			flags = models.IntervalLastFlag
+		}
+
+		n.IntervalWrite(guid, data[offset:end], flags)
+	}
+
+	return nil
+}
+
+// SilenceInterval tells listeners that bot's channel is silent from the next interval,
+// it's dropped when the bot is not logged in
+func (n *NinJamBot) SilenceInterval(channelIndex uint8) {
+	// интервал с нулевым GUID - в канале тишина
+	n.IntervalBegin([16]byte{}, channelIndex)
+}
+
+// IntervalTicker ticks at boundaries of intervals of server's tempo, the boundaries don't drift with timer delays.
+// Zero IntervalTicker is stopped, it's not safe for concurrent use
+type IntervalTicker struct {
+	timer  *time.Timer
+	length time.Duration
+	next   time.Time
+}
+
+// Start starts ticking one interval of bpm/bpi after now, the running ticker is restarted
+func (t *IntervalTicker) Start(bpm, bpi uint) {
+	t.Stop()
+
+	t.length = time.Minute * time.Duration(bpi) / time.Duration(bpm)
+	t.next = time.Now()
+	t.Next()
+}
+
+// Stop stops the ticker, C returns nil after it
+func (t *IntervalTicker) Stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// C returns channel of the next tick, it's nil when the ticker is stopped, so it blocks forever in select
+func (t *IntervalTicker) C() <-chan time.Time {
+	if t.timer == nil {
+		return nil
+	}
+
+	return t.timer.C
+}
+
+// Next schedules the next tick, it must be called after every received tick
+func (t *IntervalTicker) Next() {
+	t.next = t.next.Add(t.length)
+	if t.next.Before(time.Now()) {
+		// пропущенные интервалы (например, бот был offline) не догоняем
+		t.next = time.Now().Add(t.length)
+	}
+
+	if t.timer == nil {
+		t.timer = time.NewTimer(time.Until(t.next))
+		return
+	}
+	t.timer.Reset(time.Until(t.next))
+}
@@ -0,0 +1,67 @@
+package ninjam_bot
+
+import (
+	"bytes"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNinJamBot_UploadInterval(t *testing.T) {
+	n := New("127.0.0.1", "2049", WithBufferSize(4))
+	data := bytes.Repeat([]byte{'a'}, UploadChunkSize+1)
+
+	assert.Equal(t, ErrNotConnected, n.UploadInterval(1, data))
+	assert.Empty(t, n.toServerChan)
+
+	n.setState(StateOnline)
+	n.connDone = make(chan struct{})
+	require.NoError(t, n.UploadInterval(1, data))
+	require.Len(t, n.toServerChan, 3)
+
+	frame := <-n.toServerChan
+	require.Equal(t, models.ClientUploadIntervalBeginType, frame[0])
+	begin := &models.ClientUploadIntervalBegin{}
+	require.NoError(t, begin.Unmarshal(frame[5:]))
+	assert.Equal(t, uint8(1), begin.ChannelIndex)
+	assert.NotEqual(t, [16]byte{}, begin.GUID)
+
+	// интервал разбит на куски, последний отмечен флагом
+	var uploaded []byte
+	for _, flags := range []uint8{0, 1} {
+		frame := <-n.toServerChan
+		require.Equal(t, models.ClientUploadIntervalWriteType, frame[0])
+		write := &models.ClientUploadIntervalWrite{}
+		require.NoError(t, write.Unmarshal(frame[5:]))
+		assert.Equal(t, begin.GUID, write.GUID)
+		assert.Equal(t, flags, write.Flags)
+		uploaded = append(uploaded, write.AudioData...)
+	}
+	assert.Equal(t, data, uploaded)
+
+	n.SilenceInterval(1)
+	frame = <-n.toServerChan
+	require.NoError(t, begin.Unmarshal(frame[5:]))
+	assert.Equal(t, [16]byte{}, begin.GUID)
+}
+
+func TestIntervalTicker(t *testing.T) {
+	var ticker IntervalTicker
+	assert.Nil(t, ticker.C())
+
+	// 6000 BPM, 1 BPI - интервал 10 мс
+	ticker.Start(6000, 1)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ticker.C():
+			ticker.Next()
+		case <-time.After(time.Second):
+			t.Fatal("no tick")
+		}
+	}
+
+	ticker.Stop()
+	assert.Nil(t, ticker.C())
+}
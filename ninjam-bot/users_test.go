@@ -0,0 +1,105 @@
+package ninjam_bot
+
+import (
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func userInfo(active bool, name string, index uint8, channel string) models.UserInfo {
+	ui := models.UserInfo{
+		ChannelIndex: index,
+		Name:         []byte(name),
+		ChannelName:  []byte(channel),
+	}
+	if active {
+		ui.Active = 1
+	}
+
+	return ui
+}
+
+func TestNinJamBot_updateUser(t *testing.T) {
+	n := NewNinJamBot("localhost", "2049", "bot", "", true)
+
+	n.updateUser(userInfo(true, "Vasya", 1, "vocals"))
+	n.updateUser(userInfo(true, "Vasya", 0, "guitar"))
+	n.updateUser(userInfo(true, "Petya", 0, "bass"))
+
+	users := n.Users()
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, "Petya (bass)", users[0].String())
+		assert.Equal(t, "Vasya (guitar, vocals)", users[1].String())
+	}
+
+	// channel renamed
+	n.updateUser(userInfo(true, "Vasya", 1, "keys"))
+	assert.Equal(t, "Vasya (guitar, keys)", n.Users()[1].String())
+
+	// one channel removed
+	n.updateUser(userInfo(false, "Vasya", 0, ""))
+	assert.Equal(t, "Vasya (keys)", n.Users()[1].String())
+
+	// user left
+	n.updateUser(userInfo(false, "Vasya", 1, ""))
+	n.updateUser(userInfo(false, "Sidor", 0, ""))
+	users = n.Users()
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "Petya", users[0].Name)
+	}
+}
+
+func TestNinJamBot_FindUser(t *testing.T) {
+	n := NewNinJamBot("localhost", "2049", "bot", "", true)
+
+	n.updateUser(userInfo(true, "Vasya@127.0.0.x", 0, "guitar"))
+	n.updateUser(userInfo(true, "vasyan@127.0.0.x", 0, "bass"))
+
+	user, ok := n.FindUser("vasya")
+	assert.True(t, ok)
+	assert.Equal(t, "Vasya@127.0.0.x", user.Name)
+
+	user, ok = n.FindUser("Vasyan@127.0.0.x")
+	assert.True(t, ok)
+	assert.Equal(t, "vasyan@127.0.0.x", user.Name)
+
+	_, ok = n.FindUser("Vas")
+	assert.False(t, ok)
+}
+
+func TestNinJamBot_ChannelIndex(t *testing.T) {
+	n := NewNinJamBot("localhost", "2049", "bot", "", true)
+
+	_, ok := n.ChannelIndex("jukebox")
+	assert.False(t, ok)
+
+	n.ChannelInit("jukebox")
+	n.ChannelInitExtended("metronome", 0, -60, 0)
+
+	index, ok := n.ChannelIndex("metronome")
+	assert.True(t, ok)
+	assert.Equal(t, uint8(1), index)
+
+	// после переподключения каналы создаются заново
+	n.resetSession()
+	_, ok = n.ChannelIndex("metronome")
+	assert.False(t, ok)
+}
+
+func TestNinJamBot_SubscribeChannels(t *testing.T) {
+	n := NewNinJamBot("localhost", "2049", "bot", "", true)
+
+	// рекордер и монитор активности подписываются независимо
+	n.SubscribeChannels("Vasya", models.AllChannels)
+	n.SubscribeChannels("Vasya", 0x01)
+	n.SubscribeChannels("Petya", 0x02)
+	assert.Equal(t, map[string]uint32{"Vasya": models.AllChannels, "Petya": 0x02}, n.Subscriptions())
+
+	n.UnsubscribeChannels("Vasya", models.AllChannels)
+	assert.Equal(t, map[string]uint32{"Vasya": 0x01, "Petya": 0x02}, n.Subscriptions())
+
+	n.UnsubscribeChannels("Vasya", 0x01)
+	n.UnsubscribeChannels("Petya", models.AllChannels)
+	n.UnsubscribeChannels("Sidor", models.AllChannels)
+	assert.Empty(t, n.Subscriptions())
+}
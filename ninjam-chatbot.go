@@ -1,37 +1,104 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/ayvan/ninjam-chatbot/activity"
+	"github.com/ayvan/ninjam-chatbot/capture"
 	"github.com/ayvan/ninjam-chatbot/config"
+	"github.com/ayvan/ninjam-chatbot/icecast"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/jukebox"
+	"github.com/ayvan/ninjam-chatbot/metronome"
 	"github.com/ayvan/ninjam-chatbot/models"
 	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjam-server"
+	"github.com/ayvan/ninjam-chatbot/recorder"
+	"github.com/ayvan/ninjam-chatbot/router"
 	"github.com/ayvan/ninjam-chatbot/slack-bot"
+	"github.com/ayvan/ninjam-chatbot/stream"
 	"github.com/ayvan/ninjam-chatbot/telegram-bot"
 	"github.com/VividCortex/godaemon"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type Mounts struct {
-	mounts map[string]models.Userser
+	mounts   map[string]*ninjam_bot.NinJamBot
+	monitors map[string]*activity.Monitor
+	streams  map[string]*stream.Stream
+	pollers  map[string]*icecast.Poller
+	urls     map[string]string // URLs of streams, relay's URL if there is one
 }
 
-func (m *Mounts) Mounts() map[string][]string {
-	ms := map[string][]string{}
+func (m *Mounts) Mounts() map[string]models.Mount {
+	ms := map[string]models.Mount{}
 
-	for k, mount := range m.mounts {
-		ms[k] = mount.Users()
+	for k, bot := range m.mounts {
+		status := bot.Status()
+		mount := models.Mount{
+			Users:  bot.Users(),
+			Online: status.State == ninjam_bot.StateOnline,
+			Status: status.String(),
+		}
+
+		// если громкость отслеживается, молчащих показываем отдельно
+		if monitor, ok := m.monitors[k]; ok {
+			var playing []models.User
+			for _, user := range mount.Users {
+				if monitor.Playing(user.Name) {
+					playing = append(playing, user)
+				} else {
+					mount.Idle = append(mount.Idle, user)
+				}
+			}
+			mount.Users = playing
+		}
+
+		mount.Stream = m.urls[k]
+		if s, ok := m.streams[k]; ok {
+			mount.Listeners = s.Listeners()
+		}
+		if p, ok := m.pollers[k]; ok {
+			if listeners, known := p.Listeners(); known {
+				mount.Listeners += listeners
+			}
+		}
+
+		ms[k] = mount
 	}
 
 	return ms
 }
 
 func main() {
+	// ninjam-chatbot decode <file> - печатает capture-файл, конфиг для этого не нужен
+	if len(os.Args) > 1 && os.Args[1] == "decode" {
+		if err := decode(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// ninjam-chatbot mixdown <session dir> [output dir] - сводит записанную сессию в WAV
+	if len(os.Args) > 1 && os.Args[1] == "mixdown" {
+		if err := mixdownSession(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	config.Load()
+
 	if config.Get().DaemonMode {
 		godaemon.MakeDaemon(&godaemon.DaemonAttr{})
 	}
@@ -59,170 +126,339 @@ func main() {
 		syscall.SIGQUIT)
 
 	mounts := &Mounts{
-		mounts: make(map[string]models.Userser),
+		mounts:   make(map[string]*ninjam_bot.NinJamBot),
+		monitors: make(map[string]*activity.Monitor),
+		streams:  make(map[string]*stream.Stream),
+		pollers:  make(map[string]*icecast.Poller),
+		urls:     make(map[string]string),
 	}
 
 	bots := make([]*ninjam_bot.NinJamBot, 0)
+	assemblers := make(map[*ninjam_bot.NinJamBot]*intervals.Assembler)
+	recorders := make(map[*ninjam_bot.NinJamBot]config.RecordConf)
+	jukeboxes := make([]*jukebox.Jukebox, 0)
+	metronomes := make([]*metronome.Metronome, 0)
+	monitors := make(map[*ninjam_bot.NinJamBot]*activity.Monitor)
+	streams := make([]*stream.Stream, 0)
+	streamMux := http.NewServeMux()
 
 	for _, server := range config.Get().Servers {
-		bot := ninjam_bot.NewNinJamBot(server.Host, server.Port, server.UserName, server.UserPassword, server.Anonymous)
+		licenseMode, err := ninjam_bot.ParseLicenseMode(server.License.Policy)
+		if err != nil {
+			logrus.Fatalf("Server %s:%s config error: %s", server.Host, server.Port, err)
+		}
+
+		opts := []ninjam_bot.Option{
+			ninjam_bot.WithReconnectPolicy(ninjam_bot.ReconnectPolicy{
+				InitialDelay: server.Reconnect.InitialDelay,
+				MaxDelay:     server.Reconnect.MaxDelay,
+				Jitter:       server.Reconnect.Jitter,
+				MaxAttempts:  server.Reconnect.MaxAttempts,
+			}),
+			ninjam_bot.WithLicensePolicy(ninjam_bot.LicensePolicy{Mode: licenseMode, Hash: server.License.Hash}),
+			ninjam_bot.WithLicenseState(server.License.State),
+		}
+
+		if server.Anonymous {
+			opts = append(opts, ninjam_bot.WithAnonymous(server.UserName))
+		} else {
+			opts = append(opts, ninjam_bot.WithUser(server.UserName, server.UserPassword))
+		}
+
+		if server.Capture != "" {
+			w, err := capture.Create(server.Capture)
+			if err != nil {
+				logrus.Fatalf("Can't create capture file %s: %s", server.Capture, err)
+			}
+			defer w.Close()
+			opts = append(opts, ninjam_bot.WithCapture(w))
+		}
+
+		bot := ninjam_bot.New(server.Host, server.Port, opts...)
 		mounts.mounts[server.Port] = bot
 		bots = append(bots, bot)
+		// рекордер, монитор активности и трансляция скачивают аудио пользователей один раз на всех
+		if server.Record.Dir != "" || server.Activity.Enabled || server.Stream.Enabled {
+			assemblers[bot] = intervals.New(bot)
+		}
+		if server.Record.Dir != "" {
+			recorders[bot] = server.Record
+		}
+		// джукбокс подписывается на события бота, поэтому создаётся до подключения
+		if server.Jukebox.Dir != "" {
+			jb := jukebox.New(bot, server.Jukebox.Dir)
+			if server.Jukebox.Channel != "" {
+				jb.Channel(server.Jukebox.Channel)
+			}
+			if server.Jukebox.Command != "" {
+				jb.Command(server.Jukebox.Command)
+			}
+			jukeboxes = append(jukeboxes, jb)
+		}
+		if server.Metronome.Dir != "" {
+			m := metronome.New(bot, server.Metronome.Dir)
+			if server.Metronome.Channel != "" {
+				m.Channel(server.Metronome.Channel)
+			}
+			if server.Metronome.Command != "" {
+				m.Command(server.Metronome.Command)
+			}
+			m.Volume(server.Metronome.Volume)
+			if server.Metronome.Enabled {
+				m.On()
+			}
+			metronomes = append(metronomes, m)
+		}
+		if server.Activity.Enabled {
+			monitor := activity.New(assemblers[bot])
+			if server.Activity.Threshold != 0 {
+				monitor.Threshold(server.Activity.Threshold)
+			}
+			monitor.AFK(server.Activity.AFK)
+			monitors[bot] = monitor
+			mounts.monitors[server.Port] = monitor
+		}
+		if server.Stream.Enabled {
+			s := stream.New(assemblers[bot])
+			if server.Stream.Name != "" {
+				s.Name(server.Stream.Name)
+			}
+			path := server.Stream.Path
+			if path == "" {
+				path = "/" + server.Port
+			}
+			streamMux.Handle(path, s)
+			streams = append(streams, s)
+			mounts.streams[server.Port] = s
+			mounts.urls[server.Port] = strings.TrimSuffix(config.Get().HTTP.URL, "/") + path
+		}
+		if server.Icecast.Status != "" {
+			p := icecast.New(server.Icecast.Status, server.Icecast.Mount)
+			p.Auth(server.Icecast.User, server.Icecast.Password)
+			if server.Icecast.Period > 0 {
+				p.Period(server.Icecast.Period)
+			}
+			mounts.pollers[server.Port] = p
+		}
+		if server.Icecast.URL != "" {
+			mounts.urls[server.Port] = server.Icecast.URL
+		}
+	}
+
+	if len(streams) > 0 && config.Get().HTTP.Listen == "" {
+		logrus.Fatal("HTTP listen address is required for live streams")
 	}
 
 	tbot := telegram_bot.NewTelegramBot(config.Get().Telegram.Token, config.Get().Telegram.ChatID, mounts)
 	if config.Get().Telegram.Disabled {
 		tbot.Disabled(true)
 	}
+	tbot.SyncTopic(config.Get().Telegram.SyncTopic)
+	tbot.Admins(config.Get().Telegram.Admins)
+	tbot.AdminChatID(config.Get().Telegram.AdminChatID)
 
 	sbot := slack_bot.NewSlackBot(config.Get().Slack.Token, config.Get().Slack.Channel, config.Get().Slack.BotName, mounts)
 	if config.Get().Slack.Disabled {
 		sbot.Disabled(true)
 	}
+	sbot.SyncTopic(config.Get().Slack.SyncTopic)
+	sbot.Admins(config.Get().Slack.Admins)
 
-	// инициализируем глобальный канал завершения горутин
-	sigChan := make(chan bool, 1)
-
-	go func() {
-		// ловим сигнал завершения, выводим информацию в лог, а затем отправляем его в глобальный канал
-		s := <-sChan
-		logrus.Info("os.Signal ", s, " received, finishing application...")
-		for _, bot := range bots {
-			bot.Stop()
-		}
+	// контекст приложения, отменяется при получении сигнала завершения
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-		tbot.Stop()
-		sbot.Stop()
-		sigChan <- true
-		return
-	}()
+	// роутер подписывается на сообщения ботов до их подключения, новый чат - ещё один мост
+	r := router.New(config.Get().IgnoreUsers, config.Get().IgnorePrefix)
+	bridges := make(map[string]router.Bridge)
+	for _, bot := range bots {
+		bridge := router.NewNinJam(bot)
+		bridges[serverName(bot)] = bridge
+		r.Register(serverName(bot), bridge)
+	}
+	bridges["telegram"] = tbot
+	r.Register("telegram", tbot)
+	bridges["slack"] = sbot
+	r.Register("slack", sbot)
 
 	wg := &sync.WaitGroup{}
 
-	logrus.Info("Application ", config.Get().AppName, " started")
+	// рекордеры тоже подписываются на события ботов до их подключения, при завершении они закрывают сессии,
+	// команда записи из чатов управляет всеми рекордерами в порядке серверов
+	recordControl := recorder.NewControl()
+	r.Command(models.RECORD, recordControl)
+	for _, bot := range bots {
+		recordConf, ok := recorders[bot]
+		if !ok {
+			continue
+		}
+		rec := recorder.New(assemblers[bot], recordConf.Dir)
+		recordControl.Add(serverName(bot), rec)
 
-	type BotMessage struct {
-		Bot     *ninjam_bot.NinJamBot
-		Message models.Message
+		if recordConf.Enabled {
+			if _, err := rec.Start(); err != nil {
+				logrus.Errorf("Recorder %s:%s start error: %s", bot.Host(), bot.Port(), err)
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec.Run(ctx)
+		}()
 	}
 
-	botChan := make(chan BotMessage, 1000)
+	for _, assembler := range assemblers {
+		wg.Add(1)
+		go func(assembler *intervals.Assembler) {
+			defer wg.Done()
+			assembler.Run(ctx)
+		}(assembler)
+	}
 
-	for _, bot := range bots {
+	for _, jb := range jukeboxes {
 		wg.Add(1)
-		go func(bot *ninjam_bot.NinJamBot) {
+		go func(jb *jukebox.Jukebox) {
 			defer wg.Done()
-			bot.Connect()
-		}(bot)
+			jb.Run(ctx)
+		}(jb)
+	}
 
-		go func(bot *ninjam_bot.NinJamBot) {
+	for bot, monitor := range monitors {
+		// уведомления монитора о молчащих пользователях роутер пересылает в чаты
+		go func(name string, silences <-chan activity.Silence) {
 			for {
 				select {
-				case msg := <-bot.IncomingMessages():
-					bm := BotMessage{
-						Bot:     bot,
-						Message: msg,
-					}
-					botChan <- bm
-				case <-sigChan:
-					sigChan <- true
+				case s := <-silences:
+					r.Notify(name, s)
+				case <-ctx.Done():
 					return
 				}
 			}
-		}(bot)
+		}(serverName(bot), monitor.Silences())
+
+		wg.Add(1)
+		go func(monitor *activity.Monitor) {
+			defer wg.Done()
+			monitor.Run(ctx)
+		}(monitor)
 	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		tbot.Connect()
-	}()
+	for _, m := range metronomes {
+		wg.Add(1)
+		go func(m *metronome.Metronome) {
+			defer wg.Done()
+			m.Run(ctx)
+		}(m)
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		sbot.Connect()
-	}()
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s *stream.Stream) {
+			defer wg.Done()
+			s.Run(ctx)
+		}(s)
+	}
 
-	tbotChan := tbot.IncomingMessages()
-	sbotChan := sbot.IncomingMessages()
+	for _, p := range mounts.pollers {
+		wg.Add(1)
+		go func(p *icecast.Poller) {
+			defer wg.Done()
+			p.Run(ctx)
+		}(p)
+	}
 
-f:
-	for {
-		select {
-		case s := <-sigChan:
-			sigChan <- s
-			break f
-			// messages routers <->
-		case msg := <-botChan:
-			if strings.HasPrefix(msg.Message.Name, msg.Bot.UserName()) {
-				continue
-			}
+	if len(streams) > 0 {
+		httpServer := &http.Server{Addr: config.Get().HTTP.Listen, Handler: streamMux}
 
-			for _, userName := range config.Get().IgnoreUsers {
-				if strings.HasPrefix(msg.Message.Name, userName) {
-					continue f
-				}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logrus.Errorf("HTTP server %s error: %s", httpServer.Addr, err)
 			}
+		}()
 
-			for _, botName := range config.Get().IgnorePrefix {
-				if strings.HasPrefix(msg.Message.Text, botName) {
-					continue f
-				}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-ctx.Done()
+			// потоки отключают слушателей сами, ждём только завершения их обработчиков
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			if err := httpServer.Shutdown(shutdownCtx); err != nil {
+				logrus.Errorf("HTTP server %s shutdown error: %s", httpServer.Addr, err)
 			}
+		}()
+	}
 
-			message := fmt.Sprintf("%s@%s:%s: %s", msg.Message.Name, msg.Bot.Host(), msg.Bot.Port(), msg.Message.Text)
+	go func() {
+		// ловим сигнал завершения, выводим информацию в лог, а затем отменяем контекст
+		s := <-sChan
+		logrus.Info("os.Signal ", s, " received, finishing application...")
+		cancel()
+		for _, b := range bridges {
+			b.Stop()
+		}
+	}()
 
-			switch msg.Message.Type {
-			case models.MSG:
-				logrus.Infof("Sendind to Telegram: %s", message)
-				tbot.SendMessage(message)
-				logrus.Infof("Sendind to Slack: %s", message)
-				sbot.SendMessage(message)
-				for _, bot := range bots {
-					if bot.Host() != msg.Bot.Host() || bot.Port() != msg.Bot.Port() {
-						logrus.Infof("Sendind to Ninjam %s:%s", bot.Host(), bot.Port())
-						bot.SendMessage(message)
-					}
-				}
-			case models.JOIN:
-				message := fmt.Sprintf("%s зашёл на джем-сервер %s:%s ", msg.Message.Name, msg.Bot.Host(), msg.Bot.Port())
-				logrus.Infof("Sendind to Telegram: %s", message)
-				tbot.SendMessage(message)
-				logrus.Infof("Sendind to Slack: %s", message)
-				sbot.SendMessage(message)
-			case models.PART:
-				message := fmt.Sprintf("%s покинул джем-сервер %s:%s ", msg.Message.Name, msg.Bot.Host(), msg.Bot.Port())
-				logrus.Infof("Sendind to Telegram: %s", message)
-				tbot.SendMessage(message)
-				logrus.Infof("Sendind to Slack: %s", message)
-				sbot.SendMessage(message)
-			}
+	logrus.Info("Application ", config.Get().AppName, " started")
 
-		case msg := <-tbotChan:
-			message := fmt.Sprintf("%s@telegram: %s", msg.Name, msg.Text)
-			logrus.Infof("Sendind to NinJam: %s", message)
-			for _, bot := range bots {
-				bot.SendMessage(message)
-			}
-			logrus.Infof("Sendind to Slack: %s", message)
-			sbot.SendMessage(message)
-		case msg := <-sbotChan:
-			message := fmt.Sprintf("%s@slack: %s", msg.Name, msg.Text)
-			logrus.Infof("Sendind to NinJam: %s", message)
-			for _, bot := range bots {
-				bot.SendMessage(message)
+	// встроенный сервер запускаем до ботов, бот подключается к нему как к любому другому серверу из servers
+	if serverConf := config.Get().Server; serverConf.Enabled {
+		server := ninjam_server.NewServer(newServerConfig(serverConf))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.ListenAndServe(ctx); err != nil {
+				logrus.Errorf("NINJAM server %s error: %s", serverConf.Listen, err)
 			}
-			logrus.Infof("Sendind to Telegram: %s", message)
-			tbot.SendMessage(message)
-		}
+		}()
+	}
 
+	for name, b := range bridges {
+		wg.Add(1)
+		go func(name string, b router.Bridge) {
+			defer wg.Done()
+			if err := b.Connect(ctx); err != nil {
+				logrus.Errorf("Bridge %s error: %s", name, err)
+			}
+		}(name, b)
 	}
 
-	wg.Wait()
+	r.Run(ctx)
 
-	// t.SendMessage(fmt.Sprint("Новые участники на джем-сервере ", n.Name, ": ", liUsers))
-	// t.SendMessage(fmt.Sprint("Джем-сервер ", n.Name, " покинули: ", loUsers))
+	wg.Wait()
 
 	logrus.Info("Application ", config.Get().AppName, " finished")
 }
+
+// serverName is the name of NINJAM server in router and chats
+func serverName(bot *ninjam_bot.NinJamBot) string {
+	return bot.Host() + ":" + bot.Port()
+}
+
+func newServerConfig(conf config.ServerConf) ninjam_server.Config {
+	users := make([]ninjam_server.User, 0, len(conf.Users))
+	for _, user := range conf.Users {
+		users = append(users, ninjam_server.User{
+			Name:     user.Name,
+			Password: user.Password,
+			Topic:    user.Topic,
+			Admin:    user.Admin,
+		})
+	}
+
+	return ninjam_server.Config{
+		Listen:      conf.Listen,
+		BPM:         conf.BPM,
+		BPI:         conf.BPI,
+		MaxUsers:    conf.MaxUsers,
+		MaxChannels: conf.MaxChannels,
+		KeepAlive:   conf.KeepAlive,
+		Topic:       conf.Topic,
+		Anonymous:   conf.Anonymous,
+		Users:       users,
+	}
+}
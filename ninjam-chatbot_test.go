@@ -1 +1,46 @@
 package main
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/icecast"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMounts_Icecast(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<icestats><source mount="/2049"><listeners>12</listeners></source></icestats>`))
+	}))
+	defer server.Close()
+
+	p := icecast.New(server.URL+"/admin/stats", "/2049")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	mounts := &Mounts{
+		mounts:  map[string]*ninjam_bot.NinJamBot{"2049": ninjam_bot.New("127.0.0.1", "2049")},
+		pollers: map[string]*icecast.Poller{"2049": p},
+		urls:    map[string]string{"2049": "http://icecast.example.com/2049"},
+	}
+
+	timeout := time.After(time.Second * 5)
+	for {
+		if _, known := p.Listeners(); known {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("Icecast status is not polled")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+
+	mount := mounts.Mounts()["2049"]
+	assert.Equal(t, 12, mount.Listeners)
+	assert.Equal(t, "http://icecast.example.com/2049", mount.Stream)
+}
@@ -0,0 +1,102 @@
+package ninjam_server
+
+import (
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/sirupsen/logrus"
+	"net"
+	"sync"
+	"time"
+)
+
+// client is a logged in user, all fields except sendChan are guarded by Server.mutex
+type client struct {
+	conn     net.Conn
+	name     string
+	user     User
+	channels []models.ChannelInfo
+	// usermasks - на чьи каналы подписан пользователь, ключ - имя в нижнем регистре
+	usermasks map[string]uint32
+	// uploads - получатели интервалов, которые сейчас загружает пользователь
+	uploads map[[16]byte][]*client
+
+	sendChan  chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(conn net.Conn, name string, user User) *client {
+	return &client{
+		conn:      conn,
+		name:      name,
+		user:      user,
+		usermasks: make(map[string]uint32),
+		uploads:   make(map[[16]byte][]*client),
+		sendChan:  make(chan []byte, 1000),
+		done:      make(chan struct{}),
+	}
+}
+
+// send queues message to the client, the client which doesn't read its messages is disconnected
+func (c *client) send(msgType uint8, payload models.Marshaler) {
+	data, err := frame(msgType, payload)
+	if err != nil {
+		logrus.Error("Send message to client marshal error:", err)
+		return
+	}
+
+	select {
+	case c.sendChan <- data:
+	case <-c.done:
+	default:
+		logrus.Warnf("User %s doesn't read messages, disconnecting", c.name)
+		c.close()
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// writeLoop writes queued messages to connection and sends KeepAlive when there is nothing to send
+func (c *client) writeLoop(keepAlive time.Duration) {
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		var data []byte
+
+		select {
+		case data = <-c.sendChan:
+		case <-ticker.C:
+			data = []byte{models.ClientKeepaliveType, 0, 0, 0, 0}
+		case <-c.done:
+			return
+		}
+
+		if _, err := c.conn.Write(data); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// userInfos returns active records of all user's channels
+func (c *client) userInfos() []models.UserInfo {
+	userInfos := make([]models.UserInfo, 0, len(c.channels))
+	for i, channel := range c.channels {
+		userInfos = append(userInfos, models.UserInfo{
+			Active:       1,
+			ChannelIndex: uint8(i),
+			Volume:       channel.Volume,
+			Pan:          channel.Pan,
+			Flags:        channel.Flags,
+			Name:         []byte(c.name),
+			ChannelName:  []byte(channel.Name),
+		})
+	}
+
+	return userInfos
+}
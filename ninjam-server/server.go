@@ -0,0 +1,537 @@
+package ninjam_server
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// authTimeout - за это время клиент должен ответить на ServerAuthChallenge
+	authTimeout = time.Second * 10
+
+	defaultBPM         = 120
+	defaultBPI         = 16
+	defaultMaxUsers    = 10
+	defaultMaxChannels = 2
+	defaultKeepAlive   = 3
+
+	protocolVersion = 0x00020000
+	anonymousPrefix = "anonymous:"
+)
+
+var (
+	errInvalidLogin     = errors.New("invalid login/password")
+	errAnonymousDenied  = errors.New("anonymous users not allowed")
+	errServerFull       = errors.New("server full")
+	errAlreadyConnected = errors.New("user already connected")
+)
+
+// User is a registered server user
+type User struct {
+	Name     string
+	Password string
+	Topic    bool // user can change topic
+	Admin    bool // user can change BPM and BPI with ADMIN commands
+}
+
+// Config is the embedded server configuration, zero values mean defaults
+type Config struct {
+	Listen      string // e.g. ":2049"
+	BPM         uint16
+	BPI         uint16
+	MaxUsers    int
+	MaxChannels uint8
+	KeepAlive   uint8 // seconds
+	Topic       string
+	Anonymous   bool // allow "anonymous:name" logins
+	Users       []User
+}
+
+// Server is a lightweight NINJAM server: it authenticates clients, relays intervals between subscribed users,
+// broadcasts users' channels and config changes and handles chat
+type Server struct {
+	conf Config
+
+	mutex   sync.Mutex
+	bpm     uint16
+	bpi     uint16
+	topic   string
+	clients map[*client]struct{}
+
+	listenerMutex sync.Mutex
+	listener      net.Listener
+	wg            sync.WaitGroup
+}
+
+func NewServer(conf Config) *Server {
+	if conf.BPM == 0 {
+		conf.BPM = defaultBPM
+	}
+	if conf.BPI == 0 {
+		conf.BPI = defaultBPI
+	}
+	if conf.MaxUsers <= 0 {
+		conf.MaxUsers = defaultMaxUsers
+	}
+	if conf.MaxChannels == 0 {
+		conf.MaxChannels = defaultMaxChannels
+	}
+	if conf.KeepAlive == 0 {
+		conf.KeepAlive = defaultKeepAlive
+	}
+
+	return &Server{
+		conf:    conf,
+		bpm:     conf.BPM,
+		bpi:     conf.BPI,
+		topic:   conf.Topic,
+		clients: make(map[*client]struct{}),
+	}
+}
+
+// ListenAndServe listens on Config.Listen and serves clients until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.conf.Listen)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts clients on listener until ctx is done, then disconnects all clients and waits for their goroutines
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	s.listenerMutex.Lock()
+	s.listener = listener
+	s.listenerMutex.Unlock()
+
+	logrus.Infof("NINJAM server listening on %s", listener.Addr())
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	defer func() {
+		s.mutex.Lock()
+		for c := range s.clients {
+			c.close()
+		}
+		s.mutex.Unlock()
+
+		s.wg.Wait()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveClient(conn)
+		}()
+	}
+}
+
+// Addr returns listener address, nil if server is not started yet
+func (s *Server) Addr() net.Addr {
+	s.listenerMutex.Lock()
+	defer s.listenerMutex.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Addr()
+}
+
+// Users returns names of logged in users
+func (s *Server) Users() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	names := make([]string, 0, len(s.clients))
+	for c := range s.clients {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
+func (s *Server) serveClient(conn net.Conn) {
+	defer conn.Close()
+
+	var challenge [8]byte
+	rand.Read(challenge[:])
+
+	data, err := frame(models.ServerAuthChallengeType, &models.ServerAuthChallenge{
+		Challenge:          challenge,
+		ServerCapabilities: uint32(s.conf.KeepAlive) << 8,
+		ProtocolVersion:    protocolVersion,
+	})
+	if err != nil {
+		logrus.Error("Auth challenge marshal error:", err)
+		return
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return
+	}
+
+	reader := models.NewFrameReader(conn, models.DefaultMaxFrameSize)
+
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	nm, err := reader.ReadFrame()
+	if err != nil || nm.Type != models.ClientAuthUserType {
+		logrus.Infof("Client %s didn't log in: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	c, err := s.login(conn, nm.InPayload.(*models.ClientAuthUser), challenge)
+	if err != nil {
+		logrus.Infof("Client %s login failed: %s", conn.RemoteAddr(), err)
+		if data, err := frame(models.ServerAuthReplyType, &models.ServerAuthReply{ErrorMessage: []byte(err.Error())}); err == nil {
+			conn.Write(data)
+		}
+		return
+	}
+
+	logrus.Infof("User %s logged in from %s", c.name, conn.RemoteAddr())
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		c.writeLoop(time.Second * time.Duration(s.conf.KeepAlive))
+	}()
+
+	defer func() {
+		s.leave(c)
+		c.close()
+		<-writerDone
+		logrus.Infof("User %s disconnected", c.name)
+	}()
+
+	// клиент шлёт KeepAlive, если за три интервала от него ничего нет - отключаем
+	readTimeout := time.Second * time.Duration(s.conf.KeepAlive) * 3
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		nm, err := reader.ReadFrame()
+
+		var payloadErr *models.PayloadError
+		if errors.As(err, &payloadErr) {
+			logrus.Errorf("Bad message from %s: %s", c.name, err)
+			continue
+		} else if err != nil {
+			return
+		}
+
+		s.handle(c, nm)
+	}
+}
+
+// login checks user's credentials and adds the client to the server
+func (s *Server) login(conn net.Conn, auth *models.ClientAuthUser, challenge [8]byte) (*client, error) {
+	name := string(auth.Username)
+	user := User{}
+
+	if strings.HasPrefix(name, anonymousPrefix) {
+		if !s.conf.Anonymous {
+			return nil, errAnonymousDenied
+		}
+		name = strings.TrimPrefix(name, anonymousPrefix) + "@" + maskAddr(conn.RemoteAddr())
+	} else {
+		var ok bool
+		user, ok = s.user(name)
+		if !ok || !auth.CheckPassword(user.Password, challenge) {
+			return nil, errInvalidLogin
+		}
+	}
+
+	c := newClient(conn, name, user)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.clients) >= s.conf.MaxUsers {
+		return nil, errServerFull
+	}
+
+	for other := range s.clients {
+		if strings.EqualFold(other.name, name) {
+			return nil, errAlreadyConnected
+		}
+	}
+
+	s.clients[c] = struct{}{}
+
+	// ответ на авторизацию, настройки сервера, список пользователей и тема
+	c.send(models.ServerAuthReplyType, &models.ServerAuthReply{Flag: 1, ErrorMessage: []byte(name), MaxChannels: s.conf.MaxChannels})
+	c.send(models.ServerConfigChangeNotifyType, &models.ServerConfigChangeNotify{BPM: s.bpm, BPI: s.bpi})
+
+	userInfos := make([]models.UserInfo, 0)
+	for other := range s.clients {
+		if other != c {
+			userInfos = append(userInfos, other.userInfos()...)
+		}
+	}
+	if len(userInfos) > 0 {
+		c.send(models.ServerUserInfoChangeNotifyType, &models.ServerUserInfoChangeNotify{UserInfos: userInfos})
+	}
+
+	c.send(models.ChatMessageType, chatMessage(models.TOPIC, "", s.topic))
+
+	s.broadcast(models.ChatMessageType, chatMessage(models.JOIN, name))
+	s.broadcastUserCount()
+
+	return c, nil
+}
+
+func (s *Server) user(name string) (User, bool) {
+	for _, user := range s.conf.Users {
+		if user.Name == name {
+			return user, true
+		}
+	}
+
+	return User{}, false
+}
+
+// leave removes the client and tells other users that his channels are gone
+func (s *Server) leave(c *client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	delete(s.clients, c)
+
+	userInfos := make([]models.UserInfo, 0, len(c.channels))
+	for i := range c.channels {
+		userInfos = append(userInfos, models.UserInfo{ChannelIndex: uint8(i), Name: []byte(c.name)})
+	}
+	if len(userInfos) > 0 {
+		s.broadcast(models.ServerUserInfoChangeNotifyType, &models.ServerUserInfoChangeNotify{UserInfos: userInfos})
+	}
+
+	s.broadcast(models.ChatMessageType, chatMessage(models.PART, c.name))
+	s.broadcastUserCount()
+}
+
+func (s *Server) handle(c *client, nm *models.NetMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch nm.Type {
+	case models.ClientKeepaliveType:
+	case models.ClientSetChannelInfoType:
+		s.setChannels(c, nm.InPayload.(*models.ClientSetChannelInfo).Channels)
+	case models.ClientSetUsermaskType:
+		for _, userMask := range nm.InPayload.(*models.ClientSetUsermask).UserMasks {
+			if userMask.ChannelMask == 0 {
+				delete(c.usermasks, strings.ToLower(userMask.Name))
+			} else {
+				c.usermasks[strings.ToLower(userMask.Name)] = userMask.ChannelMask
+			}
+		}
+	case models.ClientUploadIntervalBeginType:
+		s.intervalBegin(c, nm.InPayload.(*models.ClientUploadIntervalBegin))
+	case models.ClientUploadIntervalWriteType:
+		s.intervalWrite(c, nm.InPayload.(*models.ClientUploadIntervalWrite))
+	case models.ChatMessageType:
+		s.chat(c, nm.InPayload.(*models.ChatMessage))
+	default:
+		logrus.Debugf("Unknown message %d from %s", nm.Type, c.name)
+	}
+}
+
+// setChannels replaces user's channels and sends the changes to all users
+func (s *Server) setChannels(c *client, channels []models.ChannelInfo) {
+	if len(channels) > int(s.conf.MaxChannels) {
+		channels = channels[:s.conf.MaxChannels]
+	}
+
+	removed := make([]models.UserInfo, 0)
+	for i := len(channels); i < len(c.channels); i++ {
+		removed = append(removed, models.UserInfo{ChannelIndex: uint8(i), Name: []byte(c.name)})
+	}
+
+	c.channels = channels
+
+	userInfos := append(c.userInfos(), removed...)
+	if len(userInfos) > 0 {
+		s.broadcast(models.ServerUserInfoChangeNotifyType, &models.ServerUserInfoChangeNotify{UserInfos: userInfos})
+	}
+}
+
+// intervalBegin starts relaying of the interval to users subscribed to uploader's channel
+func (s *Server) intervalBegin(c *client, begin *models.ClientUploadIntervalBegin) {
+	if int(begin.ChannelIndex) >= len(c.channels) {
+		return
+	}
+
+	recipients := make([]*client, 0)
+	for other := range s.clients {
+		if other != c && other.usermasks[strings.ToLower(c.name)]&(1<<begin.ChannelIndex) != 0 {
+			recipients = append(recipients, other)
+		}
+	}
+
+	download := &models.ServerDownloadIntervalBegin{
+		GUID:          begin.GUID,
+		EstimatedSize: begin.EstimatedSize,
+		FourCC:        begin.FourCC,
+		ChannelIndex:  begin.ChannelIndex,
+		Username:      []byte(c.name),
+	}
+
+	for _, recipient := range recipients {
+		recipient.send(models.ServerDownloadIntervalBeginType, download)
+	}
+
+	// пустой GUID - пользователь перестал играть в канал, данных не будет
+	if download.IsEmpty() {
+		return
+	}
+
+	c.uploads[begin.GUID] = recipients
+}
+
+func (s *Server) intervalWrite(c *client, write *models.ClientUploadIntervalWrite) {
+	recipients, ok := c.uploads[write.GUID]
+	if !ok {
+		return
+	}
+
+	download := &models.ServerDownloadIntervalWrite{
+		GUID:      write.GUID,
+		Flags:     write.Flags,
+		AudioData: write.AudioData,
+	}
+
+	for _, recipient := range recipients {
+		recipient.send(models.ServerDownloadIntervalWriteType, download)
+	}
+
+	if download.IsLast() {
+		delete(c.uploads, write.GUID)
+	}
+}
+
+func (s *Server) chat(c *client, cm *models.ChatMessage) {
+	switch string(cm.Command) {
+	case models.MSG:
+		s.broadcast(models.ChatMessageType, chatMessage(models.MSG, c.name, string(cm.Arg1)))
+	case models.PRIVMSG:
+		to := string(cm.Arg1)
+		for other := range s.clients {
+			if strings.EqualFold(other.name, to) {
+				other.send(models.ChatMessageType, chatMessage(models.PRIVMSG, c.name, string(cm.Arg2)))
+				return
+			}
+		}
+		c.send(models.ChatMessageType, chatMessage(models.MSG, "", fmt.Sprintf("No such user: %s", to)))
+	case models.TOPIC:
+		if !c.user.Topic {
+			c.send(models.ChatMessageType, chatMessage(models.MSG, "", "No permission to change topic"))
+			return
+		}
+		s.topic = string(cm.Arg1)
+		s.broadcast(models.ChatMessageType, chatMessage(models.TOPIC, c.name, s.topic))
+	case models.ADMIN:
+		if !c.user.Admin {
+			c.send(models.ChatMessageType, chatMessage(models.MSG, "", "No permission for admin commands"))
+			return
+		}
+		s.admin(c, string(cm.Arg1))
+	}
+}
+
+// admin handles ADMIN commands "bpm N" and "bpi N"
+func (s *Server) admin(c *client, command string) {
+	var name string
+	var value int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(command, "/"), "%s %d", &name, &value); err != nil {
+		c.send(models.ChatMessageType, chatMessage(models.MSG, "", "Admin commands: bpm N, bpi N"))
+		return
+	}
+
+	switch strings.ToLower(name) {
+	case "bpm":
+		if value < 40 || value > 400 {
+			c.send(models.ChatMessageType, chatMessage(models.MSG, "", "BPM must be in range 40-400"))
+			return
+		}
+		s.bpm = uint16(value)
+	case "bpi":
+		if value < 2 || value > 1024 {
+			c.send(models.ChatMessageType, chatMessage(models.MSG, "", "BPI must be in range 2-1024"))
+			return
+		}
+		s.bpi = uint16(value)
+	default:
+		c.send(models.ChatMessageType, chatMessage(models.MSG, "", "Admin commands: bpm N, bpi N"))
+		return
+	}
+
+	s.broadcast(models.ServerConfigChangeNotifyType, &models.ServerConfigChangeNotify{BPM: s.bpm, BPI: s.bpi})
+	s.broadcast(models.ChatMessageType, chatMessage(models.MSG, "", fmt.Sprintf("%s sets %s to %d", c.name, strings.ToUpper(name), value)))
+}
+
+func (s *Server) broadcast(msgType uint8, payload models.Marshaler) {
+	for c := range s.clients {
+		c.send(msgType, payload)
+	}
+}
+
+func (s *Server) broadcastUserCount() {
+	s.broadcast(models.ChatMessageType, chatMessage(models.USERCOUNT, fmt.Sprint(len(s.clients)), fmt.Sprint(s.conf.MaxUsers)))
+}
+
+func chatMessage(command string, args ...string) *models.ChatMessage {
+	cm := &models.ChatMessage{Command: []byte(command)}
+	for i, arg := range []*[]byte{&cm.Arg1, &cm.Arg2, &cm.Arg3, &cm.Arg4} {
+		if i < len(args) {
+			*arg = []byte(args[i])
+		}
+	}
+
+	return cm
+}
+
+func frame(msgType uint8, payload models.Marshaler) ([]byte, error) {
+	nm := models.NewNetMessage(msgType)
+	nm.OutPayload = payload
+
+	return nm.Marshal()
+}
+
+// maskAddr hides last part of IPv4 address like NINJAM server does: 127.0.0.x
+func maskAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "x"
+	}
+
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		return fmt.Sprintf("%d.%d.%d.x", ip[0], ip[1], ip[2])
+	}
+
+	return host
+}
@@ -0,0 +1,259 @@
+package ninjam_server
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type testServer struct {
+	*Server
+	port string
+}
+
+func startServer(t *testing.T, conf Config) (*testServer, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := NewServer(conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, s.Serve(ctx, listener))
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	return &testServer{Server: s, port: port}, func() {
+		cancel()
+		<-done
+	}
+}
+
+type testBot struct {
+	*ninjam_bot.NinJamBot
+	events <-chan ninjam_bot.Event
+	done   chan struct{}
+}
+
+func connectBot(t *testing.T, s *testServer, name, password string, anonymous bool) *testBot {
+	bot := &testBot{
+		NinJamBot: ninjam_bot.NewNinJamBot("127.0.0.1", s.port, name, password, anonymous),
+		done:      make(chan struct{}),
+	}
+	bot.ReconnectPolicy(ninjam_bot.ReconnectPolicy{InitialDelay: time.Hour})
+	bot.events = bot.Subscribe()
+
+	go func() {
+		defer close(bot.done)
+		bot.Connect(context.Background())
+	}()
+
+	return bot
+}
+
+func (b *testBot) stop() {
+	b.Stop()
+	<-b.done
+}
+
+// wait returns the first event matching the condition, skipping others
+func (b *testBot) wait(t *testing.T, match func(ninjam_bot.Event) bool) ninjam_bot.Event {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case event := <-b.events:
+			if match(event) {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("event was not received")
+			return nil
+		}
+	}
+}
+
+func (b *testBot) waitAuth(t *testing.T) ninjam_bot.AuthEvent {
+	return b.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.AuthEvent)
+		return ok
+	}).(ninjam_bot.AuthEvent)
+}
+
+// waitChat waits for the chat message, server's own messages come with empty Name
+func (b *testBot) waitChat(t *testing.T, message models.Message) {
+	b.wait(t, func(e ninjam_bot.Event) bool {
+		chat, ok := e.(ninjam_bot.ChatEvent)
+		return ok && chat.Message == message
+	})
+}
+
+func TestServer_Login(t *testing.T) {
+	s, stop := startServer(t, Config{
+		Anonymous: false,
+		Users:     []User{{Name: "vasya", Password: "secret"}},
+	})
+	defer stop()
+
+	vasya := connectBot(t, s, "vasya", "secret", false)
+	defer vasya.stop()
+
+	auth := vasya.waitAuth(t)
+	assert.True(t, auth.Success)
+	assert.Equal(t, "vasya", auth.Message)
+	assert.Equal(t, uint8(defaultMaxChannels), auth.MaxChannels)
+
+	assert.Equal(t, ninjam_bot.ConfigChangeEvent{BPM: defaultBPM, BPI: defaultBPI}, vasya.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.ConfigChangeEvent)
+		return ok
+	}))
+
+	wrong := connectBot(t, s, "vasya", "wrong", false)
+	defer wrong.stop()
+	assert.Equal(t, ninjam_bot.AuthEvent{Message: errInvalidLogin.Error()}, wrong.waitAuth(t))
+
+	anonymous := connectBot(t, s, "petya", "", true)
+	defer anonymous.stop()
+	assert.Equal(t, ninjam_bot.AuthEvent{Message: errAnonymousDenied.Error()}, anonymous.waitAuth(t))
+
+	assert.Equal(t, []string{"vasya"}, s.Users())
+}
+
+func TestServer_UsersAndChat(t *testing.T) {
+	s, stop := startServer(t, Config{Anonymous: true, Topic: "welcome"})
+	defer stop()
+
+	vasya := connectBot(t, s, "vasya", "", true)
+	defer vasya.stop()
+	assert.Equal(t, "vasya@127.0.0.x", vasya.waitAuth(t).Message)
+
+	topic := vasya.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.TopicEvent)
+		return ok
+	}).(ninjam_bot.TopicEvent)
+	assert.Equal(t, "welcome", topic.Topic.Text)
+
+	vasya.ChannelInit("guitar")
+
+	petya := connectBot(t, s, "petya", "", true)
+	petya.waitAuth(t)
+
+	// новый пользователь получает список каналов, остальные - JOIN
+	joined := petya.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.UserJoinedEvent)
+		return ok
+	}).(ninjam_bot.UserJoinedEvent)
+	assert.Equal(t, "vasya@127.0.0.x (guitar)", joined.User.String())
+	vasya.waitChat(t, models.Message{Type: models.JOIN, Name: "petya@127.0.0.x"})
+
+	vasya.SendMessage("hello")
+	petya.waitChat(t, models.Message{Type: models.MSG, Name: "vasya@127.0.0.x", Text: "hello"})
+
+	vasya.SendPrivateMessage("PETYA@127.0.0.x", "psst")
+	pm := petya.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.PrivateMessageEvent)
+		return ok
+	}).(ninjam_bot.PrivateMessageEvent)
+	assert.Equal(t, models.PrivateMessage{From: "vasya@127.0.0.x", Text: "psst"}, pm.Message)
+
+	// anonymous user can't change topic
+	vasya.SetTopic("new topic")
+	vasya.waitChat(t, models.Message{Type: models.MSG, Text: "No permission to change topic"})
+
+	petya.stop()
+	vasya.waitChat(t, models.Message{Type: models.PART, Name: "petya@127.0.0.x"})
+}
+
+func TestServer_Admin(t *testing.T) {
+	s, stop := startServer(t, Config{
+		Users: []User{{Name: "admin", Password: "secret", Topic: true, Admin: true}},
+	})
+	defer stop()
+
+	admin := connectBot(t, s, "admin", "secret", false)
+	defer admin.stop()
+	admin.waitAuth(t)
+
+	admin.SetTopic("jam tonight")
+	topic := admin.wait(t, func(e ninjam_bot.Event) bool {
+		event, ok := e.(ninjam_bot.TopicEvent)
+		return ok && event.Topic.By != ""
+	}).(ninjam_bot.TopicEvent)
+	assert.Equal(t, models.Topic{By: "admin", Text: "jam tonight"}, topic.Topic)
+
+	admin.SendAdminMessage("bpm 140")
+	assert.Equal(t, ninjam_bot.ConfigChangeEvent{BPM: 140, BPI: defaultBPI}, admin.wait(t, func(e ninjam_bot.Event) bool {
+		event, ok := e.(ninjam_bot.ConfigChangeEvent)
+		return ok && event.BPM == 140
+	}))
+	admin.waitChat(t, models.Message{Type: models.MSG, Text: "admin sets BPM to 140"})
+
+	admin.SendAdminMessage("bpm 1000")
+	admin.waitChat(t, models.Message{Type: models.MSG, Text: "BPM must be in range 40-400"})
+}
+
+func TestServer_Intervals(t *testing.T) {
+	s, stop := startServer(t, Config{Anonymous: true})
+	defer stop()
+
+	vasya := connectBot(t, s, "vasya", "", true)
+	defer vasya.stop()
+	vasya.waitAuth(t)
+
+	petya := connectBot(t, s, "petya", "", true)
+	defer petya.stop()
+	petya.waitAuth(t)
+
+	vasya.ChannelInit("guitar")
+	petya.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.UserJoinedEvent)
+		return ok
+	})
+
+	petya.SubscribeChannels("vasya@127.0.0.x", models.AllChannels)
+
+	// подписка доходит до сервера асинхронно, шлём интервалы, пока petya их не получит
+	guid := [16]byte{1, 2, 3}
+	received := make(chan *models.ServerDownloadIntervalBegin, 1)
+	go func() {
+		event := petya.wait(t, func(e ninjam_bot.Event) bool {
+			_, ok := e.(ninjam_bot.IntervalBeginEvent)
+			return ok
+		})
+		received <- event.(ninjam_bot.IntervalBeginEvent).Interval
+	}()
+
+	var begin *models.ServerDownloadIntervalBegin
+	for begin == nil {
+		vasya.IntervalBegin(guid, 0)
+		select {
+		case begin = <-received:
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+
+	assert.Equal(t, guid, begin.GUID)
+	assert.Equal(t, "vasya@127.0.0.x", string(begin.Username))
+	assert.Equal(t, uint8(0), begin.ChannelIndex)
+
+	vasya.IntervalWrite(guid, []byte("ogg data"), 1)
+	write := petya.wait(t, func(e ninjam_bot.Event) bool {
+		_, ok := e.(ninjam_bot.IntervalWriteEvent)
+		return ok
+	}).(ninjam_bot.IntervalWriteEvent)
+	assert.Equal(t, guid, write.Interval.GUID)
+	assert.Equal(t, []byte("ogg data"), write.Interval.AudioData)
+	assert.True(t, write.Interval.IsLast())
+}
+
+func TestMaskAddr(t *testing.T) {
+	assert.Equal(t, "192.168.1.x", maskAddr(&net.TCPAddr{IP: net.ParseIP("192.168.1.15"), Port: 2049}))
+	assert.Equal(t, "::1", maskAddr(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 2049}))
+}
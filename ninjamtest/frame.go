@@ -0,0 +1,115 @@
+package ninjamtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+)
+
+// Frame is one NINJAM protocol message
+type Frame struct {
+	Type    uint8
+	Payload []byte
+}
+
+// Bytes returns frame as it is sent over network: type, payload length and payload
+func (f Frame) Bytes() []byte {
+	data := make([]byte, 5, 5+len(f.Payload))
+	data[0] = f.Type
+	binary.LittleEndian.PutUint32(data[1:], uint32(len(f.Payload)))
+
+	return append(data, f.Payload...)
+}
+
+// ChatArgs returns command and arguments of ChatMessage frame without trailing empty arguments
+func (f Frame) ChatArgs() []string {
+	args := make([]string, 0, 5)
+	for _, arg := range bytes.Split(bytes.TrimSuffix(f.Payload, []byte{0}), []byte{0}) {
+		args = append(args, string(arg))
+	}
+
+	for len(args) > 1 && args[len(args)-1] == "" {
+		args = args[:len(args)-1]
+	}
+
+	return args
+}
+
+// NewFrame marshals payload into frame, it panics on marshal error, the payloads of models never return it
+func NewFrame(msgType uint8, payload models.Marshaler) Frame {
+	data, err := payload.Marshal()
+	if err != nil {
+		panic(fmt.Sprintf("ninjamtest: %v", err))
+	}
+
+	return Frame{Type: msgType, Payload: data}
+}
+
+// ConfigChangeNotify returns ServerConfigChangeNotify frame
+func ConfigChangeNotify(bpm, bpi uint16) Frame {
+	return NewFrame(models.ServerConfigChangeNotifyType, &models.ServerConfigChangeNotify{BPM: bpm, BPI: bpi})
+}
+
+// UserInfoChangeNotify returns ServerUserInfoChangeNotify frame
+func UserInfoChangeNotify(userInfos ...models.UserInfo) Frame {
+	return NewFrame(models.ServerUserInfoChangeNotifyType, &models.ServerUserInfoChangeNotify{UserInfos: userInfos})
+}
+
+// UserJoined returns ServerUserInfoChangeNotify frame with one active user's channel
+func UserJoined(name string, channelIndex uint8, channelName string) Frame {
+	return UserInfoChangeNotify(models.UserInfo{
+		Active:       1,
+		ChannelIndex: channelIndex,
+		Name:         []byte(name),
+		ChannelName:  []byte(channelName),
+	})
+}
+
+// UserLeft returns ServerUserInfoChangeNotify frame removing user's channel
+func UserLeft(name string, channelIndex uint8) Frame {
+	return UserInfoChangeNotify(models.UserInfo{
+		ChannelIndex: channelIndex,
+		Name:         []byte(name),
+	})
+}
+
+// ChatMessage returns ChatMessage frame, e.g. ChatMessage(models.MSG, "Vasya", "hello")
+func ChatMessage(command string, args ...string) Frame {
+	cm := &models.ChatMessage{Command: []byte(command)}
+	for i, arg := range []*[]byte{&cm.Arg1, &cm.Arg2, &cm.Arg3, &cm.Arg4} {
+		if i < len(args) {
+			*arg = []byte(args[i])
+		}
+	}
+
+	return NewFrame(models.ChatMessageType, cm)
+}
+
+func authChallenge(challenge [8]byte, keepAlive uint8, license string) Frame {
+	sac := &models.ServerAuthChallenge{
+		Challenge: challenge,
+		// keepalive interval in seconds is kept in bits 8-15 of server capabilities
+		ServerCapabilities: uint32(keepAlive) << 8,
+		ProtocolVersion:    0x00020000,
+	}
+	// bit 0 - client must accept license agreement
+	if license != "" {
+		sac.ServerCapabilities |= 1
+		sac.LicenseAgreement = []byte(license)
+	}
+
+	return NewFrame(models.ServerAuthChallengeType, sac)
+}
+
+func authReply(success bool, message string, maxChannels uint8) Frame {
+	reply := &models.ServerAuthReply{
+		ErrorMessage: []byte(message),
+		MaxChannels:  maxChannels,
+	}
+	if success {
+		reply.Flag = 1
+	}
+
+	return NewFrame(models.ServerAuthReplyType, reply)
+}
@@ -0,0 +1,113 @@
+package ninjamtest
+
+import (
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"time"
+)
+
+// DefaultInboxTimeout - how long Inbox waits for a frame
+const DefaultInboxTimeout = time.Second * 5
+
+// Inbox reads frames sent by clients by type. Chat messages go to server independently of intervals,
+// so frames of other types are kept until they are asked for
+type Inbox struct {
+	Timeout time.Duration
+
+	server  *Server
+	pending []Frame
+}
+
+// NewInbox returns inbox of server's frames, server must not be read by others meanwhile
+func NewInbox(server *Server) *Inbox {
+	return &Inbox{
+		Timeout: DefaultInboxTimeout,
+		server:  server,
+	}
+}
+
+// Next returns the next frame of msgType
+func (i *Inbox) Next(msgType uint8) (Frame, error) {
+	for n, f := range i.pending {
+		if f.Type == msgType {
+			i.pending = append(i.pending[:n], i.pending[n+1:]...)
+			return f, nil
+		}
+	}
+
+	for {
+		f, err := i.server.Next(i.Timeout)
+		if err != nil {
+			return Frame{}, fmt.Errorf("ninjamtest: no %s from clients", models.MessageTypeName(msgType))
+		}
+		if f.Type == msgType {
+			return f, nil
+		}
+		i.pending = append(i.pending, f)
+	}
+}
+
+// Pending returns received frames nobody has asked for yet
+func (i *Inbox) Pending() []Frame {
+	return i.pending
+}
+
+// Chat returns text of the next MSG chat message
+func (i *Inbox) Chat() (string, error) {
+	f, err := i.Next(models.ChatMessageType)
+	if err != nil {
+		return "", err
+	}
+
+	args := f.ChatArgs()
+	if len(args) != 2 || args[0] != models.MSG {
+		return "", fmt.Errorf("ninjamtest: chat message %q is not %s", args, models.MSG)
+	}
+
+	return args[1], nil
+}
+
+// Channels returns channels of the next ClientSetChannelInfo
+func (i *Inbox) Channels() ([]models.ChannelInfo, error) {
+	f, err := i.Next(models.ClientSetChannelInfoType)
+	if err != nil {
+		return nil, err
+	}
+
+	channelInfo := &models.ClientSetChannelInfo{}
+	if err := channelInfo.Unmarshal(f.Payload); err != nil {
+		return nil, err
+	}
+
+	return channelInfo.Channels, nil
+}
+
+// IntervalBegin returns the next ClientUploadIntervalBegin
+func (i *Inbox) IntervalBegin() (*models.ClientUploadIntervalBegin, error) {
+	f, err := i.Next(models.ClientUploadIntervalBeginType)
+	if err != nil {
+		return nil, err
+	}
+
+	begin := &models.ClientUploadIntervalBegin{}
+	if err := begin.Unmarshal(f.Payload); err != nil {
+		return nil, err
+	}
+
+	return begin, nil
+}
+
+// IntervalWrite returns the next ClientUploadIntervalWrite
+func (i *Inbox) IntervalWrite() (*models.ClientUploadIntervalWrite, error) {
+	f, err := i.Next(models.ClientUploadIntervalWriteType)
+	if err != nil {
+		return nil, err
+	}
+
+	write := &models.ClientUploadIntervalWrite{}
+	if err := write.Unmarshal(f.Payload); err != nil {
+		return nil, err
+	}
+
+	return write, nil
+}
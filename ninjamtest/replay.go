@@ -0,0 +1,49 @@
+package ninjamtest
+
+import (
+	"github.com/ayvan/ninjam-chatbot/capture"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"io"
+)
+
+// ReplayFrames reads capture file and returns frames the captured client received from server.
+// Login handshake and keepalives are skipped, Server does them by itself
+func ReplayFrames(r io.Reader) ([]Frame, error) {
+	reader, err := capture.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []Frame
+	for _, record := range records {
+		if record.Direction != capture.In {
+			continue
+		}
+
+		switch record.Type {
+		case models.ServerAuthChallengeType, models.ServerAuthReplyType, models.ClientKeepaliveType:
+			continue
+		}
+
+		frames = append(frames, Frame{Type: record.Type, Payload: record.Payload})
+	}
+
+	return frames, nil
+}
+
+// Replay makes server send frames from capture file to every client after login, see ReplayFrames
+func (s *Server) Replay(r io.Reader) error {
+	frames, err := ReplayFrames(r)
+	if err != nil {
+		return err
+	}
+
+	s.OnLogin(frames...)
+
+	return nil
+}
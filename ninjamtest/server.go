@@ -0,0 +1,371 @@
+// Package ninjamtest provides an in-process NINJAM server for tests and local development.
+// It runs the real login handshake, pushes scripted frames to clients and records what they sent.
+// Sound provides OGG Vorbis audio shared by tests of audio packages.
+package ninjamtest
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultKeepAlive - keepalive interval in seconds the server asks clients for
+	DefaultKeepAlive uint8 = 1
+	// DefaultMaxChannels - channels per user allowed by server
+	DefaultMaxChannels uint8 = 2
+
+	anonymousPrefix = "anonymous:"
+)
+
+// Server is a NINJAM server listening on local TCP port
+type Server struct {
+	listener net.Listener
+
+	mutex       sync.Mutex
+	users       map[string]string
+	anonymous   bool
+	keepAlive   uint8
+	maxChannels uint8
+	license     string
+	script      []Frame
+	clients     map[*client]struct{}
+	closed      bool
+
+	received chan Frame
+	logins   chan string
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type client struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	loggedIn   bool
+}
+
+func (c *client) write(frames ...Frame) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	for _, f := range frames {
+		if _, err := c.conn.Write(f.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewServer starts server on 127.0.0.1 random port, anonymous logins are allowed.
+// It panics if it can't listen, like httptest.NewServer
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ninjamtest: failed to listen on a port: %v", err))
+	}
+
+	s := &Server{
+		listener:    listener,
+		users:       make(map[string]string),
+		anonymous:   true,
+		keepAlive:   DefaultKeepAlive,
+		maxChannels: DefaultMaxChannels,
+		clients:     make(map[*client]struct{}),
+		received:    make(chan Frame, 1000),
+		logins:      make(chan string, 100),
+		done:        make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// Host returns server host
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+// Port returns server port
+func (s *Server) Port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+// AddUser registers user with password, password hash sent by client is checked on login
+func (s *Server) AddUser(name, password string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.users[name] = password
+}
+
+// Anonymous allows or denies "anonymous:name" logins
+func (s *Server) Anonymous(allowed bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.anonymous = allowed
+}
+
+// KeepAlive sets keepalive interval in seconds sent to clients in auth challenge
+func (s *Server) KeepAlive(seconds uint8) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.keepAlive = seconds
+}
+
+// License makes server require accepting license agreement with text, empty text disables it.
+// Clients which don't accept it get "license not accepted" auth reply
+func (s *Server) License(text string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.license = text
+}
+
+// OnLogin sets frames pushed to every client right after successful login
+func (s *Server) OnLogin(frames ...Frame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.script = frames
+}
+
+// Push sends frames to all logged in clients
+func (s *Server) Push(frames ...Frame) {
+	for _, c := range s.loggedIn() {
+		c.write(frames...)
+	}
+}
+
+// DropClients closes connections of all clients, server keeps listening
+func (s *Server) DropClients() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Received returns frames sent by logged in clients, keepalives are skipped
+func (s *Server) Received() <-chan Frame {
+	return s.received
+}
+
+// Next waits for the next frame sent by clients
+func (s *Server) Next(timeout time.Duration) (Frame, error) {
+	select {
+	case f := <-s.received:
+		return f, nil
+	case <-time.After(timeout):
+		return Frame{}, errors.New("ninjamtest: no frames from clients")
+	}
+}
+
+// Logins returns names of users logged in successfully
+func (s *Server) Logins() <-chan string {
+	return s.logins
+}
+
+// WaitLogin waits for the next successful login and returns user name
+func (s *Server) WaitLogin(timeout time.Duration) (string, error) {
+	select {
+	case name := <-s.logins:
+		return name, nil
+	case <-time.After(timeout):
+		return "", errors.New("ninjamtest: no logins")
+	}
+}
+
+// Close stops listening, closes all connections and waits for server goroutines
+func (s *Server) Close() {
+	s.mutex.Lock()
+	if !s.closed {
+		close(s.done)
+	}
+	s.closed = true
+	s.listener.Close()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *Server) loggedIn() []*client {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		if c.loggedIn {
+			clients = append(clients, c)
+		}
+	}
+
+	return clients
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{conn: conn}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.clients[c] = struct{}{}
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				conn.Close()
+
+				s.mutex.Lock()
+				delete(s.clients, c)
+				s.mutex.Unlock()
+			}()
+
+			s.handle(c)
+		}()
+	}
+}
+
+func (s *Server) handle(c *client) {
+	var challenge [8]byte
+	rand.Read(challenge[:])
+
+	s.mutex.Lock()
+	keepAlive, maxChannels, license := s.keepAlive, s.maxChannels, s.license
+	s.mutex.Unlock()
+
+	if err := c.write(authChallenge(challenge, keepAlive, license)); err != nil {
+		return
+	}
+
+	f, err := readFrame(c.conn)
+	if err != nil || f.Type != models.ClientAuthUserType {
+		return
+	}
+
+	name, err := s.checkLogin(f.Payload, challenge)
+	if err != nil {
+		c.write(authReply(false, err.Error(), 0))
+		return
+	}
+
+	s.mutex.Lock()
+	script := s.script
+	s.mutex.Unlock()
+
+	if err := c.write(append([]Frame{authReply(true, name, maxChannels)}, script...)...); err != nil {
+		return
+	}
+
+	// Push and WaitLogin see the client only after the whole script is sent to it
+	s.mutex.Lock()
+	c.loggedIn = true
+	s.mutex.Unlock()
+
+	select {
+	case s.logins <- name:
+	case <-s.done:
+		return
+	}
+
+	for {
+		f, err := readFrame(c.conn)
+		if err != nil {
+			return
+		}
+
+		if f.Type == models.ClientKeepaliveType {
+			continue
+		}
+
+		select {
+		case s.received <- f:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// checkLogin parses ClientAuthUser and checks password hash, it returns user name
+func (s *Server) checkLogin(payload []byte, challenge [8]byte) (string, error) {
+	auth := &models.ClientAuthUser{}
+	if err := auth.Unmarshal(payload); err != nil {
+		return "", errors.New("invalid login/password")
+	}
+	name := string(auth.Username)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.license != "" && !auth.HasAgreement() {
+		return "", errors.New("license not accepted")
+	}
+
+	if strings.HasPrefix(name, anonymousPrefix) {
+		if !s.anonymous {
+			return "", errors.New("anonymous users not allowed")
+		}
+
+		return strings.TrimPrefix(name, anonymousPrefix), nil
+	}
+
+	password, ok := s.users[name]
+	if !ok || !auth.CheckPassword(password, challenge) {
+		return "", errors.New("invalid login/password")
+	}
+
+	return name, nil
+}
+
+func readFrame(r io.Reader) (Frame, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Frame{}, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[1:])
+	if length > models.DefaultMaxFrameSize {
+		return Frame{}, models.ErrFrameTooLarge
+	}
+
+	f := Frame{
+		Type:    header[0],
+		Payload: make([]byte, length),
+	}
+
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return Frame{}, err
+	}
+
+	return f, nil
+}
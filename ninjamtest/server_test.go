@@ -0,0 +1,161 @@
+package ninjamtest
+
+import (
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	conn   net.Conn
+	reader *models.FrameReader
+}
+
+func dial(t *testing.T, s *Server) *testClient {
+	conn, err := net.Dial("tcp", net.JoinHostPort(s.Host(), s.Port()))
+	require.NoError(t, err)
+
+	return &testClient{
+		conn:   conn,
+		reader: models.NewFrameReader(conn, 0),
+	}
+}
+
+func (c *testClient) read(t *testing.T) *models.NetMessage {
+	c.conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	nm, err := c.reader.ReadFrame()
+	require.NoError(t, err)
+
+	return nm
+}
+
+func (c *testClient) login(t *testing.T, name, password string) *models.ServerAuthReply {
+	nm := c.read(t)
+	require.Equal(t, models.ServerAuthChallengeType, nm.Type)
+
+	challenge := nm.InPayload.(*models.ServerAuthChallenge)
+	keepAlive, err := challenge.KeepAliveInterval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, keepAlive)
+
+	auth := models.NewNetMessage(models.ClientAuthUserType)
+	auth.OutPayload = models.NewClientAuthUser(name, password, false, challenge.Challenge)
+	data, err := auth.Marshal()
+	require.NoError(t, err)
+
+	_, err = c.conn.Write(data)
+	require.NoError(t, err)
+
+	nm = c.read(t)
+	require.Equal(t, models.ServerAuthReplyType, nm.Type)
+
+	return nm.InPayload.(*models.ServerAuthReply)
+}
+
+func TestServer_Login(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddUser("vasya", "secret")
+
+	c := dial(t, s)
+	defer c.conn.Close()
+
+	reply := c.login(t, "vasya", "secret")
+	assert.Equal(t, uint8(1), reply.Flag)
+	assert.Equal(t, "vasya", string(reply.ErrorMessage))
+	assert.Equal(t, DefaultMaxChannels, reply.MaxChannels)
+
+	name, err := s.WaitLogin(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "vasya", name)
+}
+
+func TestServer_LoginFailed(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddUser("vasya", "secret")
+	s.Anonymous(false)
+
+	c := dial(t, s)
+	reply := c.login(t, "vasya", "wrong")
+	c.conn.Close()
+	assert.Equal(t, uint8(0), reply.Flag)
+	assert.Equal(t, "invalid login/password", string(reply.ErrorMessage))
+
+	c = dial(t, s)
+	reply = c.login(t, "anonymous:petya", "")
+	c.conn.Close()
+	assert.Equal(t, uint8(0), reply.Flag)
+	assert.Equal(t, "anonymous users not allowed", string(reply.ErrorMessage))
+
+	_, err := s.WaitLogin(time.Millisecond * 100)
+	assert.Error(t, err)
+}
+
+func TestServer_Script(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.OnLogin(
+		ConfigChangeNotify(120, 16),
+		UserJoined("Vasya", 0, "guitar"),
+	)
+
+	c := dial(t, s)
+	defer c.conn.Close()
+
+	reply := c.login(t, "anonymous:bot", "")
+	assert.Equal(t, "bot", string(reply.ErrorMessage))
+
+	config := c.read(t).InPayload.(*models.ServerConfigChangeNotify)
+	assert.Equal(t, uint16(120), config.BPM)
+	assert.Equal(t, uint16(16), config.BPI)
+
+	userInfos := c.read(t).InPayload.(*models.ServerUserInfoChangeNotify).UserInfos
+	if assert.Len(t, userInfos, 1) {
+		assert.True(t, userInfos[0].IsActive())
+		assert.Equal(t, "Vasya", string(userInfos[0].Name))
+		assert.Equal(t, "guitar", string(userInfos[0].ChannelName))
+	}
+
+	_, err := s.WaitLogin(time.Second)
+	require.NoError(t, err)
+
+	s.Push(ChatMessage(models.MSG, "Vasya", "hello"))
+
+	chat := c.read(t).InPayload.(*models.ChatMessage)
+	assert.Equal(t, "Vasya", string(chat.Arg1))
+	assert.Equal(t, "hello", string(chat.Arg2))
+
+	// keepalive is not recorded
+	c.conn.Write(Frame{Type: models.ClientKeepaliveType}.Bytes())
+	c.conn.Write(ChatMessage(models.MSG, "hi").Bytes())
+
+	f, err := s.Next(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, models.ChatMessageType, f.Type)
+	assert.Equal(t, []string{models.MSG, "hi"}, f.ChatArgs())
+}
+
+func TestServer_DropClients(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	c := dial(t, s)
+	defer c.conn.Close()
+
+	c.login(t, "anonymous:bot", "")
+	_, err := s.WaitLogin(time.Second)
+	require.NoError(t, err)
+
+	s.DropClients()
+
+	c.conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	_, err = c.reader.ReadFrame()
+	assert.Error(t, err)
+}
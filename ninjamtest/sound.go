@@ -0,0 +1,13 @@
+package ninjamtest
+
+import (
+	_ "embed"
+)
+
+//go:embed testdata/sound.ogg
+var sound []byte
+
+// Sound returns 1 second of mono 44100 Hz OGG Vorbis, every call returns new copy
+func Sound() []byte {
+	return append([]byte(nil), sound...)
+}
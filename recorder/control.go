@@ -0,0 +1,67 @@
+package recorder
+
+import (
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"strings"
+)
+
+// Control starts and stops recorders of several servers by chat command models.RECORD,
+// it's a command handler of router
+type Control struct {
+	names     []string
+	recorders map[string]*Recorder
+}
+
+// NewControl creates control without recorders, its replies tell that recording is not set up
+func NewControl() *Control {
+	return &Control{recorders: make(map[string]*Recorder)}
+}
+
+// Add adds recorder of server, name is shown in replies, servers are reported in the order they are added
+func (c *Control) Add(name string, r *Recorder) {
+	if _, ok := c.recorders[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.recorders[name] = r
+}
+
+// HandleCommand starts recording of all servers when msg.Text is "start", otherwise stops it,
+// it returns report for chat
+func (c *Control) HandleCommand(msg models.Message) string {
+	start := msg.Text == "start"
+	lines := make([]string, 0, len(c.names))
+
+	for _, name := range c.names {
+		rec := c.recorders[name]
+
+		if start {
+			dir, err := rec.Start()
+			switch err {
+			case nil:
+				lines = append(lines, fmt.Sprintf("Запись джем-сервера %s начата: %s", name, dir))
+			case ErrRecording:
+				lines = append(lines, fmt.Sprintf("Джем-сервер %s уже записывается: %s", name, dir))
+			default:
+				lines = append(lines, fmt.Sprintf("Не удалось начать запись джем-сервера %s: %s", name, err))
+			}
+			continue
+		}
+
+		dir, _ := rec.Recording()
+		switch err := rec.Stop(); err {
+		case nil:
+			lines = append(lines, fmt.Sprintf("Запись джем-сервера %s остановлена: %s", name, dir))
+		case ErrNotRecording:
+			lines = append(lines, fmt.Sprintf("Джем-сервер %s не записывается", name))
+		default:
+			lines = append(lines, fmt.Sprintf("Ошибка при остановке записи джем-сервера %s: %s", name, err))
+		}
+	}
+
+	if len(lines) == 0 {
+		return "Запись не настроена ни для одного джем-сервера"
+	}
+
+	return strings.Join(lines, "\n")
+}
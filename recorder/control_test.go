@@ -0,0 +1,40 @@
+package recorder
+
+import (
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	record := func(command string) models.Message {
+		return models.Message{Type: models.RECORD, Name: "petya", Text: command}
+	}
+
+	c := NewControl()
+	assert.Equal(t, "Запись не настроена ни для одного джем-сервера", c.HandleCommand(record("start")))
+
+	rec := New(intervals.New(ninjam_bot.New("127.0.0.1", "2049")), dir)
+	c.Add("127.0.0.1:2049", rec)
+
+	reply := c.HandleCommand(record("start"))
+	session, ok := rec.Recording()
+	require.True(t, ok)
+	assert.Equal(t, "Запись джем-сервера 127.0.0.1:2049 начата: "+session, reply)
+	assert.Equal(t, "Джем-сервер 127.0.0.1:2049 уже записывается: "+session, c.HandleCommand(record("start")))
+
+	assert.Equal(t, "Запись джем-сервера 127.0.0.1:2049 остановлена: "+session, c.HandleCommand(record("stop")))
+	assert.FileExists(t, filepath.Join(session, LogName))
+
+	assert.Equal(t, "Джем-сервер 127.0.0.1:2049 не записывается", c.HandleCommand(record("stop")))
+}
@@ -0,0 +1,298 @@
+// Package recorder saves audio of all users of NINJAM server to disk, every user's channel separately.
+//
+// Every recording is a session directory named by its start time. Intervals are stored the way ninjamsrv
+// stores them: <session>/<first hex digit of GUID>/<GUID>.ogg, and clipsort.log describes them:
+//
+//	channel "<user name>" <channel index> "<channel name>" <volume> <pan>
+//	interval <index> <BPM> <BPI>
+//	user <GUID> "<user name>" <channel index> "<channel name>"
+//	...
+//	end
+//
+// "channel" line is written for every user's channel at the start of recording and when server reports
+// changes of user's channels, volume is in 0.1 dB, pan is from -128 (left) to 127 (right).
+// "interval" line starts every interval which has audio, "user" lines list intervals of users' channels
+// which started in it. Interval positions are counted by bot's clock from the start of recording
+// and from every BPM/BPI change. Intervals come from intervals.Assembler and are saved when they are
+// downloaded completely, intervals unfinished when recording stops are not saved.
+package recorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LogName is the name of intervals log in session directory
+	LogName = "clipsort.log"
+
+	sessionNameFormat = "20060102_150405"
+)
+
+var (
+	// ErrRecording is returned by Start when recording is already started
+	ErrRecording = errors.New("recording is already started")
+	// ErrNotRecording is returned by Stop when recording is not started
+	ErrNotRecording = errors.New("recording is not started")
+)
+
+// Recorder writes intervals of all users of bot's server while recording is started
+type Recorder struct {
+	bot       *ninjam_bot.NinJamBot
+	intervals *intervals.Assembler
+	dir       string
+	events    <-chan intervals.Event
+	now       func() time.Time
+
+	mutex   sync.Mutex
+	session *session // nil when recording is not started
+	bpm     uint
+	bpi     uint
+	users   map[string]models.User
+}
+
+// session is one recording
+type session struct {
+	dir    string
+	log    *os.File
+	w      *bufio.Writer
+	opened time.Time
+
+	// интервалы считаются от начала записи и заново от каждой смены BPM/BPI
+	start       time.Time
+	startIndex  int
+	prevBPM     uint // tempo before the last change, intervals started before it are written with it
+	prevBPI     uint
+	lastIndex   int // index of the last "interval" line, -1 before the first one
+	writeFailed bool
+}
+
+// New creates recorder of assembler's server, sessions are created in dir.
+// It subscribes to assembler's events, so it must be created before bot connects
+func New(assembler *intervals.Assembler, dir string) *Recorder {
+	return &Recorder{
+		bot:       assembler.Bot(),
+		intervals: assembler,
+		dir:       dir,
+		events:    assembler.Subscribe(),
+		now:       time.Now,
+		users:     make(map[string]models.User),
+	}
+}
+
+// Run handles events until ctx is cancelled, then it stops recording
+func (r *Recorder) Run(ctx context.Context) {
+	defer r.intervals.Unsubscribe(r.events)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Stop(); err != nil && err != ErrNotRecording {
+				logrus.Errorf("Recorder %s:%s stop error: %s", r.bot.Host(), r.bot.Port(), err)
+			}
+			return
+		case event := <-r.events:
+			r.handle(event)
+		}
+	}
+}
+
+// Recording returns session directory and whether recording is started
+func (r *Recorder) Recording() (string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.session == nil {
+		return "", false
+	}
+
+	return r.session.dir, true
+}
+
+// Start creates new session directory and subscribes bot to all users' channels, it returns session directory.
+// It doesn't block on the bot, subscriptions are changed by the assembler
+func (r *Recorder) Start() (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.session != nil {
+		return r.session.dir, ErrRecording
+	}
+
+	now := r.now()
+	dir := filepath.Join(r.dir, now.Format(sessionNameFormat))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, LogName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	r.session = &session{
+		dir:       dir,
+		log:       log,
+		w:         bufio.NewWriter(log),
+		opened:    now,
+		start:     now,
+		lastIndex: -1,
+	}
+	r.intervals.Acquire()
+
+	// пользователи, зашедшие до создания рекордера, известны только боту
+	for _, user := range r.bot.Users() {
+		r.users[user.Name] = user
+	}
+	for _, user := range r.users {
+		r.writeChannels(r.session, user)
+	}
+
+	logrus.Infof("Recording %s:%s started: %s", r.bot.Host(), r.bot.Port(), dir)
+
+	return dir, nil
+}
+
+// Stop closes clipsort.log and unsubscribes bot from users' channels
+func (r *Recorder) Stop() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := r.session
+	if s == nil {
+		return ErrNotRecording
+	}
+	r.session = nil
+	r.intervals.Release()
+
+	fmt.Fprintln(s.w, "end")
+	err := s.w.Flush()
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+
+	logrus.Infof("Recording %s:%s stopped: %s", r.bot.Host(), r.bot.Port(), s.dir)
+
+	return err
+}
+
+func (r *Recorder) handle(event intervals.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch event := event.(type) {
+	case ninjam_bot.ConfigChangeEvent:
+		if event.BPM == r.bpm && event.BPI == r.bpi {
+			return
+		}
+		if r.session != nil {
+			now := r.now()
+			// первый BPM/BPI после старта записи номер интервала не меняет
+			if r.bpm != 0 {
+				r.session.startIndex = r.intervalIndex(r.session, now) + 1
+			}
+			r.session.start = now
+			r.session.prevBPM, r.session.prevBPI = r.bpm, r.bpi
+		}
+		r.bpm, r.bpi = event.BPM, event.BPI
+	case ninjam_bot.UserJoinedEvent:
+		r.users[event.User.Name] = event.User
+		if r.session != nil {
+			r.writeChannels(r.session, event.User)
+		}
+	case ninjam_bot.UserChannelsChangedEvent:
+		r.users[event.User.Name] = event.User
+		if r.session != nil {
+			r.writeChannels(r.session, event.User)
+		}
+	case ninjam_bot.UserLeftEvent:
+		delete(r.users, event.User.Name)
+	case *intervals.Interval:
+		if r.session != nil {
+			r.record(r.session, event)
+		}
+	case ninjam_bot.DisconnectEvent:
+		// пользователей сервер пришлёт заново после входа
+		r.users = make(map[string]models.User)
+	}
+}
+
+// intervalIndex returns index of the interval going at moment at
+func (r *Recorder) intervalIndex(s *session, at time.Time) int {
+	if r.bpm == 0 || r.bpi == 0 {
+		return s.startIndex
+	}
+	// интервал начался до смены темпа, а скачался после неё
+	if at.Before(s.start) {
+		return s.startIndex - 1
+	}
+
+	length := time.Minute * time.Duration(r.bpi) / time.Duration(r.bpm)
+
+	return s.startIndex + int(at.Sub(s.start)/length)
+}
+
+func (r *Recorder) record(s *session, interval *intervals.Interval) {
+	// интервал начался до старта записи
+	if interval.Started.Before(s.opened) {
+		return
+	}
+
+	guid := strings.ToUpper(hex.EncodeToString(interval.GUID[:]))
+	path := filepath.Join(s.dir, guid[:1], guid+".ogg")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.Errorf("Recorder %s:%s error: %s", r.bot.Host(), r.bot.Port(), err)
+		return
+	}
+
+	if err := ioutil.WriteFile(path, interval.Data, 0644); err != nil {
+		logrus.Errorf("Recorder %s:%s can't write %s: %s", r.bot.Host(), r.bot.Port(), path, err)
+		return
+	}
+
+	bpm, bpi := r.bpm, r.bpi
+	if interval.Started.Before(s.start) {
+		bpm, bpi = s.prevBPM, s.prevBPI
+	}
+
+	// интервалы скачиваются не по порядку начала, лог назад не идёт
+	index := r.intervalIndex(s, interval.Started)
+	if index > s.lastIndex {
+		s.lastIndex = index
+		fmt.Fprintf(s.w, "interval %d %d %d\n", index, bpm, bpi)
+	}
+
+	fmt.Fprintf(s.w, "user %s %q %d %q\n", guid, interval.User, interval.Channel.Index, interval.Channel.Name)
+
+	r.flushLog(s)
+}
+
+// writeChannels writes user's channels with volume and pan to clipsort.log
+func (r *Recorder) writeChannels(s *session, user models.User) {
+	for _, channel := range user.Channels {
+		fmt.Fprintf(s.w, "channel %q %d %q %d %d\n", user.Name, channel.Index, channel.Name, channel.Volume, channel.Pan)
+	}
+
+	r.flushLog(s)
+}
+
+func (r *Recorder) flushLog(s *session) {
+	if err := s.w.Flush(); err != nil && !s.writeFailed {
+		s.writeFailed = true
+		logrus.Errorf("Recorder %s:%s can't write %s: %s", r.bot.Host(), r.bot.Port(), LogName, err)
+	}
+}
@@ -0,0 +1,141 @@
+package recorder
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	guitar = models.User{Name: "Vasya@127.0.0.x", Channels: []models.Channel{{Index: 0, Name: "guitar", Volume: -30, Pan: -64}}}
+	start  = time.Date(2026, 10, 18, 20, 30, 0, 0, time.UTC)
+)
+
+func interval(guid byte, userName string, channel models.Channel, data string, started time.Time) *intervals.Interval {
+	return &intervals.Interval{GUID: [16]byte{guid, 1}, User: userName, Channel: channel, Data: []byte(data), Started: started}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestRecorder_Session(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := New(intervals.New(ninjam_bot.New("127.0.0.1", "2049")), dir)
+	now := start
+	r.now = func() time.Time { return now }
+
+	r.handle(ninjam_bot.ConfigChangeEvent{BPM: 120, BPI: 16})
+	r.handle(ninjam_bot.UserJoinedEvent{User: guitar})
+
+	_, err = r.Start()
+	require.NoError(t, err)
+	session, ok := r.Recording()
+	require.True(t, ok)
+	assert.Equal(t, filepath.Join(dir, "20261018_203000"), session)
+
+	_, err = r.Start()
+	assert.Equal(t, ErrRecording, err)
+
+	r.handle(interval(0xab, guitar.Name, guitar.Channels[0], "OggS", now))
+	r.handle(ninjam_bot.UserChannelsChangedEvent{User: models.User{Name: guitar.Name, Channels: []models.Channel{{Index: 0, Name: "guitar", Volume: 10}}}})
+	// интервал, начавшийся до записи, не записывается
+	r.handle(interval(0x12, guitar.Name, guitar.Channels[0], "OggS", now.Add(-time.Second)))
+
+	// второй интервал: 120 BPM, 16 BPI - 8 секунд, он скачан уже после смены темпа
+	now = now.Add(time.Second * 9)
+	second := interval(0xcd, guitar.Name, guitar.Channels[0], "Og", now)
+
+	// смена темпа начинает новый интервал
+	now = now.Add(time.Second)
+	r.handle(ninjam_bot.ConfigChangeEvent{BPM: 90, BPI: 16})
+	r.handle(second)
+	r.handle(interval(0xef, "Petya@127.0.0.x", models.Channel{Index: 1}, "OggS", now))
+
+	require.NoError(t, r.Stop())
+	assert.Equal(t, ErrNotRecording, r.Stop())
+
+	assert.Equal(t, "OggS", readFile(t, filepath.Join(session, "A", "AB010000000000000000000000000000.ogg")))
+	assert.Equal(t, "Og", readFile(t, filepath.Join(session, "C", "CD010000000000000000000000000000.ogg")))
+	_, err = os.Stat(filepath.Join(session, "1", "12010000000000000000000000000000.ogg"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "channel \"Vasya@127.0.0.x\" 0 \"guitar\" -30 -64\n"+
+		"interval 0 120 16\n"+
+		"user AB010000000000000000000000000000 \"Vasya@127.0.0.x\" 0 \"guitar\"\n"+
+		"channel \"Vasya@127.0.0.x\" 0 \"guitar\" 10 0\n"+
+		"interval 1 120 16\n"+
+		"user CD010000000000000000000000000000 \"Vasya@127.0.0.x\" 0 \"guitar\"\n"+
+		"interval 2 90 16\n"+
+		"user EF010000000000000000000000000000 \"Petya@127.0.0.x\" 1 \"\"\n"+
+		"end\n", readFile(t, filepath.Join(session, LogName)))
+}
+
+func TestRecorder_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := ninjamtest.NewServer()
+	defer server.Close()
+	server.OnLogin(
+		ninjamtest.ConfigChangeNotify(120, 16),
+		ninjamtest.UserJoined(guitar.Name, 0, "guitar"),
+	)
+
+	bot := ninjam_bot.New(server.Host(), server.Port())
+	assembler := intervals.New(bot)
+	r := New(assembler, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go assembler.Run(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(stopped)
+	}()
+	go bot.Connect(ctx)
+	defer bot.Stop()
+
+	_, err = server.WaitLogin(time.Second * 5)
+	require.NoError(t, err)
+
+	session, err := r.Start()
+	require.NoError(t, err)
+
+	f, err := server.Next(time.Second * 5)
+	require.NoError(t, err)
+	require.Equal(t, models.ClientSetUsermaskType, f.Type)
+	usermask := &models.ClientSetUsermask{}
+	require.NoError(t, usermask.Unmarshal(f.Payload))
+	assert.Equal(t, []models.UserMask{{Name: guitar.Name, ChannelMask: models.AllChannels}}, usermask.UserMasks)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 5):
+		t.Fatal("recorder was not stopped")
+	}
+
+	_, ok := r.Recording()
+	assert.False(t, ok)
+	// интервалы записываются в TestRecorder_Session, здесь проверяется только начало и конец сессии
+	log := readFile(t, filepath.Join(session, LogName))
+	assert.True(t, strings.HasPrefix(log, "channel \"Vasya@127.0.0.x\" 0 \"guitar\" 0 0\n"), log)
+	assert.True(t, strings.HasSuffix(log, "end\n"), log)
+}
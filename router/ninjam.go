@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"strings"
+)
+
+// NinJam is Server bridge of NINJAM bot: it turns bot's chat events into envelopes and sends
+// envelopes of other bridges to server's chat
+type NinJam struct {
+	bot      *ninjam_bot.NinJamBot
+	events   <-chan ninjam_bot.Event
+	incoming chan models.Envelope
+}
+
+// NewNinJam creates bridge of bot, it subscribes to bot's events, so it must be created before bot connects
+func NewNinJam(bot *ninjam_bot.NinJamBot) *NinJam {
+	return &NinJam{
+		bot:      bot,
+		events:   bot.Subscribe(),
+		incoming: make(chan models.Envelope, ninjam_bot.DefaultBufferSize),
+	}
+}
+
+// Bot returns bridged bot
+func (n *NinJam) Bot() *ninjam_bot.NinJamBot {
+	return n.bot
+}
+
+// Connect connects the bot and passes its chat to Incoming until the bot stops
+func (n *NinJam) Connect(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go n.pass(ctx)
+
+	return n.bot.Connect(ctx)
+}
+
+// Stop stops the bot
+func (n *NinJam) Stop() {
+	n.bot.Stop()
+}
+
+// FindUser looks up user of server
+func (n *NinJam) FindUser(name string) (models.User, bool) {
+	return n.bot.FindUser(name)
+}
+
+// Incoming returns MSG, JOIN and PART of other users, TOPIC, PRIVMSG sent to the bot and LICENSE
+func (n *NinJam) Incoming() <-chan models.Envelope {
+	return n.incoming
+}
+
+// Send sends envelope to server: MSG to chat, PRIVMSG to user To, TOPIC sets topic
+// and ADMIN is sent as admin command, other types are ignored
+func (n *NinJam) Send(envelope models.Envelope) {
+	switch envelope.Type {
+	case models.MSG:
+		n.bot.SendMessage(envelope.Text)
+	case models.PRIVMSG:
+		n.bot.SendPrivateMessage(envelope.To, envelope.Text)
+	case models.TOPIC:
+		n.bot.SetTopic(envelope.Text)
+	case models.ADMIN:
+		n.bot.SendAdminMessage(envelope.Text)
+	}
+}
+
+func (n *NinJam) pass(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.events:
+			envelope, ok := n.envelope(event)
+			if !ok {
+				continue
+			}
+			select {
+			case n.incoming <- envelope:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// envelope converts event to envelope for router, own messages of the bot are skipped
+func (n *NinJam) envelope(event ninjam_bot.Event) (models.Envelope, bool) {
+	switch event := event.(type) {
+	case ninjam_bot.ChatEvent:
+		if strings.HasPrefix(event.Message.Name, n.bot.UserName()) {
+			return models.Envelope{}, false
+		}
+		return models.Envelope{Message: event.Message}, true
+	case ninjam_bot.TopicEvent:
+		return models.Envelope{Message: models.Message{Type: models.TOPIC, Name: event.Topic.By, Text: event.Topic.Text}}, true
+	case ninjam_bot.PrivateMessageEvent:
+		return models.Envelope{Message: models.Message{Type: models.PRIVMSG, Name: event.Message.From, Text: event.Message.Text}}, true
+	case ninjam_bot.LicenseEvent:
+		return models.Envelope{
+			Message: models.Message{Type: models.LICENSE},
+			License: &models.License{Text: event.Text, Hash: event.Hash, Accepted: event.Accepted},
+		}, true
+	}
+
+	return models.Envelope{}, false
+}
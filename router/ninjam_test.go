@@ -0,0 +1,35 @@
+package router
+
+import (
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNinJam_envelope(t *testing.T) {
+	n := NewNinJam(ninjam_bot.NewNinJamBot("localhost", "2049", "bot", "", true))
+
+	events := []ninjam_bot.Event{
+		ninjam_bot.ChatEvent{Message: models.Message{Type: models.MSG, Name: "Vasya@127.0.0.x", Text: "привет"}},
+		// собственные сообщения бота не возвращаются в роутер
+		ninjam_bot.ChatEvent{Message: models.Message{Type: models.MSG, Name: "bot@127.0.0.x", Text: "эхо"}},
+		ninjam_bot.TopicEvent{Topic: models.Topic{By: "Petya", Text: "блюз"}},
+		ninjam_bot.PrivateMessageEvent{Message: models.PrivateMessage{From: "Petya", Text: "@kolya hi"}},
+		ninjam_bot.LicenseEvent{Text: "be nice", Hash: "abc", Accepted: true},
+		ninjam_bot.UserCountEvent{},
+	}
+	var envelopes []models.Envelope
+	for _, event := range events {
+		if envelope, ok := n.envelope(event); ok {
+			envelopes = append(envelopes, envelope)
+		}
+	}
+
+	assert.Equal(t, []models.Envelope{
+		{Message: models.Message{Type: models.MSG, Name: "Vasya@127.0.0.x", Text: "привет"}},
+		{Message: models.Message{Type: models.TOPIC, Name: "Petya", Text: "блюз"}},
+		{Message: models.Message{Type: models.PRIVMSG, Name: "Petya", Text: "@kolya hi"}},
+		{Message: models.Message{Type: models.LICENSE}, License: &models.License{Text: "be nice", Hash: "abc", Accepted: true}},
+	}, envelopes)
+}
@@ -0,0 +1,405 @@
+// Package router passes messages between bridges: NINJAM servers and chats like Telegram and Slack.
+//
+// Every bridge sends its users' messages to Incoming channel and gets messages of others by Send.
+// Bridges which can find NINJAM users (Server) are NINJAM servers, others are chats. Chat message
+// goes to all servers and to other chats, NINJAM chat message goes to chats and to other servers,
+// joins, parts, topics and service messages of servers go to chats. Adding a chat platform means
+// registering one more bridge. Chat commands and notices of other sources like activity monitor
+// are plugged in by Command and Notify, so router doesn't depend on them:
+//
+//	r := router.New(ignoreUsers, ignorePrefix)
+//	ninjam := router.NewNinJam(bot)
+//	r.Register("127.0.0.1:2049", ninjam)
+//	r.Register("telegram", tbot)
+//	r.Command(models.RECORD, recorders)
+//	go ninjam.Connect(ctx)
+//	go tbot.Connect(ctx)
+//	r.Run(ctx)
+package router
+
+import (
+	"context"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/sirupsen/logrus"
+	"net"
+	"strings"
+)
+
+// Bridge connects router to NINJAM server or chat platform
+type Bridge interface {
+	// Connect keeps connection until ctx is done or Stop is called
+	Connect(ctx context.Context) error
+	Stop()
+	// Send delivers envelope to bridge's users, bridge ignores envelope types it can't send
+	Send(envelope models.Envelope)
+	// Incoming returns messages of bridge's users
+	Incoming() <-chan models.Envelope
+}
+
+// Server is bridge to NINJAM server
+type Server interface {
+	Bridge
+	FindUser(name string) (models.User, bool)
+}
+
+// CommandHandler handles chat command like models.RECORD and returns reply to the chat which sent it,
+// private command is answered by private message to its sender
+type CommandHandler interface {
+	HandleCommand(msg models.Message) string
+}
+
+// CommandHandlerFunc adapts function to CommandHandler
+type CommandHandlerFunc func(msg models.Message) string
+
+// HandleCommand calls f(msg)
+func (f CommandHandlerFunc) HandleCommand(msg models.Message) string {
+	return f(msg)
+}
+
+// Notice is a message about server from other source, e.g. activity monitor's notice about silent user
+type Notice interface {
+	// Text returns message for chats, server is the name the server is registered with
+	Text(server string) string
+}
+
+// directory - чат, который знает своих пользователей, им пересылаются личные сообщения с NINJAM
+type directory interface {
+	HasUser(userName string) bool
+}
+
+type bridge struct {
+	Bridge
+	name     string
+	incoming <-chan models.Envelope
+	server   Server // nil for chats
+	topic    string // последняя известная тема сервера: сервер присылает тему при каждом подключении бота
+}
+
+type envelopeFrom struct {
+	from     *bridge
+	envelope models.Envelope
+}
+
+type noticeFrom struct {
+	from   *bridge
+	notice Notice
+}
+
+// Router passes messages between registered bridges
+type Router struct {
+	ignoreUsers  []string
+	ignorePrefix []string
+
+	bridges  []*bridge
+	commands map[string]CommandHandler
+	notices  chan noticeFrom
+}
+
+// New creates router which doesn't pass NINJAM messages of ignoreUsers and messages starting with ignorePrefix
+func New(ignoreUsers, ignorePrefix []string) *Router {
+	return &Router{
+		ignoreUsers:  ignoreUsers,
+		ignorePrefix: ignorePrefix,
+		commands:     make(map[string]CommandHandler),
+		notices:      make(chan noticeFrom, 100),
+	}
+}
+
+// Register adds bridge with name, e.g. "telegram" or "127.0.0.1:2049", the name is shown in relayed messages.
+// NINJAM bridge must be registered before it connects, all bridges must be registered before Run
+func (r *Router) Register(name string, b Bridge) {
+	br := &bridge{Bridge: b, name: name, incoming: b.Incoming()}
+	if server, ok := b.(Server); ok {
+		br.server = server
+	}
+
+	r.bridges = append(r.bridges, br)
+}
+
+// Command sets handler of chat messages of msgType, e.g. models.RECORD, it must be called before Run
+func (r *Router) Command(msgType string, handler CommandHandler) {
+	r.commands[msgType] = handler
+}
+
+// Notify sends notice about server registered with name to chats. It doesn't block:
+// notices are dropped when router doesn't keep up with them
+func (r *Router) Notify(name string, notice Notice) {
+	br := r.find(name)
+	if br == nil {
+		logrus.Warnf("Notice of unknown server %s dropped: %s", name, notice.Text(name))
+		return
+	}
+
+	select {
+	case r.notices <- noticeFrom{from: br, notice: notice}:
+	default:
+		logrus.Warnf("Notice of %s dropped: %s", name, notice.Text(name))
+	}
+}
+
+// Run passes messages until ctx is cancelled
+func (r *Router) Run(ctx context.Context) {
+	incoming := make(chan envelopeFrom, len(r.bridges))
+
+	for _, br := range r.bridges {
+		go func(br *bridge) {
+			for {
+				select {
+				case envelope := <-br.incoming:
+					select {
+					case incoming <- envelopeFrom{from: br, envelope: envelope}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(br)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case in := <-incoming:
+			in.envelope.Source = in.from.name
+			r.route(in.from, in.envelope)
+		case n := <-r.notices:
+			r.routeNotice(n)
+		}
+	}
+}
+
+func (r *Router) route(from *bridge, envelope models.Envelope) {
+	if from.server != nil {
+		r.routeServer(from, envelope)
+		return
+	}
+
+	r.routeChat(from, envelope)
+}
+
+// routeServer пересылает сообщение NINJAM-сервера в чаты и на другие серверы
+func (r *Router) routeServer(from *bridge, envelope models.Envelope) {
+	switch envelope.Type {
+	case models.MSG, models.JOIN, models.PART:
+		r.routeNinJamChat(from, envelope.Message)
+	case models.TOPIC:
+		if from.topic == envelope.Text {
+			return
+		}
+		from.topic = envelope.Text
+
+		var message string
+		if envelope.Name != "" {
+			message = fmt.Sprintf("%s сменил тему джем-сервера %s: %s", envelope.Name, from.name, envelope.Text)
+		} else {
+			message = fmt.Sprintf("Тема джем-сервера %s: %s", from.name, envelope.Text)
+		}
+
+		r.sendChats(from, models.Message{Type: models.MSG, Text: message})
+		r.sendChats(from, models.Message{Type: models.TOPIC, Text: fmt.Sprintf("%s: %s", port(from.name), envelope.Text)})
+	case models.LICENSE:
+		if envelope.License == nil {
+			return
+		}
+
+		status := "принято"
+		if !envelope.License.Accepted {
+			status = "отклонено"
+		}
+		message := fmt.Sprintf("Джем-сервер %s требует принять лицензионное соглашение (%s ботом), SHA-256: %s\n\n%s",
+			from.name, status, envelope.License.Hash, envelope.License.Text)
+
+		r.sendChats(from, models.Message{Type: models.ADMIN, Text: message})
+	case models.PRIVMSG:
+		// личное сообщение боту на NINJAM в формате "@nick text" пересылаем в личку чата, где есть такой пользователь
+		to, text, ok := models.SplitRecipient(envelope.Text)
+		if !ok || !strings.HasPrefix(to, "@") {
+			from.Send(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: envelope.Name,
+				Text: "Формат личного сообщения: @ник_в_telegram_или_slack текст"}})
+			return
+		}
+		to = strings.TrimPrefix(to, "@")
+
+		message := fmt.Sprintf("%s@%s: %s", envelope.Name, from.name, text)
+
+		for _, br := range r.bridges {
+			if d, ok := br.Bridge.(directory); ok && br.server == nil && d.HasUser(to) {
+				logrus.Infof("Sending private message to %s user %s: %s", br.name, to, message)
+				br.Send(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: to, Text: message}, Source: from.name})
+				return
+			}
+		}
+
+		from.Send(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: envelope.Name,
+			Text: fmt.Sprintf("Пользователь %s не найден в чатах", to)}})
+	}
+}
+
+func (r *Router) routeNinJamChat(from *bridge, msg models.Message) {
+	for _, userName := range r.ignoreUsers {
+		if strings.HasPrefix(msg.Name, userName) {
+			return
+		}
+	}
+
+	for _, botName := range r.ignorePrefix {
+		if strings.HasPrefix(msg.Text, botName) {
+			return
+		}
+	}
+
+	switch msg.Type {
+	case models.MSG:
+		message := models.Message{Type: models.MSG, Text: fmt.Sprintf("%s@%s: %s", msg.Name, from.name, msg.Text)}
+		r.sendChats(from, message)
+		r.sendServers(from, message)
+	case models.JOIN:
+		r.sendChats(from, models.Message{Type: models.MSG, Text: fmt.Sprintf("%s зашёл на джем-сервер %s ", msg.Name, from.name)})
+	case models.PART:
+		r.sendChats(from, models.Message{Type: models.MSG, Text: fmt.Sprintf("%s покинул джем-сервер %s ", msg.Name, from.name)})
+	}
+}
+
+// routeChat пересылает сообщение из чата на NINJAM-серверы и в другие чаты
+func (r *Router) routeChat(from *bridge, envelope models.Envelope) {
+	msg := envelope.Message
+
+	switch msg.Type {
+	case models.PRIVMSG:
+		server, user, ok := r.findNinJamUser(msg.To)
+		if !ok {
+			from.Send(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: msg.Name,
+				Text: fmt.Sprintf("Пользователь %s не найден на джем-серверах", msg.To)}, UserID: envelope.UserID})
+			return
+		}
+		logrus.Infof("Sending private message from %s user %s to NinJam user %s", from.name, msg.Name, user.Name)
+		server.Send(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: user.Name,
+			Text: fmt.Sprintf("%s@%s: %s", msg.Name, from.name, msg.Text)}, Source: from.name})
+	case models.TOPIC:
+		r.routeTopic(from, envelope)
+	case models.MSG:
+		message := models.Message{Type: models.MSG, Text: fmt.Sprintf("%s@%s: %s", msg.Name, from.name, msg.Text)}
+		r.sendServers(from, message)
+		r.sendChats(from, message)
+	default:
+		handler, ok := r.commands[msg.Type]
+		if !ok {
+			return
+		}
+		logrus.Infof("Command %s %s from %s (%s)", msg.Type, msg.Text, from.name, msg.Name)
+		r.reply(from, envelope, handler.HandleCommand(msg))
+	}
+}
+
+// routeTopic sets topic "PORT text" of the server with PORT, topic without port is set only when there is one server
+func (r *Router) routeTopic(from *bridge, envelope models.Envelope) {
+	var servers []*bridge
+	for _, br := range r.bridges {
+		if br.server != nil {
+			servers = append(servers, br)
+		}
+	}
+
+	to := servers
+	text := envelope.Text
+	if p, body, ok := models.SplitRecipient(envelope.Text); ok {
+		for _, br := range servers {
+			if port(br.name) == p {
+				to, text = []*bridge{br}, body
+				break
+			}
+		}
+	}
+
+	if len(to) != 1 {
+		ports := make([]string, 0, len(servers))
+		for _, br := range servers {
+			ports = append(ports, port(br.name))
+		}
+		r.reply(from, envelope, fmt.Sprintf("Формат команды: topic ПОРТ_СЕРВЕРА ТЕКСТ, джем-серверы: %s", strings.Join(ports, ", ")))
+		return
+	}
+
+	logrus.Infof("Setting NinJam topic of %s from %s (%s): %s", to[0].name, from.name, envelope.Name, text)
+	r.send(from, to[0], models.Message{Type: models.TOPIC, Text: text})
+}
+
+// reply answers message of chat: privately when the message was private, otherwise to the chat
+func (r *Router) reply(from *bridge, envelope models.Envelope, text string) {
+	if envelope.Private {
+		from.Send(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: envelope.Name, Text: text}, UserID: envelope.UserID})
+		return
+	}
+
+	from.Send(models.Envelope{Message: models.Message{Type: models.MSG, Text: text}})
+}
+
+// routeNotice пересылает в чаты сообщение другого источника о сервере
+func (r *Router) routeNotice(n noticeFrom) {
+	r.sendChats(n.from, models.Message{Type: models.MSG, Text: n.notice.Text(n.from.name)})
+}
+
+// sendChats sends message to all chats except from
+func (r *Router) sendChats(from *bridge, msg models.Message) {
+	for _, br := range r.bridges {
+		if br.server == nil && br != from {
+			r.send(from, br, msg)
+		}
+	}
+}
+
+// sendServers sends message to all NINJAM servers except from
+func (r *Router) sendServers(from *bridge, msg models.Message) {
+	for _, br := range r.bridges {
+		if br.server != nil && br != from {
+			r.send(from, br, msg)
+		}
+	}
+}
+
+func (r *Router) send(from, to *bridge, msg models.Message) {
+	envelope := models.Envelope{Message: msg}
+	if from != nil {
+		envelope.Source = from.name
+	}
+
+	logrus.Infof("Sending %s to %s: %s", msg.Type, to.name, msg.Text)
+	to.Send(envelope)
+}
+
+// findNinJamUser looks up user on all NINJAM servers
+func (r *Router) findNinJamUser(name string) (*bridge, models.User, bool) {
+	for _, br := range r.bridges {
+		if br.server == nil {
+			continue
+		}
+		if user, ok := br.server.FindUser(name); ok {
+			return br, user, true
+		}
+	}
+
+	return nil, models.User{}, false
+}
+
+func (r *Router) find(name string) *bridge {
+	for _, br := range r.bridges {
+		if br.name == name {
+			return br
+		}
+	}
+
+	return nil
+}
+
+// port returns port of server name "host:port", chats show it in topic
+func port(name string) string {
+	if _, port, err := net.SplitHostPort(name); err == nil {
+		return port
+	}
+
+	return name
+}
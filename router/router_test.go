@@ -0,0 +1,325 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeChat records everything router sends to chat
+type fakeChat struct {
+	incoming chan models.Envelope
+	messages chan string
+	direct   chan models.Envelope
+	topics   chan string
+	admin    chan string
+	users    map[string]bool
+}
+
+func newFakeChat(users ...string) *fakeChat {
+	c := &fakeChat{
+		incoming: make(chan models.Envelope, 100),
+		messages: make(chan string, 100),
+		direct:   make(chan models.Envelope, 100),
+		topics:   make(chan string, 100),
+		admin:    make(chan string, 100),
+		users:    make(map[string]bool),
+	}
+	for _, user := range users {
+		c.users[user] = true
+	}
+
+	return c
+}
+
+func (c *fakeChat) Connect(ctx context.Context) error {
+	return nil
+}
+
+func (c *fakeChat) Stop() {}
+
+func (c *fakeChat) Incoming() <-chan models.Envelope {
+	return c.incoming
+}
+
+func (c *fakeChat) Send(envelope models.Envelope) {
+	switch envelope.Type {
+	case models.MSG:
+		c.messages <- envelope.Text
+	case models.PRIVMSG:
+		c.direct <- models.Envelope{Message: models.Message{To: envelope.To, Text: envelope.Text}, UserID: envelope.UserID}
+	case models.TOPIC:
+		c.topics <- envelope.Text
+	case models.ADMIN:
+		c.admin <- envelope.Text
+	}
+}
+
+func (c *fakeChat) HasUser(userName string) bool {
+	return c.users[userName]
+}
+
+func receive(t *testing.T, ch interface{}) interface{} {
+	timeout := time.After(time.Second * 5)
+	switch ch := ch.(type) {
+	case chan string:
+		select {
+		case v := <-ch:
+			return v
+		case <-timeout:
+		}
+	case chan models.Envelope:
+		select {
+		case v := <-ch:
+			return v
+		case <-timeout:
+		}
+	}
+	t.Fatal("nothing received")
+	return nil
+}
+
+func nextFrame(t *testing.T, server *ninjamtest.Server) ninjamtest.Frame {
+	f, err := server.Next(time.Second * 5)
+	require.NoError(t, err)
+
+	return f
+}
+
+// routerTest - router of two NINJAM servers and two chats, Vasya plays on the first server,
+// the second one requires accepting license agreement
+type routerTest struct {
+	router   *Router
+	servers  []*ninjamtest.Server
+	bots     []*ninjam_bot.NinJamBot
+	tbot     *fakeChat
+	sbot     *fakeChat
+	commands chan models.Message // команды, полученные обработчиком models.RECORD
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func newRouterTest(t *testing.T) *routerTest {
+	rt := &routerTest{
+		tbot:     newFakeChat("petya"),
+		sbot:     newFakeChat("kolya"),
+		commands: make(chan models.Message, 100),
+		done:     make(chan struct{}),
+	}
+
+	for i := 0; i < 2; i++ {
+		server := ninjamtest.NewServer()
+		rt.servers = append(rt.servers, server)
+		rt.bots = append(rt.bots, ninjam_bot.NewNinJamBot(server.Host(), server.Port(), "bot", "", true))
+	}
+	rt.servers[0].OnLogin(ninjamtest.UserJoined("Vasya@127.0.0.x", 0, "guitar"))
+	rt.servers[1].License("be nice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rt.cancel = cancel
+
+	r := New([]string{"Ignored"}, []string{"!"})
+	rt.router = r
+	for _, bot := range rt.bots {
+		bridge := NewNinJam(bot)
+		r.Register(bot.Host()+":"+bot.Port(), bridge)
+		go bridge.Connect(ctx)
+	}
+	r.Register("telegram", rt.tbot)
+	r.Register("slack", rt.sbot)
+	r.Command(models.RECORD, CommandHandlerFunc(func(msg models.Message) string {
+		rt.commands <- msg
+		return "Запись " + msg.Text
+	}))
+
+	for _, server := range rt.servers {
+		_, err := server.WaitLogin(time.Second * 5)
+		require.NoError(t, err)
+	}
+
+	// ждём, пока бот обработает список пользователей сервера
+	deadline := time.Now().Add(time.Second * 5)
+	for _, ok := rt.bots[0].FindUser("Vasya"); !ok; _, ok = rt.bots[0].FindUser("Vasya") {
+		require.True(t, time.Now().Before(deadline), "users list was not received")
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		defer close(rt.done)
+		r.Run(ctx)
+	}()
+
+	return rt
+}
+
+func (rt *routerTest) close() {
+	rt.cancel()
+	for _, bot := range rt.bots {
+		bot.Stop()
+	}
+	<-rt.done
+	for _, server := range rt.servers {
+		server.Close()
+	}
+}
+
+func TestRouter_NinJamChat(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	port := rt.servers[0].Port()
+
+	// own, ignored users' and prefixed messages are not relayed
+	rt.servers[0].Push(
+		ninjamtest.ChatMessage(models.MSG, "bot", "own message"),
+		ninjamtest.ChatMessage(models.MSG, "Ignored@127.0.0.x", "ignored"),
+		ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", "!command"),
+		ninjamtest.ChatMessage(models.MSG, "Vasya@127.0.0.x", "hello"),
+	)
+
+	message := "Vasya@127.0.0.x@127.0.0.1:" + port + ": hello"
+	assert.Equal(t, message, receive(t, rt.tbot.messages))
+	assert.Equal(t, message, receive(t, rt.sbot.messages))
+	assert.Equal(t, []string{models.MSG, message}, nextFrame(t, rt.servers[1]).ChatArgs())
+
+	rt.servers[0].Push(ninjamtest.ChatMessage(models.JOIN, "Sidor@127.0.0.x"))
+	assert.Equal(t, "Sidor@127.0.0.x зашёл на джем-сервер 127.0.0.1:"+port+" ", receive(t, rt.tbot.messages))
+	assert.Equal(t, "Sidor@127.0.0.x зашёл на джем-сервер 127.0.0.1:"+port+" ", receive(t, rt.sbot.messages))
+}
+
+func TestRouter_ChatToNinJam(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	rt.tbot.incoming <- models.Envelope{Message: models.Message{Type: models.MSG, Name: "Petya", Text: "hi"}}
+
+	for _, server := range rt.servers {
+		assert.Equal(t, []string{models.MSG, "Petya@telegram: hi"}, nextFrame(t, server).ChatArgs())
+	}
+	assert.Equal(t, "Petya@telegram: hi", receive(t, rt.sbot.messages))
+
+	rt.sbot.incoming <- models.Envelope{Message: models.Message{Type: models.MSG, Name: "Kolya", Text: "hey"}}
+
+	for _, server := range rt.servers {
+		assert.Equal(t, []string{models.MSG, "Kolya@slack: hey"}, nextFrame(t, server).ChatArgs())
+	}
+	assert.Equal(t, "Kolya@slack: hey", receive(t, rt.tbot.messages))
+}
+
+func TestRouter_PrivateMessages(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	// Telegram -> NINJAM user found by name without address
+	rt.tbot.incoming <- models.Envelope{Message: models.Message{Type: models.PRIVMSG, Name: "petya", To: "vasya", Text: "psst"}}
+	assert.Equal(t, []string{models.PRIVMSG, "Vasya@127.0.0.x", "petya@telegram: psst"}, nextFrame(t, rt.servers[0]).ChatArgs())
+
+	// ответ отправляется по ID пользователя чата
+	rt.sbot.incoming <- models.Envelope{Message: models.Message{Type: models.PRIVMSG, Name: "Kolya K", To: "nobody", Text: "psst"}, UserID: "U0123ABCD"}
+	assert.Equal(t, models.Envelope{Message: models.Message{To: "Kolya K", Text: "Пользователь nobody не найден на джем-серверах"}, UserID: "U0123ABCD"},
+		receive(t, rt.sbot.direct))
+
+	// NINJAM -> Slack user
+	rt.servers[0].Push(ninjamtest.ChatMessage(models.PRIVMSG, "Vasya@127.0.0.x", "@kolya hello"))
+	direct := receive(t, rt.sbot.direct).(models.Envelope)
+	assert.Equal(t, "kolya", direct.To)
+	assert.True(t, strings.HasSuffix(direct.Text, ": hello"))
+
+	// wrong format is answered on NINJAM
+	rt.servers[0].Push(ninjamtest.ChatMessage(models.PRIVMSG, "Vasya@127.0.0.x", "hello"))
+	assert.Equal(t, []string{models.PRIVMSG, "Vasya@127.0.0.x", "Формат личного сообщения: @ник_в_telegram_или_slack текст"}, nextFrame(t, rt.servers[0]).ChatArgs())
+}
+
+func TestRouter_Topic(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	port := rt.servers[1].Port()
+
+	rt.servers[1].Push(ninjamtest.ChatMessage(models.TOPIC, "Vasya", "jam tonight"))
+	assert.Equal(t, "Vasya сменил тему джем-сервера 127.0.0.1:"+port+": jam tonight", receive(t, rt.tbot.messages))
+	assert.Equal(t, port+": jam tonight", receive(t, rt.tbot.topics))
+	assert.Equal(t, port+": jam tonight", receive(t, rt.sbot.topics))
+
+	// серверов два, тема без порта не меняется
+	rt.tbot.incoming <- models.Envelope{Message: models.Message{Type: models.TOPIC, Name: "petya", Text: "new topic"}}
+	assert.Equal(t, "Формат команды: topic ПОРТ_СЕРВЕРА ТЕКСТ, джем-серверы: "+rt.servers[0].Port()+", "+port,
+		receive(t, rt.tbot.messages))
+
+	rt.sbot.incoming <- models.Envelope{Message: models.Message{Type: models.TOPIC, Name: "kolya", Text: port + " new topic"}, Private: true}
+	assert.Equal(t, []string{models.TOPIC, "new topic"}, nextFrame(t, rt.servers[1]).ChatArgs())
+
+	rt.sbot.incoming <- models.Envelope{Message: models.Message{Type: models.TOPIC, Name: "kolya", Text: "1 new topic"}, Private: true}
+	assert.Equal(t, models.Envelope{Message: models.Message{To: "kolya", Text: "Формат команды: topic ПОРТ_СЕРВЕРА ТЕКСТ, джем-серверы: " +
+		rt.servers[0].Port() + ", " + port}}, receive(t, rt.sbot.direct))
+	// первый сервер темы не получал
+	_, err := rt.servers[0].Next(time.Millisecond * 100)
+	assert.Error(t, err)
+}
+
+func TestRouter_License(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	// служебное сообщение получают все чаты, чаты без чата администраторов его пропускают
+	message := "Джем-сервер 127.0.0.1:" + rt.servers[1].Port() + " требует принять лицензионное соглашение (принято ботом), SHA-256: " +
+		ninjam_bot.LicenseHash([]byte("be nice")) + "\n\nbe nice"
+	assert.Equal(t, message, receive(t, rt.tbot.admin))
+	assert.Equal(t, message, receive(t, rt.sbot.admin))
+	assert.Empty(t, rt.tbot.messages)
+	assert.Empty(t, rt.sbot.messages)
+}
+
+func TestRouter_Command(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	// без обработчика команда игнорируется
+	rt.sbot.incoming <- models.Envelope{Message: models.Message{Type: "UNKNOWN", Name: "kolya", Text: "start"}}
+	rt.sbot.incoming <- models.Envelope{Message: models.Message{Type: models.RECORD, Name: "kolya", Text: "stop"}}
+
+	assert.Equal(t, "Запись stop", receive(t, rt.sbot.messages))
+	assert.Equal(t, models.Message{Type: models.RECORD, Name: "kolya", Text: "stop"}, <-rt.commands)
+
+	// на команду из лички отвечаем в личку
+	rt.tbot.incoming <- models.Envelope{Message: models.Message{Type: models.RECORD, Name: "Petya", Text: "start"}, Private: true, UserID: "42"}
+	assert.Equal(t, models.Envelope{Message: models.Message{To: "Petya", Text: "Запись start"}, UserID: "42"}, receive(t, rt.tbot.direct))
+	assert.Equal(t, models.Message{Type: models.RECORD, Name: "Petya", Text: "start"}, <-rt.commands)
+	assert.Empty(t, rt.commands)
+	assert.Empty(t, rt.tbot.messages)
+	assert.Empty(t, rt.sbot.messages)
+}
+
+// notice - сообщение другого источника для теста
+type notice string
+
+func (n notice) Text(server string) string {
+	return fmt.Sprintf("%s: %s", server, string(n))
+}
+
+func TestRouter_Notify(t *testing.T) {
+	rt := newRouterTest(t)
+	defer rt.close()
+
+	name := "127.0.0.1:" + rt.servers[0].Port()
+
+	// сообщение о неизвестном сервере отбрасывается сразу
+	rt.router.Notify("127.0.0.1:1", notice("неизвестный сервер"))
+	rt.router.Notify(name, notice("Vasya молчит"))
+
+	assert.Equal(t, name+": Vasya молчит", receive(t, rt.tbot.messages))
+	assert.Equal(t, name+": Vasya молчит", receive(t, rt.sbot.messages))
+	assert.Empty(t, rt.tbot.messages)
+}
+
+func TestPort(t *testing.T) {
+	assert.Equal(t, "2049", port("127.0.0.1:2049"))
+	assert.Equal(t, "telegram", port("telegram"))
+}
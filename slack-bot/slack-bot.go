@@ -1,12 +1,14 @@
 package slack_bot
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/ayvan/ninjam-chatbot/models"
-	"github.com/slack-go/slack"
 	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,9 +22,15 @@ type SlackBot struct {
 	channel           string
 	channelID         string
 	messagesToSlack   chan string
-	messagesFromSlack chan models.Message
+	messagesFromSlack chan models.Envelope
+	topicsToSlack     chan string
+	directToSlack     chan models.Envelope
+	users             map[string]string
+	usersMutex        sync.Mutex
 	models.Mountser
-	disabled bool
+	disabled  bool
+	syncTopic bool
+	admins    []string
 }
 
 func NewSlackBot(token, channel, botName string, mounts models.Mountser) *SlackBot {
@@ -32,7 +40,10 @@ func NewSlackBot(token, channel, botName string, mounts models.Mountser) *SlackB
 		token:             token,
 		channel:           channel,
 		messagesToSlack:   make(chan string, 1000),
-		messagesFromSlack: make(chan models.Message, 1000),
+		messagesFromSlack: make(chan models.Envelope, 1000),
+		topicsToSlack:     make(chan string, 100),
+		directToSlack:     make(chan models.Envelope, 100),
+		users:             make(map[string]string),
 		Mountser:          mounts,
 	}
 }
@@ -41,10 +52,44 @@ func (sb *SlackBot) Disabled(disabled bool) {
 	sb.disabled = disabled
 }
 
-func (sb *SlackBot) IncomingMessages() <-chan models.Message {
+// SyncTopic enables writing NINJAM topic to channel topic
+func (sb *SlackBot) SyncTopic(syncTopic bool) {
+	sb.syncTopic = syncTopic
+}
+
+// Admins sets IDs of users allowed to run bot commands like "topic", e.g. "U0123ABCD", display names
+// are not used because anyone can take a name
+func (sb *SlackBot) Admins(admins []string) {
+	sb.admins = admins
+}
+
+func (sb *SlackBot) isAdmin(userID string) bool {
+	for _, admin := range sb.admins {
+		if admin == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Incoming returns messages and commands of Slack users for router
+func (sb *SlackBot) Incoming() <-chan models.Envelope {
 	return sb.messagesFromSlack
 }
 
+// Send sends envelope from router: MSG to chat, PRIVMSG to user UserID or To, TOPIC sets topic, other types are ignored
+func (sb *SlackBot) Send(envelope models.Envelope) {
+	switch envelope.Type {
+	case models.MSG:
+		sb.SendMessage(envelope.Text)
+	case models.PRIVMSG:
+		sb.sendDirect(envelope)
+	case models.TOPIC:
+		sb.SetTopic(envelope.Text)
+	}
+}
+
 func (sb *SlackBot) SendMessage(message string) {
 	if sb.disabled {
 		return
@@ -54,32 +99,112 @@ func (sb *SlackBot) SendMessage(message string) {
 	}()
 }
 
-func (sb *SlackBot) Connect() {
+// SetTopic writes topic to channel topic if topic sync is enabled
+func (sb *SlackBot) SetTopic(topic string) {
+	if sb.disabled || !sb.syncTopic {
+		return
+	}
+	go func() {
+		sb.topicsToSlack <- topic
+	}()
+}
+
+// SendDirectMessage sends direct message to Slack user by user name
+func (sb *SlackBot) SendDirectMessage(userName, message string) {
+	sb.sendDirect(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: userName, Text: message}})
+}
+
+func (sb *SlackBot) sendDirect(envelope models.Envelope) {
 	if sb.disabled {
 		return
 	}
+	go func() {
+		sb.directToSlack <- envelope
+	}()
+}
+
+// recipientID returns ID of direct message recipient: UserID of envelope or ID of user with user name To
+func (sb *SlackBot) recipientID(envelope models.Envelope) (string, bool) {
+	if envelope.UserID != "" {
+		return envelope.UserID, true
+	}
+
+	return sb.userID(envelope.To)
+}
+
+// HasUser reports whether there is a Slack user with such user name (not display name)
+func (sb *SlackBot) HasUser(userName string) bool {
+	_, ok := sb.userID(userName)
+
+	return ok
+}
+
+func (sb *SlackBot) userID(userName string) (string, bool) {
+	sb.usersMutex.Lock()
+	defer sb.usersMutex.Unlock()
+
+	id, ok := sb.users[strings.ToLower(userName)]
+
+	return id, ok
+}
+
+// addUser remembers ID of user by unique user name, display names are not used: anyone can take any
+// of them. Known name is never given to other ID, so nobody takes over direct messages of another user
+func (sb *SlackBot) addUser(userName, id string) {
+	if userName == "" {
+		return
+	}
+
+	sb.usersMutex.Lock()
+	defer sb.usersMutex.Unlock()
+
+	key := strings.ToLower(userName)
+	if known, ok := sb.users[key]; ok && known != id {
+		logrus.Warnf("Slack user %s with ID %s is ignored, the name belongs to user with ID %s", userName, id, known)
+		return
+	}
+
+	sb.users[key] = id
+}
+
+// Connect connects to Slack and reconnects after failures until ctx is done or Stop is called
+func (sb *SlackBot) Connect(ctx context.Context) error {
+	if sb.disabled {
+		return nil
+	}
 f:
 	for {
 		select {
+		case <-ctx.Done():
+			break f
 		case s := <-sb.sigChan:
 			sb.sigChan <- s
 			break f
 		default:
-			sb.connect()
+			sb.connect(ctx)
 			// если коннект прервался - запустим таймаут перед реконнектом
-			time.Sleep(time.Second * 5)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 5):
+			}
 		}
 	}
+
+	return nil
 }
 
+// Stop stops Connect, it may be called several times
 func (sb *SlackBot) Stop() {
 	if sb.disabled {
 		return
 	}
-	sb.sigChan <- true
+	select {
+	case sb.sigChan <- true:
+	default:
+	}
 }
 
-func (sb *SlackBot) connect() {
+func (sb *SlackBot) connect(ctx context.Context) {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Warnf("Recovered in connect(): %s ", r)
@@ -106,8 +231,21 @@ func (sb *SlackBot) connect() {
 		}
 	}
 
+	users, err := rtm.GetUsers()
+	if err != nil {
+		logrus.Errorf("Slack GetUsers error: %s", err)
+	}
+
+	for _, u := range users {
+		if !u.Deleted && !u.IsBot {
+			sb.addUser(u.Name, u.ID)
+		}
+	}
+
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case s := <-sb.sigChan:
 			sb.sigChan <- s
 			return
@@ -117,6 +255,25 @@ func (sb *SlackBot) connect() {
 			message := rtm.NewOutgoingMessage(msg, sb.channelID)
 			// и отправляем его
 			rtm.SendMessage(message)
+		case msg := <-sb.directToSlack:
+			id, ok := sb.recipientID(msg)
+			if !ok {
+				logrus.Warnf("Unknown Slack user %s, direct message dropped", msg.To)
+				continue
+			}
+			im, _, _, err := rtm.OpenConversation(&slack.OpenConversationParameters{Users: []string{id}})
+			if err != nil {
+				logrus.Errorf("Slack OpenConversation error: %s", err)
+				continue
+			}
+			logrus.Infof("Sending direct message to Slack user %s: %s", msg.To, msg.Text)
+			rtm.SendMessage(rtm.NewOutgoingMessage(msg.Text, im.ID))
+		case topic := <-sb.topicsToSlack:
+			logrus.Infof("Setting Slack channel topic: %s", topic)
+			_, err := rtm.SetTopicOfConversation(sb.channelID, topic)
+			if err != nil {
+				logrus.Errorf("Slack SetTopicOfConversation error: %s", err)
+			}
 		case msg := <-rtm.IncomingEvents:
 			msgJSON, _ := json.Marshal(msg)
 			logrus.Infof("Slack event received: %T %s", msg, string(msgJSON))
@@ -152,7 +309,9 @@ func (sb *SlackBot) connect() {
 					continue
 				}
 
-				logrus.Infof("Message received: [%s] %s %s", userName, channel, text)
+				logrus.Infof("Message received: [%s, ID %s] %s %s", userName, ev.User, channel, text)
+
+				sb.addUser(u.Name, ev.User)
 
 				text = strings.Trim(text, "/")
 				text = strings.TrimSpace(text)
@@ -182,12 +341,7 @@ func (sb *SlackBot) connect() {
 							reply = reply + k + " "
 						}
 						for k, m := range sb.Mounts() {
-							users := strings.Join(m, ", ")
-							if users != "" {
-								reply = reply + "\nНа сервере " + k + " играют: " + users
-							} else {
-								reply = reply + "\nНа сервере " + k + " никого нет. "
-							}
+							reply = reply + "\n" + m.Describe(k)
 						}
 					} else {
 						reply = "Нет активных серверов!"
@@ -198,17 +352,54 @@ func (sb *SlackBot) connect() {
 					// и отправляем его
 					rtm.SendMessage(message)
 				case ok:
-					users := strings.Join(mount, ", ")
-					reply := ""
-					if users != "" {
-						reply = "На сервере " + text + " играют: " + users
-					} else {
-						reply = "На сервере " + text + " никого нет. "
-					}
+					reply := mount.Describe(text)
 					// Созадаем сообщение
 					message := rtm.NewOutgoingMessage(reply, channel)
 					// и отправляем его
 					rtm.SendMessage(message)
+				// в личке с ботом команду можно писать без имени бота
+				case strings.HasPrefix(text, "msg ") && isDirectChannel(channel) || strings.HasPrefix(text, sb.botName+" msg "):
+					to, body, ok := models.SplitRecipient(strings.TrimPrefix(strings.TrimPrefix(text, sb.botName+" "), "msg "))
+					if !ok {
+						rtm.SendMessage(rtm.NewOutgoingMessage("Формат команды: msg NINJAM_USER ТЕКСТ", channel))
+						continue
+					}
+
+					// ответ придёт по имени пользователя, отображаемое имя для этого не годится
+					m := models.Message{
+						Type: models.PRIVMSG,
+						Name: u.Name,
+						To:   to,
+						Text: body,
+					}
+
+					sb.messagesFromSlack <- models.Envelope{Message: m, UserID: ev.User}
+				case strings.HasPrefix(text, sb.botName+" topic "):
+					if !sb.isAdmin(ev.User) {
+						rtm.SendMessage(rtm.NewOutgoingMessage("Менять тему джем-сервера могут только администраторы бота", channel))
+						continue
+					}
+
+					m := models.Message{
+						Type: models.TOPIC,
+						Name: userName,
+						Text: strings.TrimSpace(strings.TrimPrefix(text, sb.botName+" topic ")),
+					}
+
+					sb.messagesFromSlack <- models.Envelope{Message: m, Private: isDirectChannel(channel), UserID: ev.User}
+				case text == sb.botName+" record start" || text == sb.botName+" record stop":
+					if !sb.isAdmin(ev.User) {
+						rtm.SendMessage(rtm.NewOutgoingMessage("Управлять записью джем-серверов могут только администраторы бота", channel))
+						continue
+					}
+
+					m := models.Message{
+						Type: models.RECORD,
+						Name: userName,
+						Text: strings.TrimPrefix(text, sb.botName+" record "),
+					}
+
+					sb.messagesFromSlack <- models.Envelope{Message: m, Private: isDirectChannel(channel), UserID: ev.User}
 				case text == sb.botName+" help":
 					reply := "Сайт джем-серверов с информацией об адресах находится по адресу http://guitar-jam.ru\n"
 					reply = reply + "Подробнее о джем-серверах, подключении к ним и по остальным вопросам читайте тему http://forum.gitarizm.ru/showthread.php?t=39731 и задавайте вопросы там или в этом чате.\n"
@@ -216,6 +407,9 @@ func (sb *SlackBot) connect() {
 					reply = reply + sb.botName + " info\n"
 					reply = reply + sb.botName + " help\n"
 					reply = reply + sb.botName + " SERVER_PORT (например \"" + sb.botName + " 2050\")\n"
+					reply = reply + sb.botName + " msg NINJAM_USER ТЕКСТ (личное сообщение пользователю джем-сервера)\n"
+					reply = reply + sb.botName + " topic SERVER_PORT ТЕКСТ (сменить тему джем-сервера, только для администраторов)\n"
+					reply = reply + sb.botName + " record start|stop (запись джем-серверов, только для администраторов)\n"
 
 					// Созадаем сообщение
 					message := rtm.NewOutgoingMessage(reply, channel)
@@ -230,7 +424,7 @@ func (sb *SlackBot) connect() {
 						Text: text,
 					}
 
-					sb.messagesFromSlack <- m
+					sb.messagesFromSlack <- models.Envelope{Message: m, UserID: ev.User}
 				}
 			}
 		}
@@ -238,6 +432,11 @@ func (sb *SlackBot) connect() {
 	}
 }
 
+// isDirectChannel reports whether channel ID belongs to direct messages conversation
+func isDirectChannel(channel string) bool {
+	return strings.HasPrefix(channel, "D")
+}
+
 func (sb *SlackBot) getNames(names []string, rtm *slack.RTM) map[string]string {
 	res := make(map[string]string)
 
@@ -255,16 +454,6 @@ func (sb *SlackBot) getNames(names []string, rtm *slack.RTM) map[string]string {
 	return res
 }
 
-type Status struct {
-	Mount []Mount `xml:"mount"`
-}
-
-type Mount struct {
-	Name      string `xml:"name"`
-	Listeners string `xml:"listeners"`
-	Users     string `xml:"users"`
-}
-
 func replaceUserNames(text string, names map[string]string) string {
 
 	for slackName, userName := range names {
@@ -0,0 +1,21 @@
+package slack_bot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSlackBot_addUser(t *testing.T) {
+	sb := NewSlackBot("token", "general", "jambot", nil)
+
+	sb.addUser("Vasya", "U1")
+	sb.addUser("", "U2")
+	// чужое имя не перехватывает личные сообщения
+	sb.addUser("vasya", "U3")
+
+	id, ok := sb.userID("VASYA")
+	assert.True(t, ok)
+	assert.Equal(t, "U1", id)
+	assert.False(t, sb.HasUser(""))
+	assert.Equal(t, map[string]string{"vasya": "U1"}, sb.users)
+}
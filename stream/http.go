@@ -0,0 +1,89 @@
+package stream
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+)
+
+const (
+	bitsPerSample = 16
+	// unknownSize - RIFF and data chunk size of endless WAV, players read such stream until it ends
+	unknownSize = 0xffffffff
+)
+
+// ServeHTTP sends the mix to listener as endless WAV until listener disconnects or the stream stops.
+// WAV has no real sizes in the header, players which check them or seek in the stream can't play it
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	l := s.listen()
+	if l == nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.leave(l)
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("icy-name", s.name)
+
+	flusher, _ := w.(http.Flusher)
+	if _, err := w.Write(wavHeader()); err != nil {
+		return
+	}
+
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case chunk, ok := <-l:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// wavHeader returns header of endless 16-bit stereo PCM WAV
+func wavHeader() []byte {
+	blockAlign := channels * bitsPerSample / 8
+
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], unknownSize)
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:], 1)  // PCM
+	binary.LittleEndian.PutUint16(header[22:], channels)
+	binary.LittleEndian.PutUint32(header[24:], SampleRate)
+	binary.LittleEndian.PutUint32(header[28:], uint32(SampleRate*blockAlign)) // bytes per second
+	binary.LittleEndian.PutUint16(header[32:], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:], bitsPerSample)
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], unknownSize)
+
+	return header
+}
+
+// pcm converts samples to 16-bit PCM, samples out of [-1, 1] are clipped
+func pcm(samples []float32) []byte {
+	data := make([]byte, len(samples)*bitsPerSample/8)
+	for i, sample := range samples {
+		s := math.Max(-1, math.Min(1, float64(sample)))
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(math.Round(s*math.MaxInt16))))
+	}
+
+	return data
+}
@@ -0,0 +1,72 @@
+package stream
+
+import (
+	"encoding/binary"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStream_ServeHTTP(t *testing.T) {
+	s := New(intervals.New(ninjam_bot.New("127.0.0.1", "2049")))
+	s.Name("Guitar jam")
+	now := start
+	s.now = func() time.Time { return now }
+	s.produce()
+
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "audio/wav", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Guitar jam", resp.Header.Get("icy-name"))
+
+	header := make([]byte, 44)
+	_, err = io.ReadFull(resp.Body, header)
+	require.NoError(t, err)
+	assert.Equal(t, "RIFF", string(header[:4]))
+	assert.Equal(t, "WAVEfmt ", string(header[8:16]))
+	assert.Equal(t, uint16(2), binary.LittleEndian.Uint16(header[22:]))
+	assert.Equal(t, uint32(SampleRate), binary.LittleEndian.Uint32(header[24:]))
+	assert.Equal(t, "data", string(header[36:40]))
+	assert.Equal(t, 1, s.Listeners())
+
+	// 100 мс тишины
+	now = now.Add(chunkPeriod)
+	s.produce()
+	chunk := make([]byte, SampleRate/10*4)
+	_, err = io.ReadFull(resp.Body, chunk)
+	require.NoError(t, err)
+	assert.Equal(t, make([]byte, len(chunk)), chunk)
+
+	// остановленный поток отключает слушателей и не принимает новых
+	s.close()
+	rest, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+
+	resp, err = http.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestPCM(t *testing.T) {
+	data := pcm([]float32{0, 1, -1, 2, 0.5})
+	assert.Equal(t, []byte{0, 0, 0xff, 0x7f, 0x01, 0x80, 0xff, 0x7f, 0x00, 0x40}, data)
+}
@@ -0,0 +1,274 @@
+// Package stream mixes audio of all users of NINJAM server and serves it as live HTTP audio stream,
+// which browsers and media players can play.
+//
+// Stream gets users' intervals from intervals.Assembler and decodes them. NINJAM client
+// hears others one interval late, so does the stream: intervals are placed on the grid of interval length
+// which starts at the first downloaded interval plus latency. Users' intervals of the same interval land
+// in the same slot and play together, with volume and pan of their channels.
+//
+// The mix is served as endless 16-bit stereo PCM WAV. Intervals aren't decoded while nobody listens,
+// but downloading all users' audio uses as much traffic as a listening client.
+//
+// Endless WAV is not a format of Icecast mounts (Ogg or MP3), there is no encoder here: to relay the stream
+// through Icecast, run source client which encodes it, e.g. ffmpeg.
+package stream
+
+import (
+	"context"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/mixdown"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/sirupsen/logrus"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// SampleRate of the stream, intervals with other sample rates are resampled
+	SampleRate = 44100
+
+	channels = 2
+	// latency - the first interval starts to play this time after it's downloaded, so intervals
+	// of other users which come a bit later still start in time
+	latency = time.Millisecond * 500
+	// chunkPeriod - how often mixed audio is sent to listeners
+	chunkPeriod = time.Millisecond * 100
+	// listenerBuffer - chunks queued for listener, slower listeners are disconnected
+	listenerBuffer = 50
+)
+
+// Stream mixes intervals of bot's server and sends them to HTTP listeners
+type Stream struct {
+	bot       *ninjam_bot.NinJamBot
+	intervals *intervals.Assembler
+	events    <-chan intervals.Event
+	now       func() time.Time
+	name      string
+
+	// состояние микса меняется только в горутине Run
+	bpm      uint
+	bpi      uint
+	started  time.Time // time of the first frame of the stream
+	position int64     // frames sent to listeners
+	mix      []float32 // mixed frames from position
+	grid     int64     // frame of an interval start, -1 when unknown
+
+	mutex     sync.Mutex
+	listeners map[chan []byte]struct{}
+	closed    bool
+}
+
+// New creates stream of assembler's server, it subscribes to assembler's events, so it must be created
+// before bot connects
+func New(assembler *intervals.Assembler) *Stream {
+	bot := assembler.Bot()
+
+	return &Stream{
+		bot:       bot,
+		intervals: assembler,
+		events:    assembler.Subscribe(),
+		now:       time.Now,
+		name:      "NINJAM " + bot.Host() + ":" + bot.Port(),
+		grid:      -1,
+		listeners: make(map[chan []byte]struct{}),
+	}
+}
+
+// Name sets stream name which players show, "NINJAM <host>:<port>" by default
+func (s *Stream) Name(name string) {
+	s.name = name
+}
+
+// Listeners returns number of connected listeners
+func (s *Stream) Listeners() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.listeners)
+}
+
+// Run handles events and sends the mix to listeners until ctx is cancelled, then it disconnects listeners.
+// It keeps the bot subscribed to all users' audio
+func (s *Stream) Run(ctx context.Context) {
+	s.intervals.Acquire()
+	defer s.intervals.Release()
+	defer s.intervals.Unsubscribe(s.events)
+	defer s.close()
+
+	ticker := time.NewTicker(chunkPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.events:
+			s.handle(event)
+		case <-ticker.C:
+			s.produce()
+		}
+	}
+}
+
+func (s *Stream) handle(event intervals.Event) {
+	switch event := event.(type) {
+	case ninjam_bot.ConfigChangeEvent:
+		if event.BPM != s.bpm || event.BPI != s.bpi {
+			// интервалы нового темпа выстраиваются по новой сетке
+			s.grid = -1
+		}
+		s.bpm, s.bpi = event.BPM, event.BPI
+	case *intervals.Interval:
+		s.write(event)
+	}
+}
+
+func (s *Stream) write(interval *intervals.Interval) {
+	if s.Listeners() == 0 {
+		return
+	}
+
+	samples, format, err := interval.Decode()
+	if err != nil {
+		logrus.Warnf("Stream %s:%s can't decode interval of %s: %s", s.bot.Host(), s.bot.Port(), interval.User, err)
+		return
+	}
+	if format.Channels != 1 && format.Channels != 2 {
+		logrus.Warnf("Stream %s:%s can't mix interval of %s: %d channels", s.bot.Host(), s.bot.Port(), interval.User, format.Channels)
+		return
+	}
+
+	left, right := mixdown.Gains(interval.Channel.Volume, interval.Channel.Pan)
+
+	s.place(mixdown.ToStereo(samples, format.Channels, format.SampleRate, SampleRate), left, right)
+}
+
+// place mixes stereo samples into the slot of the grid closest to the current frame plus latency
+func (s *Stream) place(samples []float32, left, right float32) {
+	frames := int64(len(samples) / channels)
+	if frames == 0 {
+		return
+	}
+
+	length := s.intervalFrames()
+	if length == 0 {
+		length = frames
+	}
+
+	start := s.frame() + int64(latency)*SampleRate/int64(time.Second)
+	if s.grid < 0 {
+		s.grid = start
+	}
+	slot := math.Round(float64(start-s.grid) / float64(length))
+	start = s.grid + int64(slot)*length
+
+	// начало интервала, который пришёл слишком поздно, уже проиграно
+	skip := s.position - start
+	if skip < 0 {
+		skip = 0
+	}
+	if skip >= frames {
+		return
+	}
+
+	offset := (start + skip - s.position) * channels
+	if end := offset + (frames-skip)*channels; end > int64(len(s.mix)) {
+		s.mix = append(s.mix, make([]float32, end-int64(len(s.mix)))...)
+	}
+
+	for i := skip * channels; i < int64(len(samples)); i += channels {
+		s.mix[offset] += samples[i] * left
+		s.mix[offset+1] += samples[i+1] * right
+		offset += channels
+	}
+}
+
+// produce sends mixed frames up to the current time to listeners, the stream plays silence when there is no mix
+func (s *Stream) produce() {
+	target := s.frame()
+	n := int(target-s.position) * channels
+	if n <= 0 {
+		return
+	}
+	s.position = target
+
+	chunk := make([]float32, n)
+	copy(chunk, s.mix)
+	if n < len(s.mix) {
+		s.mix = s.mix[n:]
+	} else {
+		s.mix = nil
+	}
+
+	s.broadcast(pcm(chunk))
+}
+
+// frame returns number of frames from the start of the stream to now
+func (s *Stream) frame() int64 {
+	now := s.now()
+	if s.started.IsZero() {
+		s.started = now
+	}
+
+	return int64(now.Sub(s.started)) * SampleRate / int64(time.Second)
+}
+
+func (s *Stream) intervalFrames() int64 {
+	if s.bpm == 0 || s.bpi == 0 {
+		return 0
+	}
+
+	return int64(s.bpi) * 60 * SampleRate / int64(s.bpm)
+}
+
+func (s *Stream) broadcast(chunk []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for l := range s.listeners {
+		select {
+		case l <- chunk:
+		default:
+			logrus.Warnf("Stream %s:%s disconnects slow listener", s.bot.Host(), s.bot.Port())
+			delete(s.listeners, l)
+			close(l)
+		}
+	}
+}
+
+// listen adds listener, it returns nil when the stream is stopped
+func (s *Stream) listen() chan []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	l := make(chan []byte, listenerBuffer)
+	s.listeners[l] = struct{}{}
+
+	return l
+}
+
+func (s *Stream) leave(l chan []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.listeners[l]; ok {
+		delete(s.listeners, l)
+		close(l)
+	}
+}
+
+func (s *Stream) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	for l := range s.listeners {
+		delete(s.listeners, l)
+		close(l)
+	}
+}
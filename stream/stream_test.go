@@ -0,0 +1,112 @@
+package stream
+
+import (
+	"encoding/binary"
+	"github.com/ayvan/ninjam-chatbot/intervals"
+	"github.com/ayvan/ninjam-chatbot/models"
+	"github.com/ayvan/ninjam-chatbot/ninjam-bot"
+	"github.com/ayvan/ninjam-chatbot/ninjamtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var (
+	// гитара только в левом канале
+	guitar = models.User{Name: "Vasya@127.0.0.x", Channels: []models.Channel{{Index: 0, Name: "guitar", Pan: -128}}}
+	start  = time.Date(2026, 10, 18, 20, 30, 0, 0, time.UTC)
+)
+
+// interval returns guitar's interval, it's panned to the left
+func interval(data []byte) *intervals.Interval {
+	return &intervals.Interval{User: guitar.Name, Channel: guitar.Channels[0], Data: data}
+}
+
+// received returns frames queued for listener as left and right samples
+func received(l chan []byte) (left, right []int16) {
+	for len(l) > 0 {
+		chunk := <-l
+		for i := 0; i < len(chunk); i += 4 {
+			left = append(left, int16(binary.LittleEndian.Uint16(chunk[i:])))
+			right = append(right, int16(binary.LittleEndian.Uint16(chunk[i+2:])))
+		}
+	}
+
+	return left, right
+}
+
+// onset returns the first frame louder than silence or -1
+func onset(samples []int16) int {
+	for i, sample := range samples {
+		if sample > 100 || sample < -100 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func TestStream(t *testing.T) {
+	sound := ninjamtest.Sound()
+
+	s := New(intervals.New(ninjam_bot.New("127.0.0.1", "2049")))
+	now := start
+	s.now = func() time.Time { return now }
+	s.produce()
+
+	// 120 BPM, 16 BPI - интервал 8 секунд
+	s.handle(ninjam_bot.ConfigChangeEvent{BPM: 120, BPI: 16})
+
+	// без слушателей интервалы не декодируются
+	s.handle(interval(sound))
+	assert.Empty(t, s.mix)
+
+	l := s.listen()
+	require.NotNil(t, l)
+	assert.Equal(t, 1, s.Listeners())
+
+	// первый интервал звучит через latency после загрузки
+	now = now.Add(time.Second)
+	s.handle(interval(sound))
+	// битый интервал пропускается
+	s.handle(interval([]byte("OggS")))
+
+	now = now.Add(time.Second * 3)
+	s.produce()
+	left, right := received(l)
+	require.Len(t, left, 4*SampleRate)
+	assert.InDelta(t, SampleRate*3/2, onset(left), 100)
+	assert.Equal(t, -1, onset(right))
+	assert.Equal(t, -1, onset(left[SampleRate*3:]))
+
+	// следующий интервал пришёл с опозданием, но попадает в свою ячейку сетки
+	now = start.Add(time.Second*9 + time.Millisecond*300)
+	s.handle(interval(sound))
+	now = start.Add(time.Second * 12)
+	s.produce()
+	left, _ = received(l)
+	require.Len(t, left, 8*SampleRate)
+	assert.InDelta(t, SampleRate*11/2, onset(left), 100)
+
+	s.leave(l)
+	assert.Equal(t, 0, s.Listeners())
+}
+
+func TestStream_SlowListener(t *testing.T) {
+	s := New(intervals.New(ninjam_bot.New("127.0.0.1", "2049")))
+	now := start
+	s.now = func() time.Time { return now }
+	s.produce()
+
+	l := s.listen()
+	for i := 0; i <= listenerBuffer; i++ {
+		now = now.Add(chunkPeriod)
+		s.produce()
+	}
+	assert.Equal(t, 0, s.Listeners())
+	assert.Len(t, l, listenerBuffer)
+
+	s.close()
+	assert.Nil(t, s.listen())
+}
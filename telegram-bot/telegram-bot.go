@@ -1,10 +1,13 @@
 package telegram_bot
 
 import (
+	"context"
 	"github.com/ayvan/ninjam-chatbot/models"
-	"github.com/sirupsen/logrus"
 	"github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/sirupsen/logrus"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,9 +16,17 @@ type TelegramBot struct {
 	token                string
 	chatID               int64
 	messagesToTelegram   chan string
-	messagesFromTelegram chan models.Message
+	messagesFromTelegram chan models.Envelope
+	topicsToTelegram     chan string
+	directToTelegram     chan models.Envelope
+	adminToTelegram      chan string
+	users                map[string]int64
+	usersMutex           sync.Mutex
 	models.Mountser
-	disabled bool
+	disabled    bool
+	syncTopic   bool
+	admins      []int64
+	adminChatID int64
 }
 
 func NewTelegramBot(token string, chatID int64, mounts models.Mountser) *TelegramBot {
@@ -24,7 +35,11 @@ func NewTelegramBot(token string, chatID int64, mounts models.Mountser) *Telegra
 		token:                token,
 		chatID:               chatID,
 		messagesToTelegram:   make(chan string, 1000),
-		messagesFromTelegram: make(chan models.Message, 1000),
+		messagesFromTelegram: make(chan models.Envelope, 1000),
+		topicsToTelegram:     make(chan string, 100),
+		directToTelegram:     make(chan models.Envelope, 100),
+		adminToTelegram:      make(chan string, 100),
+		users:                make(map[string]int64),
 		Mountser:             mounts,
 	}
 }
@@ -33,10 +48,52 @@ func (t *TelegramBot) Disabled(disabled bool) {
 	t.disabled = disabled
 }
 
-func (t *TelegramBot) IncomingMessages() <-chan models.Message {
+// SyncTopic enables writing NINJAM topic to chat description
+func (t *TelegramBot) SyncTopic(syncTopic bool) {
+	t.syncTopic = syncTopic
+}
+
+// Admins sets IDs of users allowed to run bot commands like "topic", names are not used because
+// anyone can take a name
+func (t *TelegramBot) Admins(admins []int64) {
+	t.admins = admins
+}
+
+// AdminChatID sets chat for service messages to admins, e.g. servers' license agreements
+func (t *TelegramBot) AdminChatID(chatID int64) {
+	t.adminChatID = chatID
+}
+
+func (t *TelegramBot) isAdmin(userID int64) bool {
+	for _, admin := range t.admins {
+		if admin == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Incoming returns messages and commands of Telegram users for router
+func (t *TelegramBot) Incoming() <-chan models.Envelope {
 	return t.messagesFromTelegram
 }
 
+// Send sends envelope from router: MSG to chat, PRIVMSG to user UserID or To, TOPIC sets topic and ADMIN goes
+// to admins' chat, other types are ignored
+func (t *TelegramBot) Send(envelope models.Envelope) {
+	switch envelope.Type {
+	case models.MSG:
+		t.SendMessage(envelope.Text)
+	case models.PRIVMSG:
+		t.sendDirect(envelope)
+	case models.TOPIC:
+		t.SetTopic(envelope.Text)
+	case models.ADMIN:
+		t.SendAdminMessage(envelope.Text)
+	}
+}
+
 func (t *TelegramBot) SendMessage(message string) {
 	if t.disabled {
 		return
@@ -46,32 +103,130 @@ func (t *TelegramBot) SendMessage(message string) {
 	}()
 }
 
-func (t *TelegramBot) Connect() {
+// SetTopic writes topic to chat description if topic sync is enabled
+func (t *TelegramBot) SetTopic(topic string) {
+	if t.disabled || !t.syncTopic {
+		return
+	}
+	go func() {
+		t.topicsToTelegram <- topic
+	}()
+}
+
+// SendDirectMessage sends private message to user, it's possible only for users the bot has seen
+// and who has started private chat with the bot
+func (t *TelegramBot) SendDirectMessage(userName, message string) {
+	t.sendDirect(models.Envelope{Message: models.Message{Type: models.PRIVMSG, To: userName, Text: message}})
+}
+
+func (t *TelegramBot) sendDirect(envelope models.Envelope) {
 	if t.disabled {
 		return
 	}
+	go func() {
+		t.directToTelegram <- envelope
+	}()
+}
+
+// recipientID returns ID of private message recipient: numeric UserID of envelope or ID of user with @username To
+func (t *TelegramBot) recipientID(envelope models.Envelope) (int64, bool) {
+	if envelope.UserID == "" {
+		return t.userID(envelope.To)
+	}
+
+	id, err := strconv.ParseInt(envelope.UserID, 10, 64)
+
+	return id, err == nil
+}
+
+// SendAdminMessage sends message to admins' chat, it's dropped if the chat is not set
+func (t *TelegramBot) SendAdminMessage(message string) {
+	if t.disabled {
+		return
+	}
+	if t.adminChatID == 0 {
+		logrus.Warnf("Telegram admin chat is not set, message dropped: %s", message)
+		return
+	}
+	go func() {
+		t.adminToTelegram <- message
+	}()
+}
+
+// HasUser reports whether the bot has seen messages from user with such @username
+func (t *TelegramBot) HasUser(userName string) bool {
+	_, ok := t.userID(userName)
+
+	return ok
+}
+
+func (t *TelegramBot) userID(userName string) (int64, bool) {
+	t.usersMutex.Lock()
+	defer t.usersMutex.Unlock()
+
+	id, ok := t.users[strings.ToLower(userName)]
+
+	return id, ok
+}
+
+// addUser remembers ID of user by @username, users without it can't get private messages: first names
+// are not unique and anyone can change them. Known name is never given to other ID, so nobody takes over
+// private messages of another user
+func (t *TelegramBot) addUser(userName string, id int64) {
+	if userName == "" {
+		return
+	}
+
+	t.usersMutex.Lock()
+	defer t.usersMutex.Unlock()
+
+	key := strings.ToLower(userName)
+	if known, ok := t.users[key]; ok && known != id {
+		logrus.Warnf("Telegram user %s with ID %d is ignored, the name belongs to user with ID %d", userName, id, known)
+		return
+	}
+
+	t.users[key] = id
+}
+
+// Connect connects to Telegram and reconnects after failures until ctx is done or Stop is called
+func (t *TelegramBot) Connect(ctx context.Context) error {
+	if t.disabled {
+		return nil
+	}
 f:
 	for {
 		select {
+		case <-ctx.Done():
+			break f
 		case s := <-t.sigChan:
 			t.sigChan <- s
 			break f
 		default:
-			t.connect()
+			t.connect(ctx)
 			// если коннект прервался - запустим таймаут перед реконнектом
-			time.Sleep(time.Second * 5)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 5):
+			}
 		}
 	}
+
+	return nil
 }
 
+// Stop stops Connect, it may be called several times
 func (t *TelegramBot) Stop() {
 	if t.disabled {
 		return
 	}
-	t.sigChan <- true
+	select {
+	case t.sigChan <- true:
+	default:
+	}
 }
 
-func (t *TelegramBot) connect() {
+func (t *TelegramBot) connect(ctx context.Context) {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Warnf("Recovered in connect: %s ", r)
@@ -102,6 +257,8 @@ func (t *TelegramBot) connect() {
 	// читаем обновления из канала
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case s := <-t.sigChan:
 			t.sigChan <- s
 			return
@@ -111,7 +268,32 @@ func (t *TelegramBot) connect() {
 			msg := tgbotapi.NewMessage(t.chatID, message)
 			// и отправляем его
 			bot.Send(msg)
-
+		case message := <-t.directToTelegram:
+			id, ok := t.recipientID(message)
+			if !ok {
+				logrus.Warnf("Unknown Telegram user %s, private message dropped", message.To)
+				continue
+			}
+			logrus.Infof("Sending private message to Telegram user %s: %s", message.To, message.Text)
+			_, err := bot.Send(tgbotapi.NewMessage(id, message.Text))
+			if err != nil {
+				logrus.Errorf("Send private message error: %s", err)
+			}
+		case message := <-t.adminToTelegram:
+			logrus.Infof("Sending message to Telegram admin chat: %s", message)
+			_, err := bot.Send(tgbotapi.NewMessage(t.adminChatID, message))
+			if err != nil {
+				logrus.Errorf("Send admin message error: %s", err)
+			}
+		case topic := <-t.topicsToTelegram:
+			logrus.Infof("Setting Telegram chat description: %s", topic)
+			_, err := bot.SetChatDescription(tgbotapi.SetChatDescriptionConfig{
+				ChatID:      t.chatID,
+				Description: topic,
+			})
+			if err != nil {
+				logrus.Errorf("SetChatDescription error: %s", err)
+			}
 		case update := <-updates:
 
 			if update.Message == nil {
@@ -136,7 +318,11 @@ func (t *TelegramBot) connect() {
 				UserName = update.Message.From.FirstName
 			}
 
-			logrus.Infof("Message received: [%s] %d %s", UserName, ChatID, Text)
+			logrus.Infof("Message received: [%s, ID %d] %d %s", UserName, update.Message.From.ID, ChatID, Text)
+
+			t.addUser(update.Message.From.UserName, int64(update.Message.From.ID))
+			// у пользователя может не быть @username, ответы ему отправляются по ID
+			userID := strconv.Itoa(update.Message.From.ID)
 
 			i := strings.Index(Text, "@"+bot.Self.UserName)
 
@@ -162,12 +348,7 @@ func (t *TelegramBot) connect() {
 						reply = reply + k + " "
 					}
 					for k, m := range t.Mounts() {
-						users := strings.Join(m, ", ")
-						if users != "" {
-							reply = reply + "\nНа сервере " + k + " играют: " + users
-						} else {
-							reply = reply + "\nНа сервере " + k + " никого нет. "
-						}
+						reply = reply + "\n" + m.Describe(k)
 					}
 				} else {
 					reply = "Нет активных серверов!"
@@ -179,20 +360,58 @@ func (t *TelegramBot) connect() {
 				bot.Send(msg)
 
 			case ok:
-				users := strings.Join(mount, ", ")
-				reply := ""
-				if users != "" {
-					reply = "На сервере " + Text + " играют: " + users
-				} else {
-					reply = "На сервере " + Text + " никого нет. "
-				}
+				reply := mount.Describe(Text)
 				// Созадаем сообщение
 				msg := tgbotapi.NewMessage(ChatID, reply)
 				// и отправляем его
 				bot.Send(msg)
+			case strings.HasPrefix(Text, "msg "):
+				to, text, ok := models.SplitRecipient(strings.TrimPrefix(Text, "msg "))
+				if !ok {
+					bot.Send(tgbotapi.NewMessage(ChatID, "Формат команды: /msg NINJAM_USER ТЕКСТ"))
+					continue
+				}
+
+				m := models.Message{
+					Type: models.PRIVMSG,
+					Name: UserName,
+					To:   to,
+					Text: text,
+				}
+
+				t.messagesFromTelegram <- models.Envelope{Message: m, UserID: userID}
+			case strings.HasPrefix(Text, "topic "):
+				if !t.isAdmin(int64(update.Message.From.ID)) {
+					bot.Send(tgbotapi.NewMessage(ChatID, "Менять тему джем-сервера могут только администраторы бота"))
+					continue
+				}
+
+				m := models.Message{
+					Type: models.TOPIC,
+					Name: UserName,
+					Text: strings.TrimSpace(strings.TrimPrefix(Text, "topic ")),
+				}
+
+				t.messagesFromTelegram <- models.Envelope{Message: m, Private: update.Message.Chat.IsPrivate(), UserID: userID}
+			case Text == "record start" || Text == "record stop":
+				if !t.isAdmin(int64(update.Message.From.ID)) {
+					bot.Send(tgbotapi.NewMessage(ChatID, "Управлять записью джем-серверов могут только администраторы бота"))
+					continue
+				}
+
+				m := models.Message{
+					Type: models.RECORD,
+					Name: UserName,
+					Text: strings.TrimPrefix(Text, "record "),
+				}
+
+				t.messagesFromTelegram <- models.Envelope{Message: m, Private: update.Message.Chat.IsPrivate(), UserID: userID}
 			case Text == "help":
 				reply := "Сайт джем-серверов с информацией об адресах находится по адресу http://guitar-jam.ru\n"
-				reply = reply + "Подробнее о джем-серверах, подключении к ним и по остальным вопросам читайте тему http://forum.gitarizm.ru/showthread.php?t=39731 и задавайте вопросы там или в этом чате."
+				reply = reply + "Подробнее о джем-серверах, подключении к ним и по остальным вопросам читайте тему http://forum.gitarizm.ru/showthread.php?t=39731 и задавайте вопросы там или в этом чате.\n"
+				reply = reply + "Личное сообщение пользователю джем-сервера: /msg НИК ТЕКСТ\n"
+				reply = reply + "Тема джем-сервера (только для администраторов): /topic ПОРТ_СЕРВЕРА ТЕКСТ\n"
+				reply = reply + "Запись джем-серверов (только для администраторов): /record start, /record stop"
 
 				// Созадаем сообщение
 				msg := tgbotapi.NewMessage(ChatID, reply)
@@ -207,19 +426,9 @@ func (t *TelegramBot) connect() {
 					Text: Text,
 				}
 
-				t.messagesFromTelegram <- m
+				t.messagesFromTelegram <- models.Envelope{Message: m, UserID: userID}
 			}
 		}
 
 	}
 }
-
-type Status struct {
-	Mount []Mount `xml:"mount"`
-}
-
-type Mount struct {
-	Name      string `xml:"name"`
-	Listeners string `xml:"listeners"`
-	Users     string `xml:"users"`
-}